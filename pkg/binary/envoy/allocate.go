@@ -0,0 +1,200 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/log"
+)
+
+// maxAdminPortTries is how many free ports are tried for the admin listener of Envoy before giving up.
+const maxAdminPortTries = 10
+
+// baseIDArgs are Envoy args that decide how Envoy tells its shared memory apart from the one of other instances.
+var baseIDArgs = []string{"--base-id", "--use-dynamic-base-id", "--disable-hot-restart"}
+
+// allocateInstance picks what sets the instance apart from the other ones that are running,
+// i.e. its name, the port of its admin listener and its base ID.
+func (r *Runtime) allocateInstance(opts InstanceOptions) (*Instance, error) {
+	others, err := instancesIn(stateDir(r.RootDir))
+	if err != nil {
+		return nil, err
+	}
+	name, err := allocateName(opts.Name, others)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AllocateAdminPort {
+		if err := r.allocateAdminPort(others); err != nil {
+			return nil, err
+		}
+	}
+	instance := &Instance{
+		ID:           opts.ID,
+		Name:         name,
+		PID:          os.Getpid(),
+		Reference:    r.reference,
+		AdminAddress: r.AdminAddress(),
+		BaseID:       r.allocateBaseID(opts.AllocateBaseID, others),
+		DebugStore:   r.DebugStore(),
+		AccessLog:    AccessLogPath(r.DebugStore()),
+		ErrorLog:     ErrorLogPath(r.DebugStore()),
+		Started:      time.Now().UTC(),
+	}
+	if instance.Reference == "" {
+		instance.Reference = r.cmd.Path
+	}
+	return instance, nil
+}
+
+// allocateName returns the passed name unless another instance goes by it, or otherwise the first free default one.
+func allocateName(name string, others []*Instance) (string, error) {
+	taken := make(map[string]bool, 2*len(others))
+	for _, other := range others {
+		taken[other.ID] = true
+		taken[other.Name] = true
+	}
+	if name != "" {
+		if taken[name] {
+			return "", fmt.Errorf("there is already an Envoy instance %q running", name)
+		}
+		return name, nil
+	}
+	for i := 1; ; i++ {
+		if name := fmt.Sprintf("envoy-%d", i); !taken[name] {
+			return name, nil
+		}
+	}
+}
+
+// allocateAdminPort moves the admin listener of Envoy to a free port if the one in Config is taken,
+// either by a process listening on it or by another instance that is about to.
+// The port is overridden by `--config-yaml`, which Envoy merges into its bootstrap, and a bootstrap generated
+// by GetEnvoy uses it as well. A bootstrap of the user, given by `--config-path`, decides the port on its own.
+func (r *Runtime) allocateAdminPort(others []*Instance) error {
+	address := r.Config.GetAdminAddress()
+	if address == "" {
+		return nil // Envoy has no admin listener
+	}
+	if r.userBootstrap {
+		return nil // the admin listener is up to the bootstrap of the user
+	}
+	taken := make(map[int32]bool, len(others))
+	for _, other := range others {
+		if _, port, err := net.SplitHostPort(other.AdminAddress); err == nil {
+			if p, err := strconv.Atoi(port); err == nil {
+				taken[int32(p)] = true
+			}
+		}
+	}
+	if !taken[r.Config.AdminPort] && portFree(address) {
+		return nil
+	}
+	if hasArg(r.cmd.Args[1:], "--config-yaml") {
+		log.Warnf("Admin address %v of Envoy is taken, but it can't be changed as --config-yaml is set", address)
+		return nil
+	}
+	host, _, _ := net.SplitHostPort(address)
+	for tries := 0; ; tries++ {
+		if tries == maxAdminPortTries {
+			return fmt.Errorf("unable to allocate a port for the admin listener of Envoy: "+
+				"all %d free ports tried are taken by other Envoy instances", maxAdminPortTries)
+		}
+		port, err := freePort(host)
+		if err != nil {
+			return fmt.Errorf("unable to allocate a port for the admin listener of Envoy: %v", err)
+		}
+		if !taken[port] {
+			log.Infof("Admin address %v of Envoy is taken, using port %d instead", address, port)
+			r.Config.AdminPort = port
+			break
+		}
+	}
+	ip := r.Config.AdminAddress
+	if ip == "" {
+		ip = "127.0.0.1"
+	}
+	r.cmd.Args = append(r.cmd.Args, "--config-yaml",
+		fmt.Sprintf("admin: {address: {socket_address: {address: %q, port_value: %d}}}", ip, r.Config.AdminPort))
+	return nil
+}
+
+// allocateBaseID returns the `--base-id` Envoy is run with, which is the lowest one no other instance uses if
+// allocate is set, or -1 if it isn't decided by GetEnvoy.
+func (r *Runtime) allocateBaseID(allocate bool, others []*Instance) int {
+	for _, arg := range baseIDArgs {
+		if hasArg(r.cmd.Args[1:], arg) {
+			return -1
+		}
+	}
+	if !allocate {
+		if r.HotRestart.Enabled {
+			return r.HotRestart.BaseID
+		}
+		return -1
+	}
+	taken := make(map[int]bool, len(others))
+	for _, other := range others {
+		taken[other.BaseID] = true
+	}
+	id := 0
+	for taken[id] {
+		id++
+	}
+	r.HotRestart.BaseID = id
+	r.baseIDAssigned = true
+	return id
+}
+
+// hasBootstrap checks whether args run Envoy with a bootstrap file, i.e. `--config-path` or `-c`.
+func hasBootstrap(args []string) bool {
+	return hasArg(args, "--config-path") || hasArg(args, "-c")
+}
+
+// hasArg checks whether the flag is among args, either on its own or with its value after `=`.
+func hasArg(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// portFree checks whether a listener can be bound to the address.
+func portFree(address string) bool {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	l.Close() //nolint
+	return true
+}
+
+// freePort returns a port on the host that no listener is bound to.
+var freePort = func(host string) (int32, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close() //nolint
+	return int32(l.Addr().(*net.TCPAddr).Port), nil
+}
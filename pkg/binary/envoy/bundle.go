@@ -0,0 +1,163 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archiver"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+const (
+	// bundleManifest is the name of the file that describes builds in a bundle
+	bundleManifest = "manifest.json"
+	// bundleChecksums is the name of the file that lists checksums of build archives in a bundle in `sha256sum` format
+	bundleChecksums = "SHA256SUMS"
+	// bundleBuilds is the name of the directory that holds build archives in a bundle
+	bundleBuilds = "builds"
+)
+
+// ExportBundle packages the downloaded builds with the passed keys into a bundle at dst, so that they can be
+// moved to a machine without internet access.
+// Besides the archives the builds were installed from, the bundle has a manifest describing them, based on the passed
+// one, and their checksums. Archives are passed on as is along with the checksums and signatures they were verified
+// against, so that they are verified on the other machine exactly like a download would be.
+// An extracted bundle is a valid manifest directory on its own.
+func (r *Runtime) ExportBundle(dst string, source *manifest.Manifest, keys ...*manifest.Key) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%v already exists", dst)
+	}
+	tmpDir, err := r.tempDir("bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir) //nolint
+
+	bundle := source.Subset(keys...)
+	var checksums bytes.Buffer
+	for _, key := range keys {
+		if !r.AlreadyDownloaded(key) {
+			return fmt.Errorf("%v is not downloaded, fetch it first", key)
+		}
+		original, digest, err := r.original(key)
+		if err != nil {
+			return fmt.Errorf("%v was installed without keeping its archive, remove it from the cache and fetch it again to export it", key)
+		}
+		name := path.Join(bundleBuilds, key.Flavor, key.Version, platformDirName(key)+archiveExt(original))
+		archive := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(archive), 0750); err != nil {
+			return err
+		}
+		if err := copyFile(original, archive, 0600); err != nil {
+			return fmt.Errorf("unable to copy the archive of %v: %v", key, err)
+		}
+		digest = signedDigest(source, key, digest)
+		bundle.SetBuild(key, &manifest.Build{DownloadLocationURL: name, Digest: *digest})
+		fmt.Fprintf(&checksums, "%s  %s\n", digest.SHA256, name)
+	}
+	data, err := bundle.Encode()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, bundleManifest), data, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, bundleChecksums), checksums.Bytes(), 0600); err != nil {
+		return err
+	}
+	sources := []string{
+		filepath.Join(tmpDir, bundleManifest),
+		filepath.Join(tmpDir, bundleChecksums),
+		filepath.Join(tmpDir, bundleBuilds),
+	}
+	if err := archiver.Archive(sources, dst); err != nil {
+		return fmt.Errorf("unable to create bundle %v: %v", dst, err)
+	}
+	return nil
+}
+
+// ImportBundle installs builds from the bundle at src into the binary store and returns their keys.
+// Every build archive is verified against the bundle manifest, exactly like a download would be.
+func (r *Runtime) ImportBundle(src string) ([]*manifest.Key, error) {
+	tmpDir, err := r.tempDir("bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir) //nolint
+	// the bundle is as untrusted as any download
+	if err := extractArchive(tmpDir, src, archivePath); err != nil {
+		return nil, fmt.Errorf("unable to extract bundle %v: %v", src, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, bundleManifest)); err != nil {
+		return nil, fmt.Errorf("%v is not a GetEnvoy bundle: %v is missing", src, bundleManifest)
+	}
+	bundle, err := manifest.LoadLocation(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	keys := bundle.Keys()
+	for i, key := range keys {
+		build, err := manifest.LocateBuild(key, bundle)
+		if err != nil {
+			return keys[:i], err
+		}
+		if err := r.Fetch(key, build); err != nil {
+			return keys[:i], err
+		}
+	}
+	return keys, nil
+}
+
+// signedDigest returns the integrity metadata of the passed manifest for the archive with the checksum of the passed
+// one, which has the signature of the archive if it was installed before the manifest had it, or the passed one
+func signedDigest(source *manifest.Manifest, key *manifest.Key, digest *manifest.Digest) *manifest.Digest {
+	if digest.Signature != "" || source.Manifest == nil {
+		return digest
+	}
+	build, err := manifest.LocateBuild(key, source)
+	if err != nil {
+		return digest
+	}
+	for _, location := range build.Locations() {
+		if located := build.At(location).Digest; located.Signature != "" && strings.EqualFold(located.SHA256, digest.SHA256) {
+			return &located
+		}
+	}
+	return digest
+}
+
+func sha256Hex(path string) (string, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
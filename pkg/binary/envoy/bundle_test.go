@@ -0,0 +1,160 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archiver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+func TestRuntime_ExportAndImportBundle(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "LINUX_GLIBC"}
+	bundle := filepath.Join(tmpDir, "bundle.tar.gz")
+	public, private, _ := ed25519.GenerateKey(nil)
+
+	online := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "online")}}
+	err := online.ExportBundle(bundle, &manifest.Manifest{}, key)
+	assert.Error(t, err, "expected builds that are not downloaded to be rejected")
+
+	createLocalFile(filepath.Join(online.platformDirectory(key), envoyLocation))
+	err = online.ExportBundle(bundle, &manifest.Manifest{}, key)
+	assert.Error(t, err, "expected builds without the archive they were installed from to be rejected")
+
+	require.NoError(t, NewBuildCache(online.BinaryStore()).Remove(key))
+	tarball := signedTarball(t, tmpDir, private)
+	require.NoError(t, online.Fetch(key, &manifest.Build{DownloadLocationURL: "file://" + filepath.ToSlash(tarball.path), Digest: tarball.digest}))
+	require.NoError(t, online.ExportBundle(bundle, &manifest.Manifest{}, key))
+	assert.Error(t, online.ExportBundle(bundle, &manifest.Manifest{}, key), "expected an existing bundle not to be overwritten")
+
+	extracted := filepath.Join(tmpDir, "extracted")
+	require.NoError(t, archiver.Unarchive(bundle, extracted))
+	checksums, _ := ioutil.ReadFile(filepath.Join(extracted, bundleChecksums))
+	assert.Equal(t, tarball.digest.SHA256+"  builds/standard/1.11.0/linux_glibc.tar.gz\n", string(checksums))
+	assert.Equal(t, tarball.digest.SHA256, sha256Of(filepath.Join(extracted, "builds/standard/1.11.0/linux_glibc.tar.gz")),
+		"expected the original archive to be exported")
+
+	manifest.SetOffline(true)
+	defer manifest.SetOffline(false)
+	manifest.SetTrustedKeys(public)
+	defer manifest.SetTrustedKeys()
+	offline := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "offline")}}
+	keys, err := offline.ImportBundle(bundle)
+	require.NoError(t, err, "expected the signature of the archive to be exported along with it")
+	assert.Equal(t, []*manifest.Key{key}, keys)
+	f, _ := ioutil.ReadFile(filepath.Join(offline.platformDirectory(key), envoyLocation))
+	assert.Contains(t, string(f), "some c++")
+}
+
+func TestRuntime_ExportBundleCarriesOverSignatures(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "DARWIN"}
+	bundle := filepath.Join(tmpDir, "bundle.tar.gz")
+	_, private, _ := ed25519.GenerateKey(nil)
+	tarball := signedTarball(t, tmpDir, private)
+	location := "file://" + filepath.ToSlash(tarball.path)
+
+	// the build was fetched before the manifest had a signature for it
+	online := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "online")}}
+	require.NoError(t, online.Fetch(key, &manifest.Build{DownloadLocationURL: location, Digest: manifest.Digest{SHA256: tarball.digest.SHA256}}))
+	source := &manifest.Manifest{}
+	source.SetBuild(key, &manifest.Build{DownloadLocationURL: location, Digest: tarball.digest})
+	require.NoError(t, online.ExportBundle(bundle, source, key))
+
+	extracted := filepath.Join(tmpDir, "extracted")
+	require.NoError(t, archiver.Unarchive(bundle, extracted))
+	exported, err := manifest.LoadLocation(extracted)
+	require.NoError(t, err)
+	build, err := manifest.LocateBuild(key, exported)
+	require.NoError(t, err)
+	assert.Equal(t, tarball.digest, build.Digest)
+}
+
+func TestRuntime_ImportBundleVerifiesChecksums(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "DARWIN"}
+	bundle := filepath.Join(tmpDir, "bundle.tar.gz")
+
+	online := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "online")}}
+	tarball := signedTarball(t, tmpDir, nil)
+	require.NoError(t, online.Fetch(key, &manifest.Build{DownloadLocationURL: "file://" + filepath.ToSlash(tarball.path), Digest: tarball.digest}))
+	require.NoError(t, online.ExportBundle(bundle, &manifest.Manifest{}, key))
+
+	extracted := filepath.Join(tmpDir, "extracted")
+	require.NoError(t, archiver.Unarchive(bundle, extracted))
+	tampered := filepath.Join(tmpDir, "tampered.tar.gz")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(extracted, "builds/standard/1.11.0/darwin.tar.gz"), []byte("tampered"), 0600))
+	require.NoError(t, archiver.Archive([]string{
+		filepath.Join(extracted, bundleManifest), filepath.Join(extracted, bundleChecksums), filepath.Join(extracted, bundleBuilds),
+	}, tampered))
+
+	offline := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "offline")}}
+	keys, err := offline.ImportBundle(tampered)
+	assert.Error(t, err)
+	assert.Empty(t, keys)
+	assert.False(t, offline.AlreadyDownloaded(key))
+}
+
+func TestRuntime_ImportBundleRejectsUnsafeEntries(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	bundle := filepath.Join(tmpDir, "bundle.tar.gz")
+	writeTarball(t, bundle, []*tar.Header{
+		{Name: bundleManifest, Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "builds/../../outside", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+
+	r := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "store")}}
+	_, err := r.ImportBundle(bundle)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parent directory references are not allowed")
+}
+
+// testTarball is an Envoy archive along with its integrity metadata
+type testTarball struct {
+	path   string
+	digest manifest.Digest
+}
+
+// signedTarball creates an Envoy archive signed with the passed key, unless it is nil
+func signedTarball(t *testing.T, dir string, key ed25519.PrivateKey) *testTarball {
+	tarball := filepath.Join(dir, "envoy.tar.gz")
+	require.NoError(t, archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball))
+	sum := sha256.New()
+	data, err := ioutil.ReadFile(tarball)
+	require.NoError(t, err)
+	sum.Write(data)
+	digest := manifest.Digest{SHA256: hex.EncodeToString(sum.Sum(nil))}
+	if key != nil {
+		digest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, sum.Sum(nil)))
+	}
+	return &testTarball{path: tarball, digest: digest}
+}
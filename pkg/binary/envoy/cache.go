@@ -0,0 +1,240 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/common"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+const (
+	// lastUsedFile is touched every time a build is fetched or run
+	lastUsedFile = ".last-used"
+	// inUsePrefix prefixes marker files that hold the pids of processes running a build
+	inUsePrefix = ".in-use-"
+)
+
+// CachedBuild describes an Envoy build present in the binary store.
+type CachedBuild struct {
+	Key      *manifest.Key
+	Path     string
+	Size     int64
+	LastUsed time.Time
+	// Pids of the processes that are currently running the build
+	Pids []int
+}
+
+// InUse returns true if the build is currently being run.
+func (b *CachedBuild) InUse() bool {
+	return len(b.Pids) > 0
+}
+
+// BuildCache manages Envoy builds persisted in a binary store, e.g. `~/.getenvoy/builds`.
+type BuildCache struct {
+	store string
+}
+
+// NewBuildCache returns a BuildCache of the binary store at the given location.
+func NewBuildCache(binaryStore string) *BuildCache {
+	return &BuildCache{store: filepath.Clean(binaryStore)}
+}
+
+// DefaultBuildCache returns the BuildCache of the binary store in the GetEnvoy home directory.
+func DefaultBuildCache() *BuildCache {
+	return NewBuildCache(binaryStore(common.HomeDir))
+}
+
+// List returns all builds in the binary store, most recently used first.
+func (c *BuildCache) List() ([]*CachedBuild, error) {
+	binaries, err := filepath.Glob(filepath.Join(c.store, "*", "*", "*", envoyLocation))
+	if err != nil {
+		return nil, err
+	}
+	builds := make([]*CachedBuild, 0, len(binaries))
+	for _, binary := range binaries {
+		dir := filepath.Dir(filepath.Dir(binary))
+		build, err := inspectBuild(dir)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[i].LastUsed.After(builds[j].LastUsed)
+	})
+	return builds, nil
+}
+
+// Has returns true if there is a build in the binary store that matches the passed key.
+func (c *BuildCache) Has(key *manifest.Key) bool {
+	_, err := os.Stat(filepath.Join(c.dir(key), envoyLocation))
+	return err == nil
+}
+
+// Get returns the build in the binary store that matches the passed key.
+func (c *BuildCache) Get(key *manifest.Key) (*CachedBuild, error) {
+	dir := c.dir(key)
+	if _, err := os.Stat(filepath.Join(dir, envoyLocation)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%v is not in the cache", key)
+		}
+		return nil, err
+	}
+	return inspectBuild(dir)
+}
+
+func (c *BuildCache) dir(key *manifest.Key) string {
+	return filepath.Join(c.store, key.Flavor, key.Version, platformDirName(key))
+}
+
+// Remove deletes the build matching the passed key unless it is currently being run.
+func (c *BuildCache) Remove(key *manifest.Key) error {
+	build, err := c.Get(key)
+	if err != nil {
+		return err
+	}
+	return c.remove(build)
+}
+
+// Prune deletes builds that haven't been used for longer than olderThan,
+// sparing the keep most recently used ones as well as the ones currently being run.
+// Zero values of keep and olderThan disable the respective criteria.
+func (c *BuildCache) Prune(keep int, olderThan time.Duration) ([]*CachedBuild, error) {
+	builds, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	if keep > len(builds) {
+		keep = len(builds)
+	}
+	removed := make([]*CachedBuild, 0)
+	cutoff := time.Now().Add(-olderThan)
+	for _, build := range builds[keep:] {
+		if build.InUse() || (olderThan > 0 && build.LastUsed.After(cutoff)) {
+			continue
+		}
+		if err := c.remove(build); err != nil {
+			return removed, err
+		}
+		removed = append(removed, build)
+	}
+	return removed, nil
+}
+
+func (c *BuildCache) remove(build *CachedBuild) error {
+	if build.InUse() {
+		return fmt.Errorf("%v is in use by process %v", build.Key, build.Pids[0])
+	}
+	if err := os.RemoveAll(build.Path); err != nil {
+		return err
+	}
+	// clean up version and flavor directories that became empty
+	for dir := filepath.Dir(build.Path); dir != c.store && strings.HasPrefix(dir, c.store); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func inspectBuild(dir string) (*CachedBuild, error) {
+	platform := filepath.Base(dir)
+	version := filepath.Base(filepath.Dir(dir))
+	flavor := filepath.Base(filepath.Dir(filepath.Dir(dir)))
+	build := &CachedBuild{
+		Key:  &manifest.Key{Flavor: flavor, Version: version, Platform: strings.ToUpper(platform)},
+		Path: dir,
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			build.Size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	build.LastUsed = lastUsed(dir)
+	build.Pids = activePids(dir)
+	return build, nil
+}
+
+// lastUsed falls back to the time the build directory was created for builds fetched before usage tracking
+func lastUsed(dir string) time.Time {
+	for _, path := range []string{filepath.Join(dir, lastUsedFile), dir} {
+		if info, err := os.Stat(path); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// activePids returns pids of live processes that marked the build as in use; stale markers are cleaned up
+func activePids(dir string) []int {
+	markers, _ := filepath.Glob(filepath.Join(dir, inUsePrefix+"*"))
+	pids := make([]int, 0, len(markers))
+	for _, marker := range markers {
+		pid, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(marker), inUsePrefix))
+		if err == nil {
+			if exists, _ := process.PidExists(int32(pid)); exists {
+				pids = append(pids, pid)
+				continue
+			}
+		}
+		os.Remove(marker) //nolint
+	}
+	return pids
+}
+
+// touchBuild records that the build in the passed directory has just been used
+func touchBuild(dir string) {
+	path := filepath.Join(dir, lastUsedFile)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return
+	}
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		log.Debugf("unable to record usage of %v: %v", dir, err)
+	}
+}
+
+// markInUse records that the build in the passed directory is being run by this process
+// The returned function releases the mark and must be called once the build is no longer used
+func markInUse(dir string) func() {
+	touchBuild(dir)
+	marker := filepath.Join(dir, inUsePrefix+strconv.Itoa(os.Getpid()))
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		log.Debugf("unable to mark %v as in use: %v", dir, err)
+	}
+	return func() {
+		os.Remove(marker) //nolint
+		touchBuild(dir)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+func TestBuildCache(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	r := &Runtime{fetcher: fetcher{store: tmpDir}}
+
+	now := time.Now()
+	old := &manifest.Key{Flavor: "standard", Version: "1.10.0", Platform: "LINUX_GLIBC"}
+	recent := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "LINUX_GLIBC"}
+	running := &manifest.Key{Flavor: "standard", Version: "1.12.0", Platform: "DARWIN"}
+	for key, lastUsed := range map[*manifest.Key]time.Time{
+		old:     now.Add(-60 * 24 * time.Hour),
+		recent:  now.Add(-time.Hour),
+		running: now.Add(-90 * 24 * time.Hour),
+	} {
+		dir := r.platformDirectory(key)
+		createLocalFile(filepath.Join(dir, envoyLocation))
+		touchBuild(dir)
+		os.Chtimes(filepath.Join(dir, lastUsedFile), lastUsed, lastUsed)
+	}
+	release := markInUse(r.platformDirectory(running))
+	// markInUse refreshes the last used time on its own
+	os.Chtimes(filepath.Join(r.platformDirectory(running), lastUsedFile), now.Add(-90*24*time.Hour), now.Add(-90*24*time.Hour))
+
+	cache := NewBuildCache(r.BinaryStore())
+
+	builds, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, builds, 3)
+	assert.Equal(t, []string{"standard:1.11.0/linux-glibc", "standard:1.10.0/linux-glibc", "standard:1.12.0/darwin"},
+		[]string{builds[0].Key.String(), builds[1].Key.String(), builds[2].Key.String()})
+	assert.Equal(t, int64(len("some c++")), builds[0].Size)
+	assert.Equal(t, []int{os.Getpid()}, builds[2].Pids)
+
+	err = cache.Remove(running)
+	assert.Error(t, err, "expected builds in use to be kept")
+
+	removed, err := cache.Prune(0, 30*24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, old.String(), removed[0].Key.String())
+	_, err = os.Stat(filepath.Join(r.BinaryStore(), "standard", "1.10.0"))
+	assert.True(t, os.IsNotExist(err), "expected empty version directory to be removed")
+
+	release()
+	_, err = os.Stat(filepath.Join(r.platformDirectory(running), inUsePrefix+strconv.Itoa(os.Getpid())))
+	assert.True(t, os.IsNotExist(err), "expected in use marker to be removed")
+
+	removed, err = cache.Prune(1, 0)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, recent.String(), removed[0].Key.String(), "expected the most recently used build to be kept")
+
+	_, err = cache.Get(recent)
+	assert.Error(t, err)
+	assert.NoError(t, cache.Remove(running))
+	builds, err = cache.List()
+	require.NoError(t, err)
+	assert.Empty(t, builds)
+}
@@ -15,6 +15,7 @@
 package controlplane
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -52,11 +53,22 @@ func Istio(r *envoy.Runtime) {
 		}
 		r.Config.IPAddresses = ips
 	}
-	r.RegisterPreStart(writeBootstrap)
-	r.RegisterPreStart(appendArgs)
+	// Envoy can't run without the bootstrap, so it is not started if the bootstrap can't be written
+	r.RegisterHook(envoy.Hook{
+		Name:      "istio-bootstrap",
+		Phase:     envoy.PreStart,
+		OnFailure: envoy.AbortOnFailure,
+		Run:       writeBootstrap,
+	}, envoy.Hook{
+		Name:      "istio-args",
+		Phase:     envoy.PreStart,
+		Order:     1,
+		OnFailure: envoy.AbortOnFailure,
+		Run:       appendArgs,
+	})
 }
 
-func appendArgs(r binary.Runner) error {
+func appendArgs(_ context.Context, r binary.Runner) error {
 	// Type assert as we're using Envoy specific config
 	e, ok := r.(*envoy.Runtime)
 	if !ok {
@@ -82,7 +94,7 @@ func convertDuration(d *durationpb.Duration) time.Duration {
 	return dur
 }
 
-func writeBootstrap(r binary.Runner) error {
+func writeBootstrap(_ context.Context, r binary.Runner) error {
 	// Type assert as we're using Envoy specific config
 	e, ok := r.(*envoy.Runtime)
 	if !ok {
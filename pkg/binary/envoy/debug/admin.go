@@ -57,9 +57,14 @@ func retrieveAdminAPIData(r binary.Runner) error {
 		log.Warnf("unable to capture Envoy configuration and metrics since Envoy Admin listener is not enabled")
 		return nil
 	}
+	address := e.AdminAddress()
+	if address == "" {
+		log.Warnf("unable to capture Envoy configuration and metrics since the address of Envoy Admin listener is up to the bootstrap of the user")
+		return nil
+	}
 	var multiErr *multierror.Error
 	for path, file := range adminAPIPaths {
-		resp, err := http.Get(fmt.Sprintf("http://%s/%v", e.Config.GetAdminAddress(), path))
+		resp, err := http.Get(fmt.Sprintf("http://%s/%v", address, path))
 		if err != nil {
 			multiErr = multierror.Append(multiErr, err)
 			continue
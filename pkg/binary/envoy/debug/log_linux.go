@@ -27,7 +27,7 @@ import (
 
 // EnableEnvoyLogCollection is a preset option that registers collection of Envoy access logs and stderr
 func EnableEnvoyLogCollection(r *envoy.Runtime) {
-	if err := os.MkdirAll(filepath.Join(r.DebugStore(), "logs"), os.ModePerm); err != nil {
+	if err := os.MkdirAll(filepath.Dir(envoy.AccessLogPath(r.DebugStore())), os.ModePerm); err != nil {
 		log.Errorf("unable to create directory to write logs to, no logs will be captured: %v", err)
 		return
 	}
@@ -36,7 +36,7 @@ func EnableEnvoyLogCollection(r *envoy.Runtime) {
 }
 
 func captureStdout(r binary.Runner) error {
-	f, err := createLogFile(filepath.Join(r.DebugStore(), "logs", "access.log"))
+	f, err := createLogFile(envoy.AccessLogPath(r.DebugStore()))
 	if err != nil {
 		return err
 	}
@@ -51,7 +51,7 @@ func captureStdout(r binary.Runner) error {
 }
 
 func captureStderr(r binary.Runner) error {
-	f, err := createLogFile(filepath.Join(r.DebugStore(), "logs", "error.log"))
+	f, err := createLogFile(envoy.ErrorLogPath(r.DebugStore()))
 	if err != nil {
 		return err
 	}
@@ -15,6 +15,7 @@
 package debug
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -36,12 +37,36 @@ func EnableNodeCollection(r *envoy.Runtime) {
 		log.Errorf("unable to create directory to write node data to: %v", err)
 		return
 	}
-	r.RegisterPreTermination(ps)
-	r.RegisterPreTermination(networkInterfaces)
-	r.RegisterPreTermination(activeConnections)
+	// the data is collected while Envoy is still running, so that it shows the Envoy process and its connections,
+	// unless Envoy exits on its own, e.g. it crashed, which skips pre-termination hooks, so it is collected once it has
+	collected := false
+	for _, hook := range []envoy.Hook{
+		{Name: "ps", Run: ps},
+		{Name: "network-interfaces", Run: networkInterfaces},
+		{Name: "active-connections", Run: activeConnections},
+	} {
+		collect := hook.Run
+		r.RegisterHook(envoy.Hook{
+			Name:  hook.Name,
+			Phase: envoy.PreTermination,
+			Run: func(ctx context.Context, r binary.Runner) error {
+				collected = true
+				return collect(ctx, r)
+			},
+		}, envoy.Hook{
+			Name:  hook.Name,
+			Phase: envoy.PostExit,
+			Run: func(ctx context.Context, r binary.Runner) error {
+				if _, err := r.GetPid(); collected || err != nil {
+					return nil // already collected, or Envoy was never started
+				}
+				return collect(ctx, r)
+			},
+		})
+	}
 }
 
-func ps(r binary.Runner) error {
+func ps(_ context.Context, r binary.Runner) error {
 	f, err := os.Create(filepath.Join(r.DebugStore(), "node/ps.txt"))
 	if err != nil {
 		return fmt.Errorf("unable to create file to write ps output to: %v", err)
@@ -124,7 +149,7 @@ func safeProc(p *process.Process) *proc {
 	}
 }
 
-func networkInterfaces(r binary.Runner) error {
+func networkInterfaces(_ context.Context, r binary.Runner) error {
 	f, err := os.Create(filepath.Join(r.DebugStore(), "node/network_interface.json"))
 	if err != nil {
 		return fmt.Errorf("unable to create file to write network interface output to: %v", err)
@@ -166,7 +191,7 @@ var typeMap = map[uint32]string{
 	syscall.SOCK_DGRAM:  "SOCK_DGRAM",
 }
 
-func activeConnections(r binary.Runner) error {
+func activeConnections(_ context.Context, r binary.Runner) error {
 	f, err := os.Create(filepath.Join(r.DebugStore(), "node/connections.json"))
 	if err != nil {
 		return fmt.Errorf("unable to create file to write network interface output to: %v", err)
@@ -20,9 +20,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/mholt/archiver"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
 	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
 	"github.com/tetratelabs/getenvoy/pkg/binary/envoytest"
 )
@@ -34,29 +38,109 @@ func Test_debugging_outputs(t *testing.T) {
 		defer os.RemoveAll(r.DebugStore())
 		envoytest.RunKill(r, filepath.Join("testdata", "null.yaml"), time.Second*10)
 
-		files := [...]string{"node/ps.txt", "node/network_interface.json", "node/connections.json"}
-		for _, file := range files {
-			path := filepath.Join(r.DebugStore(), file)
-			f, err := os.Stat(path)
+		checkNodeFiles(t, r.DebugStore())
+	})
+
+	t.Run("shows Envoy while it is running once it was stopped", func(t *testing.T) {
+		r, _ := envoy.NewRuntime(EnableNodeCollection)
+		defer os.RemoveAll(r.DebugStore() + ".tar.gz")
+		defer os.RemoveAll(r.DebugStore())
+		done := make(chan error, 1)
+		go func() {
+			done <- r.RunPath(filepath.Join("..", "testdata", "sleep.sh"), nil)
+		}()
+		waitForPid(t, r)
+		time.Sleep(100 * time.Millisecond) // give the script a chance to set up its traps
+		r.SendSignal(syscall.SIGINT)
+		select {
+		case err := <-done:
 			if err != nil {
-				t.Errorf("error stating %v: %v", path, err)
+				t.Fatalf("unexpected error: %v", err)
 			}
-			if f.Size() < 1 {
-				t.Errorf("file %v was empty", path)
-			}
-			if strings.HasSuffix(file, ".json") {
-				raw, err := ioutil.ReadFile(path)
-				if err != nil {
-					t.Errorf("error to read the file %v: %v", path, err)
-				}
-				var is []interface{}
-				if err := json.Unmarshal(raw, &is); err != nil {
-					t.Errorf("error to unmarshal json string, %v: \"%v\"", err, raw)
-				}
-				if len(is) < 1 {
-					t.Errorf("unmarshaled content is empty, expected to be a non-empty array: \"%v\"", raw)
-				}
+		case <-time.After(10 * time.Second):
+			t.Fatal("GetEnvoy didn't terminate once Envoy was stopped")
+		}
+
+		extracted, _ := ioutil.TempDir("", "getenvoy-test-")
+		defer os.RemoveAll(extracted)
+		if err := archiver.Unarchive(r.DebugStore()+".tar.gz", extracted); err != nil {
+			t.Fatalf("unable to extract the debug store: %v", err)
+		}
+		debugStore := filepath.Join(extracted, filepath.Base(r.DebugStore()))
+		checkNodeFiles(t, debugStore)
+		out, _ := ioutil.ReadFile(filepath.Join(debugStore, "node/ps.txt"))
+		if !strings.Contains(string(out), filepath.Join("testdata", "sleep.sh")) {
+			t.Errorf("expected ps output to show the Envoy process, got:\n%s", out)
+		}
+	})
+
+	t.Run("creates non-empty files once Envoy was killed", func(t *testing.T) {
+		r, _ := envoy.NewRuntime(EnableNodeCollection)
+		defer os.RemoveAll(r.DebugStore() + ".tar.gz")
+		defer os.RemoveAll(r.DebugStore())
+		done := make(chan error, 1)
+		go func() {
+			done <- r.RunPath(filepath.Join("..", "testdata", "sleep.sh"), nil)
+		}()
+		pid := waitForPid(t, r)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			t.Fatalf("unable to kill Envoy: %v", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
 			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("GetEnvoy didn't terminate once Envoy was killed")
+		}
+
+		// the debug store has been archived by now
+		extracted, _ := ioutil.TempDir("", "getenvoy-test-")
+		defer os.RemoveAll(extracted)
+		if err := archiver.Unarchive(r.DebugStore()+".tar.gz", extracted); err != nil {
+			t.Fatalf("unable to extract the debug store: %v", err)
 		}
+		checkNodeFiles(t, filepath.Join(extracted, filepath.Base(r.DebugStore())))
 	})
 }
+
+// waitForPid returns the process ID of Envoy once it has been started
+func waitForPid(t *testing.T, r binary.Runner) int {
+	pid, err := r.GetPid()
+	for deadline := time.Now().Add(10 * time.Second); err != nil && time.Now().Before(deadline); pid, err = r.GetPid() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Envoy didn't start: %v", err)
+	}
+	return pid
+}
+
+func checkNodeFiles(t *testing.T, debugStore string) {
+	files := [...]string{"node/ps.txt", "node/network_interface.json", "node/connections.json"}
+	for _, file := range files {
+		path := filepath.Join(debugStore, file)
+		f, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("error stating %v: %v", path, err)
+			continue
+		}
+		if f.Size() < 1 {
+			t.Errorf("file %v was empty", path)
+		}
+		if strings.HasSuffix(file, ".json") {
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Errorf("error to read the file %v: %v", path, err)
+			}
+			var is []interface{}
+			if err := json.Unmarshal(raw, &is); err != nil {
+				t.Errorf("error to unmarshal json string, %v: \"%v\"", err, raw)
+			}
+			if len(is) < 1 {
+				t.Errorf("unmarshaled content is empty, expected to be a non-empty array: \"%v\"", raw)
+			}
+		}
+	}
+}
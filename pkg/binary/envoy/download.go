@@ -0,0 +1,285 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/transport"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+// DownloadOptions controls how Envoy archives are downloaded.
+type DownloadOptions struct {
+	// Timeout limits the duration of a single download attempt. Zero means no limit.
+	Timeout time.Duration
+	// Retries is the number of times a failed download attempt is retried.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles with every subsequent retry.
+	Backoff time.Duration
+	// PlainHTTP makes OCI registries be accessed over HTTP rather than HTTPS.
+	PlainHTTP bool
+	// Progress selects how progress of downloads is reported.
+	Progress ProgressMode
+}
+
+// DefaultDownloadOptions returns download options used by NewRuntime.
+func DefaultDownloadOptions() DownloadOptions {
+	return DownloadOptions{
+		Retries:  3,
+		Backoff:  time.Second,
+		Progress: ProgressAuto,
+	}
+}
+
+// retryableError indicates a download attempt that failed for a reason that might go away on its own,
+// e.g. a connection reset or a 5xx response.
+type retryableError struct {
+	error
+}
+
+// downloadStore returns the location at which archives are kept while they are being downloaded
+func (r *Runtime) downloadStore() string {
+	return filepath.Join(r.store, "downloads")
+}
+
+// download fetches the archive at src into the download store and returns its location.
+// Archives at `file://` locations are simply copied.
+// Partial downloads are kept between attempts, and between runs, so that they can be resumed
+// as long as the remote archive hasn't changed in the meantime.
+// Downloads of the same archive, e.g. for several builds sharing it, happen one after the other, and each of them
+// returns a copy of its own, which the caller is free to move or remove.
+func (r *Runtime) download(src string) (string, error) {
+	dir := r.downloadStore()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("unable to create directory %q: %v", dir, err)
+	}
+	archive := filepath.Join(dir, downloadName(src))
+	unlock, err := osutil.LockFile(archive+".lock", nil)
+	if err != nil {
+		return "", err
+	}
+	defer unlock() //nolint
+	if err := r.downloadTo(src, archive); err != nil {
+		return "", err
+	}
+	return claimDownload(archive)
+}
+
+// downloadTo fetches the archive at src into the passed location of the download store
+func (r *Runtime) downloadTo(src, archive string) error {
+	if location, err := url.Parse(src); err == nil && location.Scheme == "file" {
+		return copyFile(filepath.FromSlash(location.Path), archive, 0600)
+	}
+	partial := archive + ".partial"
+
+	backoff := r.Download.Backoff
+	for attempt := 0; ; attempt++ {
+		err := r.downloadAttempt(src, partial)
+		if err == nil {
+			os.Remove(validatorPath(partial)) //nolint
+			return os.Rename(partial, archive)
+		}
+		if _, ok := err.(*retryableError); !ok || attempt >= r.Download.Retries {
+			return err
+		}
+		log.Infof("download of %v failed, retrying in %v: %v", src, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// claimDownload moves the downloaded archive to a unique name with the same extension, so that another download
+// of the same archive can't replace it while it is being used
+func claimDownload(archive string) (string, error) {
+	name := filepath.Base(archive)
+	ext := name[strings.Index(name, "."):]
+	f, err := ioutil.TempFile(filepath.Dir(archive), strings.TrimSuffix(name, ext)+"-*"+ext)
+	if err != nil {
+		os.Remove(archive) //nolint
+		return "", err
+	}
+	f.Close() //nolint
+	if err := os.Rename(archive, f.Name()); err != nil {
+		os.Remove(archive)  //nolint
+		os.Remove(f.Name()) //nolint
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (r *Runtime) downloadAttempt(src, partial string) error {
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if r.Download.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Download.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		validator, err := ioutil.ReadFile(validatorPath(partial))
+		if err != nil || len(validator) == 0 {
+			// there is no telling whether the partial file is still a prefix of the remote one
+			log.Debugf("partial download of %v can't be validated, starting over", src)
+			if err := restart(f); err != nil {
+				return err
+			}
+			offset = 0
+		} else {
+			// the server sends the whole archive instead of the range if it no longer matches the validator
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", string(validator))
+		}
+	}
+	// #nosec -> src destination can be anywhere by design
+	resp, err := transport.Do(req.WithContext(ctx))
+	if err != nil {
+		return &retryableError{err}
+	}
+	defer resp.Body.Close() //nolint
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// either there was nothing to resume, the remote archive changed or the server doesn't support ranges
+		if offset > 0 {
+			log.Debugf("unable to resume download of %v, starting over", src)
+			if err := restart(f); err != nil {
+				return err
+			}
+			offset = 0
+		}
+		if err := saveValidator(validatorPath(partial), resp); err != nil {
+			return err
+		}
+	case resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp) == offset:
+		log.Debugf("resuming download of %v at byte %d", src, offset)
+	case resp.StatusCode == http.StatusPartialContent, resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file doesn't match the remote one
+		if err := restart(f); err != nil {
+			return err
+		}
+		return &retryableError{fmt.Errorf("unable to resume download from %q", src)}
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return &retryableError{fmt.Errorf("received %v status code from %q", resp.StatusCode, src)}
+	default:
+		return fmt.Errorf("received %v status code from %q", resp.StatusCode, src)
+	}
+
+	total := resp.ContentLength
+	if total >= 0 {
+		total += offset
+	}
+	transfer := r.progress().Transfer(src, total, offset)
+	_, err = io.Copy(io.MultiWriter(f, transfer), resp.Body)
+	transfer.Done(err)
+	if err != nil {
+		return &retryableError{err}
+	}
+	return nil
+}
+
+// downloadName returns a stable file name for the archive at src that preserves its extension.
+func downloadName(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:8]) + ".tar" + filepath.Ext(src)
+}
+
+// validatorPath returns the location at which the validator of a partial download is kept.
+func validatorPath(partial string) string {
+	return partial + ".validator"
+}
+
+// saveValidator keeps the strong ETag or, lacking one, the Last-Modified date of a response
+// so that resuming the download can be made conditional on the remote archive not changing.
+func saveValidator(path string, resp *http.Response) error {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// weak validators can't be used with If-Range
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(validator), 0600)
+}
+
+func restart(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// contentRangeStart returns the first byte position of a `Content-Range: bytes <start>-<end>/<size>` header or -1.
+func contentRangeStart(resp *http.Response) int64 {
+	value := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	i := strings.Index(value, "-")
+	if i < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(value[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close() //nolint
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,237 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntime_download(t *testing.T) {
+	payload := bytes.Repeat([]byte("some c++"), 1024)
+	half := int64(len(payload) / 2)
+
+	etag := `"v1"`
+	modified := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "envoy.tar.gz", time.Time{}, bytes.NewReader(payload))
+	}
+	tests := []struct {
+		name string
+		// handler is called with the 0-based number of the request
+		handler func(n int, w http.ResponseWriter, r *http.Request)
+		partial []byte
+		// validator is recorded for the partial download
+		validator    string
+		options      DownloadOptions
+		wantRanges   []string
+		wantIfRanges []string
+		wantErr      bool
+	}{
+		{
+			name:         "downloads in a single attempt",
+			handler:      func(_ int, w http.ResponseWriter, r *http.Request) { serveContent(w, r) },
+			wantRanges:   []string{""},
+			wantIfRanges: []string{""},
+		},
+		{
+			name:         "resumes a partial download left by a previous run",
+			handler:      func(_ int, w http.ResponseWriter, r *http.Request) { serveContent(w, r) },
+			partial:      payload[:half],
+			validator:    etag,
+			wantRanges:   []string{"bytes=4096-"},
+			wantIfRanges: []string{etag},
+		},
+		{
+			name: "resumes a partial download validated by its last modification",
+			handler: func(_ int, w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "envoy.tar.gz", modified, bytes.NewReader(payload))
+			},
+			partial:      payload[:half],
+			validator:    modified.Format(http.TimeFormat),
+			wantRanges:   []string{"bytes=4096-"},
+			wantIfRanges: []string{modified.Format(http.TimeFormat)},
+		},
+		{
+			name:         "starts over if the remote archive changed",
+			handler:      func(_ int, w http.ResponseWriter, r *http.Request) { serveContent(w, r) },
+			partial:      []byte("garbage"),
+			validator:    `"v0"`,
+			wantRanges:   []string{"bytes=7-"},
+			wantIfRanges: []string{`"v0"`},
+		},
+		{
+			name:         "starts over if the partial download can't be validated",
+			handler:      func(_ int, w http.ResponseWriter, r *http.Request) { serveContent(w, r) },
+			partial:      []byte("garbage"),
+			wantRanges:   []string{""},
+			wantIfRanges: []string{""},
+		},
+		{
+			name: "starts over if the server ignores the range",
+			handler: func(_ int, w http.ResponseWriter, r *http.Request) {
+				w.Write(payload) //nolint
+			},
+			partial:      []byte("garbage"),
+			validator:    etag,
+			wantRanges:   []string{"bytes=7-"},
+			wantIfRanges: []string{etag},
+		},
+		{
+			name: "retries on 5xx",
+			handler: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n < 2 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				serveContent(w, r)
+			},
+			options:    DownloadOptions{Retries: 2, Backoff: time.Millisecond},
+			wantRanges: []string{"", "", ""},
+		},
+		{
+			name: "gives up once retries are exhausted",
+			handler: func(_ int, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			options:    DownloadOptions{Retries: 1, Backoff: time.Millisecond},
+			wantRanges: []string{"", ""},
+			wantErr:    true,
+		},
+		{
+			name: "does not retry on 4xx",
+			handler: func(_ int, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			options:    DownloadOptions{Retries: 3, Backoff: time.Millisecond},
+			wantRanges: []string{""},
+			wantErr:    true,
+		},
+		{
+			name: "resumes after the connection is dropped",
+			handler: func(n int, w http.ResponseWriter, r *http.Request) {
+				if n == 0 {
+					w.Header().Set("ETag", etag)
+					w.Header().Set("Content-Length", "8192")
+					w.Write(payload[:half]) //nolint
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				serveContent(w, r)
+			},
+			options:      DownloadOptions{Retries: 1, Backoff: time.Millisecond},
+			wantRanges:   []string{"", "bytes=4096-"},
+			wantIfRanges: []string{"", etag},
+		},
+		{
+			name: "times out a stalled attempt",
+			handler: func(_ int, w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			},
+			options:    DownloadOptions{Timeout: 50 * time.Millisecond},
+			wantRanges: []string{""},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+			defer os.RemoveAll(tmpDir)
+
+			var mu sync.Mutex
+			var gotRanges, gotIfRanges []string
+			mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				gotRanges = append(gotRanges, r.Header.Get("Range"))
+				gotIfRanges = append(gotIfRanges, r.Header.Get("If-Range"))
+				n := len(gotRanges) - 1
+				mu.Unlock()
+				tc.handler(n, w, r)
+			}))
+			defer mock.Close()
+			src := mock.URL + "/envoy.tar.gz"
+
+			r := &Runtime{fetcher: fetcher{store: tmpDir, Download: tc.options}}
+			if tc.partial != nil {
+				os.MkdirAll(r.downloadStore(), 0750)
+				ioutil.WriteFile(filepath.Join(r.downloadStore(), downloadName(src)+".partial"), tc.partial, 0600)
+			}
+			if tc.validator != "" {
+				ioutil.WriteFile(filepath.Join(r.downloadStore(), downloadName(src)+".partial.validator"), []byte(tc.validator), 0600)
+			}
+
+			archive, err := r.download(src)
+			mock.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tc.wantRanges, gotRanges)
+			if tc.wantIfRanges != nil {
+				assert.Equal(t, tc.wantIfRanges, gotIfRanges)
+			}
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			got, _ := ioutil.ReadFile(archive)
+			assert.Equal(t, payload, got)
+			_, err = os.Stat(archive + ".partial")
+			assert.True(t, os.IsNotExist(err), "expected partial download to be cleaned up")
+			_, err = os.Stat(archive + ".partial.validator")
+			assert.True(t, os.IsNotExist(err), "expected validator of the partial download to be cleaned up")
+		})
+	}
+}
+
+func TestRuntime_downloadSameArchiveConcurrently(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	src := filepath.Join(tmpDir, "envoy.tar.gz")
+	require.NoError(t, ioutil.WriteFile(src, []byte("envoy"), 0600))
+
+	r := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "store")}}
+	archives := make([]string, 2)
+	wg := &sync.WaitGroup{}
+	for i := range archives {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			archive, err := r.download("file://" + filepath.ToSlash(src))
+			assert.NoError(t, err)
+			archives[i] = archive
+		}(i)
+	}
+	wg.Wait()
+
+	assert.NotEqual(t, archives[0], archives[1], "expected every download to get an archive of its own")
+	for _, archive := range archives {
+		assert.True(t, strings.HasSuffix(archive, ".tar.gz"), "expected %v to keep the extension", archive)
+		got, _ := ioutil.ReadFile(archive)
+		assert.Equal(t, "envoy", string(got))
+	}
+}
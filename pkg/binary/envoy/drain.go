@@ -0,0 +1,151 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+// drainInterval is how often active connections are checked while Envoy is draining.
+var drainInterval = time.Second
+
+// drain fails health checks of Envoy and drains its listeners gracefully, then waits until there are no active
+// connections left or the drain duration has elapsed, whichever comes first.
+// Envoy is not drained if its admin API isn't available, or if GetEnvoy doesn't know where it is, see AdminAddress.
+func (r *Runtime) drain() {
+	address := r.AdminAddress()
+	duration, err := ptypes.Duration(r.Config.DrainDuration)
+	if err != nil || duration <= 0 || r.Config.GetAdminAddress() == "" {
+		return
+	}
+	if address == "" {
+		log.Infof("Not draining Envoy process (PID=%d) as its admin address is up to the bootstrap of the user", r.cmd.Process.Pid)
+		return
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	if err := adminPost(client, address, "healthcheck/fail"); err != nil {
+		log.Warnf("Unable to drain Envoy process (PID=%d): %v", r.cmd.Process.Pid, err)
+		return
+	}
+	// older versions of Envoy can't drain listeners, yet failing health checks moves traffic elsewhere
+	if err := adminPost(client, address, "drain_listeners?graceful"); err != nil {
+		log.Warnf("Unable to drain listeners of Envoy process (PID=%d): %v", r.cmd.Process.Pid, err)
+	}
+
+	log.Infof("Draining Envoy process (PID=%d) for up to %v", r.cmd.Process.Pid, duration)
+	deadline := time.Now().Add(duration)
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		active, err := activeConnections(client, address)
+		switch {
+		case err == nil && active == 0:
+			log.Infof("Envoy process (PID=%d) has no active connections left", r.cmd.Process.Pid)
+			return
+		case r.Status() == binary.StatusTerminated:
+			return
+		case !time.Now().Before(deadline):
+			log.Infof("Envoy process (PID=%d) still has %d active connections after draining for %v", r.cmd.Process.Pid, active, duration)
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// adminPost calls an endpoint of the admin API of Envoy that changes its state.
+func adminPost(client *http.Client, address, path string) error {
+	resp, err := client.Post(fmt.Sprintf("http://%s/%s", address, path), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received %v from /%v", resp.StatusCode, path)
+	}
+	return nil
+}
+
+// activeConnections sums up active downstream connections of all listeners of Envoy but the admin one.
+func activeConnections(client *http.Client, address string) (int, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/stats?filter=downstream_cx_active", address))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() //nolint
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("received %v from /stats", resp.StatusCode)
+	}
+	active := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// e.g. `listener.0.0.0.0_8080.downstream_cx_active: 3`
+		name, value := splitStat(scanner.Text())
+		if !strings.HasPrefix(name, "listener.") || strings.HasPrefix(name, "listener.admin.") ||
+			!strings.HasSuffix(name, ".downstream_cx_active") {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("unable to parse stat %q: %v", scanner.Text(), err)
+		}
+		active += n
+	}
+	return active, scanner.Err()
+}
+
+func splitStat(line string) (name, value string) {
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return line, ""
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+}
+
+// killAfter kills Envoy unless it terminates within the kill timeout.
+func (r *Runtime) killAfter(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	statuses, unsubscribe := r.Subscribe()
+	timer := time.NewTimer(timeout)
+	go func() {
+		defer unsubscribe()
+		defer timer.Stop()
+		for {
+			select {
+			case status, ok := <-statuses:
+				if !ok || status == binary.StatusTerminated {
+					return
+				}
+			case <-timer.C:
+				log.Infof("Envoy process (PID=%d) didn't terminate within %v, sending SIGKILL", r.cmd.Process.Pid, timeout)
+				r.cmd.Process.Signal(os.Kill) //nolint
+				r.signalDraining(os.Kill)
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+func TestRuntime_Drain(t *testing.T) {
+	defer func(interval time.Duration) { drainInterval = interval }(drainInterval)
+	drainInterval = time.Millisecond
+
+	tests := []struct {
+		name           string
+		active         []int
+		drainListeners int
+		want           []string
+	}{
+		{
+			name:           "waits until there are no active connections left",
+			active:         []int{2, 1, 0},
+			drainListeners: http.StatusOK,
+			want: []string{
+				"POST /healthcheck/fail", "POST /drain_listeners?graceful",
+				"GET /stats?filter=downstream_cx_active", "GET /stats?filter=downstream_cx_active", "GET /stats?filter=downstream_cx_active",
+			},
+		},
+		{
+			name:           "waits for the drain duration at most",
+			active:         []int{1},
+			drainListeners: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+			defer os.RemoveAll(tmpDir)
+			var mu sync.Mutex
+			var calls []string
+			admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch req.URL.Path {
+				case "/ready":
+					return
+				case "/drain_listeners":
+					w.WriteHeader(tc.drainListeners)
+				case "/stats":
+					active := tc.active[0]
+					if len(tc.active) > 1 {
+						tc.active = tc.active[1:]
+					}
+					fmt.Fprintf(w, "http.ingress.downstream_cx_active: %d\n", active)
+					fmt.Fprintf(w, "listener.0.0.0.0_8080.downstream_cx_active: %d\n", active)
+					fmt.Fprintf(w, "listener.admin.downstream_cx_active: 1\n")
+				}
+				calls = append(calls, req.Method+" "+req.URL.RequestURI())
+			}))
+			defer admin.Close()
+			useAdmin(r, admin)
+			r.Config.DrainDuration = ptypes.DurationProto(100 * time.Millisecond)
+
+			runAndInterrupt(t, r, "sleep.sh")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if tc.want != nil {
+				assert.Equal(t, tc.want, calls)
+			} else {
+				assert.True(t, len(calls) > 3, "expected active connections to be checked until the drain duration elapsed")
+			}
+			assert.Equal(t, "exit status 0", r.RestartStatus().LastExit, "expected Envoy to be signaled once drained")
+		})
+	}
+}
+
+func TestRuntime_DrainSkipsBootstrapOfUser(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	var mu sync.Mutex
+	var calls []string
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, req.Method+" "+req.URL.RequestURI())
+	}))
+	defer admin.Close()
+	useAdmin(r, admin)
+	r.Config.DrainDuration = ptypes.DurationProto(100 * time.Millisecond)
+
+	// the admin listener of Envoy might be anywhere, so the one in Config could be another Envoy's
+	runAndInterrupt(t, r, "sleep.sh", "--config-path", "bootstrap.yaml")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, calls, "expected neither readiness nor draining to use the admin address of Config")
+	assert.Empty(t, r.AdminAddress())
+}
+
+func TestRuntime_KillAfterTimeout(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	r.Config.AdminPort = 0
+	r.KillTimeout = 100 * time.Millisecond
+
+	runAndInterrupt(t, r, "stubborn.sh")
+
+	assert.Equal(t, "signal: killed", r.RestartStatus().LastExit)
+}
+
+func TestActiveConnections(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "listener.0.0.0.0_8080.downstream_cx_active: 2")
+		fmt.Fprintln(w, "listener.[__]_8443.downstream_cx_active: 3")
+		fmt.Fprintln(w, "listener.0.0.0.0_8080.downstream_cx_active_total: 100")
+		fmt.Fprintln(w, "listener.admin.downstream_cx_active: 1")
+	}))
+	defer admin.Close()
+
+	active, err := activeConnections(admin.Client(), admin.Listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, 5, active)
+}
+
+// useAdmin points the admin address of Envoy at the passed server.
+func useAdmin(r *Runtime, admin *httptest.Server) {
+	host, port, _ := net.SplitHostPort(admin.Listener.Addr().String())
+	adminPort, _ := strconv.Atoi(port)
+	r.Config.AdminAddress = host
+	r.Config.AdminPort = int32(adminPort)
+}
+
+// runAndInterrupt runs the script from testdata and sends SIGINT to GetEnvoy once it has started.
+func runAndInterrupt(t *testing.T, r *Runtime, script string, args ...string) {
+	errs := make(chan error)
+	go func() {
+		errs <- r.RunPath(filepath.Join("testdata", script), args)
+	}()
+	r.Wait(binary.StatusStarted)
+	time.Sleep(100 * time.Millisecond) // give the script a chance to set up its traps
+	r.SendSignal(syscall.SIGINT)
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected GetEnvoy to terminate")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archiver"
+	"github.com/tetratelabs/log"
+)
+
+// extractEnvoy extracts the `bin/` and `lib/` directories of the Envoy release in the tarball into dst,
+// wherever they are in the tarball, e.g. `envoy-1.17.0/bin/envoy` becomes `<dst>/bin/envoy`.
+//
+// The tarball is untrusted: entries that would end up outside dst, symlinks and hard links that point outside it,
+// and entries other than directories, regular files and links are rejected, failing the extraction as a whole.
+// Permission bits of entries are preserved, except for setuid, setgid and sticky bits.
+func extractEnvoy(dst, tarball string) error {
+	if err := extractArchive(dst, tarball, releasePath); err != nil {
+		return err
+	}
+	envoyFilepath := filepath.Join(dst, envoyLocation)
+	log.Debugf("checking for binary at %v", envoyFilepath)
+	if _, err := os.Stat(envoyFilepath); os.IsNotExist(err) {
+		return errors.New("no Envoy binary in downloaded tarball")
+	}
+	return nil
+}
+
+// extractArchive extracts the entries of the untrusted archive into dst, each at the path relative to dst that
+// pathOf returns for it, skipping the ones it returns an empty path for, with the same safeguards as extractEnvoy.
+func extractArchive(dst, archive string, pathOf func(name string) (string, error)) error {
+	root, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	return archiver.Walk(archive, func(f archiver.File) error {
+		entry, err := newArchiveEntry(f)
+		if err != nil {
+			return err
+		}
+		rel, err := pathOf(entry.name)
+		if err != nil {
+			return fmt.Errorf("unsafe archive entry %q: %v", entry.name, err)
+		}
+		if rel == "" {
+			return nil
+		}
+		if err := extractEntry(root, rel, entry, f, pathOf); err != nil {
+			return fmt.Errorf("unable to extract %q: %v", entry.name, err)
+		}
+		return nil
+	})
+}
+
+// archiveEntry describes an entry of an archive independently of the archive format
+type archiveEntry struct {
+	// name is the path of the entry within the archive
+	name string
+	// mode holds the type and permission bits of the entry
+	mode os.FileMode
+	// linkname is the target of a link
+	linkname string
+	// hardlink is true if the entry is a hard link to another entry
+	hardlink bool
+}
+
+func newArchiveEntry(f archiver.File) (*archiveEntry, error) {
+	switch header := f.Header.(type) {
+	case *tar.Header:
+		entry := &archiveEntry{name: header.Name, mode: header.FileInfo().Mode(), linkname: header.Linkname}
+		entry.hardlink = header.Typeflag == tar.TypeLink
+		return entry, nil
+	case zip.FileHeader:
+		entry := &archiveEntry{name: header.Name, mode: f.Mode()}
+		if entry.mode&os.ModeSymlink != 0 {
+			// zip archives keep the target of a symlink as its contents
+			target, err := ioutil.ReadAll(io.LimitReader(f, 4096))
+			if err != nil {
+				return nil, err
+			}
+			entry.linkname = string(target)
+		}
+		return entry, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive entry %q", f.Name())
+	}
+}
+
+// releasePath returns the part of the passed archive path starting at its `bin` or `lib` component, e.g. `bin/envoy`,
+// or an empty string if the entry is outside the `bin/` and `lib/` directories
+func releasePath(name string) (string, error) {
+	parts, err := archivePathParts(name)
+	if err != nil {
+		return "", err
+	}
+	for i, part := range parts {
+		if part == "bin" || part == "lib" {
+			return path.Join(parts[i:]...), nil
+		}
+	}
+	return "", nil
+}
+
+// archivePath returns the passed archive path as is, as long as it stays within the directory it is extracted into
+func archivePath(name string) (string, error) {
+	parts, err := archivePathParts(name)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(parts...), nil
+}
+
+func archivePathParts(name string) ([]string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return nil, errors.New("absolute paths are not allowed")
+	}
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for _, part := range parts {
+		if part == ".." {
+			return nil, errors.New("parent directory references are not allowed")
+		}
+	}
+	return parts, nil
+}
+
+func extractEntry(root, rel string, entry *archiveEntry, r io.Reader, pathOf func(name string) (string, error)) error {
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	// a symlink extracted earlier must not redirect the entry outside of root
+	dir, err := resolveWithin(root, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	target = filepath.Join(dir, filepath.Base(target))
+	perm := entry.mode.Perm()
+	switch {
+	case entry.mode.IsDir():
+		if err := os.MkdirAll(target, 0700|perm); err != nil {
+			return err
+		}
+		return os.Chmod(target, 0700|perm)
+	case entry.hardlink:
+		linkRel, err := pathOf(entry.linkname)
+		if err != nil || linkRel == "" {
+			return fmt.Errorf("hard link to %q points outside of the release", entry.linkname)
+		}
+		source, err := resolveWithin(root, filepath.Join(root, filepath.FromSlash(linkRel)))
+		if err != nil {
+			return err
+		}
+		return os.Link(source, target)
+	case entry.mode&os.ModeSymlink != 0:
+		if entry.linkname == "" || filepath.IsAbs(entry.linkname) || path.IsAbs(entry.linkname) {
+			return fmt.Errorf("symlink to %q is not relative", entry.linkname)
+		}
+		if _, err := resolveWithin(root, filepath.Join(dir, filepath.FromSlash(entry.linkname))); err != nil {
+			return fmt.Errorf("symlink to %q points outside of the release", entry.linkname)
+		}
+		return os.Symlink(entry.linkname, target)
+	case entry.mode.IsRegular():
+		return writeFile(target, r, perm)
+	default:
+		return fmt.Errorf("entries of type %v are not allowed", entry.mode.Type())
+	}
+}
+
+// resolveWithin returns the passed path with symlinks in its existing part resolved,
+// or an error if it is outside of root
+func resolveWithin(root, p string) (string, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	existing, rest := filepath.Clean(p), ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			resolved = filepath.Join(resolved, rest)
+			if !isWithin(resolvedRoot, resolved) {
+				return "", fmt.Errorf("%v is outside of the release", p)
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", err
+		}
+		existing, rest = parent, filepath.Join(filepath.Base(existing), rest)
+	}
+}
+
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func writeFile(target string, r io.Reader, perm os.FileMode) error {
+	// never write through whatever is already there, e.g. a symlink from an earlier entry
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close() //nolint
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// the mode passed to OpenFile is subject to umask
+	return os.Chmod(target, perm)
+}
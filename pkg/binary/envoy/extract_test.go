@@ -0,0 +1,150 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractEnvoy(t *testing.T) {
+	envoy := &tar.Header{Name: "envoy-1.17.0/bin/envoy", Typeflag: tar.TypeReg, Mode: 0755}
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+		wantErr string
+	}{
+		{
+			name: "extracts bin and lib directories",
+			entries: []*tar.Header{
+				{Name: "envoy-1.17.0/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "envoy-1.17.0/README.md", Typeflag: tar.TypeReg, Mode: 0644},
+				envoy,
+				{Name: "envoy-1.17.0/lib/libfoo.so.1", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "envoy-1.17.0/lib/libfoo.so", Typeflag: tar.TypeSymlink, Linkname: "libfoo.so.1"},
+				{Name: "envoy-1.17.0/lib/libbar.so", Typeflag: tar.TypeLink, Linkname: "envoy-1.17.0/lib/libfoo.so.1"},
+			},
+		},
+		{
+			name:    "rejects parent directory references",
+			entries: []*tar.Header{envoy, {Name: "envoy-1.17.0/bin/../../../outside", Typeflag: tar.TypeReg, Mode: 0644}},
+			wantErr: "parent directory references are not allowed",
+		},
+		{
+			name:    "rejects absolute paths",
+			entries: []*tar.Header{envoy, {Name: "/bin/outside", Typeflag: tar.TypeReg, Mode: 0644}},
+			wantErr: "absolute paths are not allowed",
+		},
+		{
+			name:    "rejects absolute symlinks",
+			entries: []*tar.Header{envoy, {Name: "lib/passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+			wantErr: `symlink to "/etc/passwd" is not relative`,
+		},
+		{
+			name:    "rejects symlinks outside of the release",
+			entries: []*tar.Header{envoy, {Name: "lib/outside", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+			wantErr: `symlink to "../../outside" points outside of the release`,
+		},
+		{
+			name: "rejects symlinks that escape through another symlink",
+			entries: []*tar.Header{
+				envoy,
+				{Name: "lib/root", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				{Name: "lib/root/bin/outside", Typeflag: tar.TypeSymlink, Linkname: "../../outside"},
+			},
+			wantErr: `symlink to "../../outside" points outside of the release`,
+		},
+		{
+			name:    "rejects hard links outside of the release",
+			entries: []*tar.Header{envoy, {Name: "lib/passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+			wantErr: `hard link to "../../etc/passwd" points outside of the release`,
+		},
+		{
+			name:    "rejects devices",
+			entries: []*tar.Header{envoy, {Name: "lib/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+			wantErr: "are not allowed",
+		},
+		{
+			name:    "rejects named pipes",
+			entries: []*tar.Header{envoy, {Name: "bin/pipe", Typeflag: tar.TypeFifo, Mode: 0644}},
+			wantErr: "are not allowed",
+		},
+		{
+			name:    "rejects tarballs without envoy",
+			entries: []*tar.Header{{Name: "lib/libfoo.so", Typeflag: tar.TypeReg, Mode: 0644}},
+			wantErr: "no Envoy binary in downloaded tarball",
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+			defer os.RemoveAll(tmpDir)
+			tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+			writeTarball(t, tarball, tc.entries)
+			dst := filepath.Join(tmpDir, "dst", "build")
+			require.NoError(t, os.MkdirAll(dst, 0750))
+
+			err := extractEnvoy(dst, tarball)
+			_, statErr := os.Lstat(filepath.Join(tmpDir, "dst", "outside"))
+			assert.True(t, os.IsNotExist(statErr), "expected nothing to be extracted outside of the destination")
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			info, err := os.Stat(filepath.Join(dst, envoyLocation))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm(), "expected the file mode to be preserved")
+			link, err := os.Readlink(filepath.Join(dst, "lib", "libfoo.so"))
+			require.NoError(t, err)
+			assert.Equal(t, "libfoo.so.1", link)
+			_, err = os.Stat(filepath.Join(dst, "lib", "libbar.so"))
+			assert.NoError(t, err)
+			_, err = os.Stat(filepath.Join(dst, "README.md"))
+			assert.True(t, os.IsNotExist(err), "expected only bin and lib directories to be extracted")
+		})
+	}
+}
+
+// writeTarball creates a tarball with the passed entries, regular files having their name as contents
+func writeTarball(t *testing.T, path string, entries []*tar.Header) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+	for _, entry := range entries {
+		header := *entry
+		var contents []byte
+		if header.Typeflag == tar.TypeReg {
+			contents = []byte(header.Name)
+			header.Size = int64(len(contents))
+		}
+		require.NoError(t, tw.WriteHeader(&header))
+		_, err := tw.Write(contents)
+		require.NoError(t, err)
+	}
+}
@@ -15,62 +15,130 @@
 package envoy
 
 import (
-	"archive/tar"
-	"errors"
-	"net/http"
-	"path/filepath"
-	"strings"
-
-	"os"
-
+	"encoding/json"
 	"fmt"
-
 	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"io"
-
-	"github.com/mholt/archiver"
-	"github.com/schollz/progressbar/v2"
 	"github.com/tetratelabs/getenvoy/pkg/manifest"
-	"github.com/tetratelabs/getenvoy/pkg/transport"
 	"github.com/tetratelabs/log"
+
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
 )
 
-const envoyLocation = "bin/envoy"
+const (
+	envoyLocation = "bin/envoy"
+	// originalDir is the directory of a build that keeps the archive it was installed from, so that it can be passed on
+	// as is along with the integrity metadata it was verified against, e.g. in a bundle
+	originalDir = ".original"
+	// originalDigestFile is the file within originalDir that holds the integrity metadata of the archive
+	originalDigestFile = "digest.json"
+)
 
 // FetchAndRun downloads an Envoy binary, if necessary, and runs it.
+// The reference can also point to an OCI registry, e.g. `oci://registry.local/envoy/standard:1.17`, or be a path to
+// either an Envoy executable or a local Envoy release, which is registered under CustomFlavor before it is run.
+// An empty reference stands for the one pinned by `getenvoy use`.
 func (r *Runtime) FetchAndRun(reference string, args []string) error {
+	if IsOCIReference(reference) {
+		key, err := r.FetchOCI(reference)
+		if err != nil {
+			return err
+		}
+		return r.Run(key, args)
+	}
 	key, err := manifest.NewKey(reference)
 	if err != nil {
+		if reference == "" {
+			return err
+		}
 		if _, err := os.Stat(reference); err != nil {
 			return fmt.Errorf("%q is neither a valid Envoy release provided by getenvoy.io nor a path to a custom Envoy binary", reference)
 		}
-		return r.RunPath(reference, args)
+		if !IsRelease(reference) {
+			return r.RunPath(reference, args)
+		}
+		if key, err = NewReleaseKey(reference); err != nil {
+			return err
+		}
+		imported, err := r.Import(key, reference)
+		if err != nil {
+			return err
+		}
+		if imported {
+			r.progress().Printf("registered %v as %v", reference, key)
+		}
+		return r.Run(key, args)
+	}
+	if key, err = resolve(key, r.progress()); err != nil {
+		return err
 	}
 	if !r.AlreadyDownloaded(key) {
-		location, err := manifest.Locate(key)
+		build, err := manifest.Locate(key)
 		if err != nil {
 			return err
 		}
-		if err := r.Fetch(key, location); err != nil {
+		if err := r.Fetch(key, build); err != nil {
 			return err
 		}
 	}
 	return r.Run(key, args)
 }
 
-// Fetch downloads an Envoy binary from the passed location
-func (r *Runtime) Fetch(key *manifest.Key, binaryLocation string) error {
+// Resolve replaces a version range or alias in the passed key, e.g. `standard:1.16.x`, with the newest matching version
+// The concrete reference is reported to the passed progress so that it can be pinned, or to os.Stderr if it is nil,
+// which keeps it apart from the output of Envoy
+func Resolve(key *manifest.Key, progress Progress) (*manifest.Key, error) {
+	if progress == nil {
+		progress = NewProgress(ProgressNone, os.Stderr)
+	}
+	return resolve(key, progress)
+}
+
+func resolve(key *manifest.Key, progress Progress) (*manifest.Key, error) {
+	if !manifest.IsVersionRange(key.Version) {
+		return key, nil
+	}
+	resolved, err := manifest.Resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	progress.Printf("resolved %v to %v", key, resolved)
+	return resolved, nil
+}
+
+// Fetch downloads an Envoy binary from the location of the passed build
+// The downloaded archive is verified against the build checksum before it is extracted into the store
+// Concurrent fetches of the same key, including by other GetEnvoy processes, are serialized
+// In offline mode, only a binary that is already downloaded or available on the local filesystem can be fetched
+func (r *Runtime) Fetch(key *manifest.Key, build *manifest.Build) error {
+	unlock, err := osutil.LockFile(r.lockFile(key), func() {
+		r.progress().Printf("waiting for another GetEnvoy process to finish fetching %v", key)
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock() //nolint
 	if !r.AlreadyDownloaded(key) {
-		log.Debugf("fetching %v from %v", key, binaryLocation)
-		dst := r.platformDirectory(key)
-		if err := os.MkdirAll(dst, 0750); err != nil {
-			return fmt.Errorf("unable to create directory %q: %v", dst, err)
+		if build == nil {
+			return fmt.Errorf("unable to fetch %v: download location is unknown", key)
+		}
+		locations := build.Locations()
+		if manifest.IsOffline() {
+			if locations = localLocations(locations); len(locations) == 0 {
+				return fmt.Errorf("%v is not downloaded and cannot be fetched in offline mode", key)
+			}
 		}
-		fmt.Printf("fetching %v\n", key)
-		return fetchEnvoy(dst, binaryLocation)
+		log.Debugf("fetching %v from %v", key, locations[0])
+		r.progress().Printf("fetching %v", key)
+		return r.fetchEnvoy(key, build, locations)
 	}
-	fmt.Printf("%v is already downloaded\n", key)
+	r.progress().Printf("%v is already downloaded", key)
 	return nil
 }
 
@@ -83,80 +151,224 @@ func (r *Runtime) AlreadyDownloaded(key *manifest.Key) bool {
 	return !os.IsNotExist(err)
 }
 
+// progress returns the reporter of progress of fetching Envoy
+func (r *Runtime) progress() Progress {
+	r.progressOnce.Do(func() {
+		if r.Progress == nil {
+			out := r.IO.Err
+			if out == nil {
+				out = os.Stderr
+			}
+			r.Progress = NewProgress(r.Download.Progress, out)
+		}
+	})
+	return r.Progress
+}
+
 // BinaryStore returns the location at which the runtime instance persists binaries
 // Getters typically aren't idiomatic Go, however, this one is deliberately part of the fetcher interface
 func (r *Runtime) BinaryStore() string {
-	return filepath.Join(r.store, "builds")
+	return binaryStore(r.store)
+}
+
+// binaryStore returns the location of Envoy builds within a GetEnvoy home directory
+func binaryStore(home string) string {
+	return filepath.Join(home, "builds")
 }
 
 func (r *Runtime) platformDirectory(key *manifest.Key) string {
-	platform := strings.ToLower(key.Platform)
-	platform = strings.ReplaceAll(platform, "-", "_")
-	return filepath.Join(r.BinaryStore(), key.Flavor, key.Version, platform)
+	return filepath.Join(r.BinaryStore(), key.Flavor, key.Version, platformDirName(key))
+}
+
+// lockFile returns the location of the file that guards fetching of the build matching the passed key
+func (r *Runtime) lockFile(key *manifest.Key) string {
+	return filepath.Join(r.store, "locks", key.Flavor, key.Version, platformDirName(key)+".lock")
+}
+
+// quarantineStore returns the location at which archives that failed verification are kept for inspection
+func (r *Runtime) quarantineStore() string {
+	return filepath.Join(r.store, "quarantine")
 }
 
-func fetchEnvoy(dst, src string) error {
-	tmpDir, err := ioutil.TempDir("", "getenvoy-")
+// tempDir creates a new temporary directory within the store, so that it can be moved into place with a rename
+func (r *Runtime) tempDir(prefix string) (string, error) {
+	tmpRoot := filepath.Join(r.store, "tmp")
+	if err := os.MkdirAll(tmpRoot, 0750); err != nil {
+		return "", fmt.Errorf("unable to create directory %q: %v", tmpRoot, err)
+	}
+	tmpDir, err := ioutil.TempDir(tmpRoot, prefix)
 	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmpDir, 0750); err != nil {
+		os.RemoveAll(tmpDir) //nolint
+		return "", err
+	}
+	return tmpDir, nil
+}
+
+// localLocations returns the locations that refer to the local filesystem
+func localLocations(locations []string) []string {
+	result := make([]string, 0, len(locations))
+	for _, location := range locations {
+		if u, err := url.Parse(location); err == nil && u.Scheme == "file" {
+			result = append(result, location)
+		}
+	}
+	return result
+}
+
+func platformDirName(key *manifest.Key) string {
+	platform := strings.ToLower(key.Platform)
+	return strings.ReplaceAll(platform, "-", "_")
+}
+
+// fetchEnvoy downloads the build from the first of the locations that works, verifies it and installs it
+// An archive that fails verification is quarantined and the next location is tried
+func (r *Runtime) fetchEnvoy(key *manifest.Key, build *manifest.Build, locations []string) error {
+	// a build that can't be verified isn't downloaded in the first place
+	if err := build.Verifiable(); err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpDir) //nolint
-	tarball, err := doDownload(tmpDir, src)
+	var err error
+	for i, location := range locations {
+		if err = r.fetchFrom(key, build.At(location)); err == nil {
+			return nil
+		}
+		if i < len(locations)-1 {
+			log.Warnf("unable to fetch envoy from %v, falling back to %v: %v", location, locations[i+1], err)
+		}
+	}
+	return err
+}
+
+// fetchFrom downloads the build from its download location, verifies it against the integrity metadata
+// of that location and installs it
+func (r *Runtime) fetchFrom(key *manifest.Key, build *manifest.Build) error {
+	src := build.DownloadLocationURL
+	tarball, err := r.download(src)
 	if err != nil {
 		return fmt.Errorf("unable to fetch envoy from %v: %v", src, err)
 	}
-	if err := extractEnvoy(dst, tarball); err != nil {
+	defer os.Remove(tarball) //nolint
+	if err := build.Verify(tarball); err != nil {
+		quarantined, qErr := r.quarantine(key, tarball)
+		if qErr != nil {
+			log.Errorf("unable to quarantine %v: %v", tarball, qErr)
+			return fmt.Errorf("unable to verify envoy from %v: %v", src, err)
+		}
+		return fmt.Errorf("unable to verify envoy from %v: %v (archive quarantined at %v)", src, err, quarantined)
+	}
+	return r.install(key, tarball, build.Digest)
+}
+
+// install extracts the tarball into a temporary directory and only then moves it into the store,
+// so that a build directory never appears half-populated, e.g. if extraction gets interrupted
+// The tarball is moved into the build along with the passed integrity metadata it was verified against,
+// which lacking a checksum is completed with the one of the tarball
+func (r *Runtime) install(key *manifest.Key, tarball string, digest manifest.Digest) error {
+	tmpDir, err := r.tempDir("extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir) //nolint
+	dst := r.platformDirectory(key)
+	if err := extractEnvoy(tmpDir, tarball); err != nil {
 		return fmt.Errorf("unable to extract envoy to %v: %v", dst, err)
 	}
-	return nil
+	if err := keepOriginal(tmpDir, tarball, digest); err != nil {
+		return fmt.Errorf("unable to keep the archive of envoy: %v", err)
+	}
+	return r.moveIntoStore(key, tmpDir)
+}
+
+// keepOriginal moves the archive a build was installed from into the build directory along with its integrity metadata
+func keepOriginal(dir, archive string, digest manifest.Digest) error {
+	if digest.SHA256 == "" {
+		sum, err := sha256Hex(archive)
+		if err != nil {
+			return err
+		}
+		digest.SHA256 = sum
+	}
+	originals := filepath.Join(dir, originalDir)
+	if err := os.MkdirAll(originals, 0750); err != nil {
+		return err
+	}
+	if err := os.Rename(archive, filepath.Join(originals, "envoy"+archiveExt(archive))); err != nil {
+		return err
+	}
+	return recordDigest(dir, digest)
 }
 
-func doDownload(dst, src string) (string, error) {
-	// #nosec -> src destination can be anywhere by design
-	resp, err := transport.Get(src)
+// recordDigest records the integrity metadata of what the build directory was installed from
+func recordDigest(dir string, digest manifest.Digest) error {
+	originals := filepath.Join(dir, originalDir)
+	if err := os.MkdirAll(originals, 0750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(digest)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer resp.Body.Close() //nolint
+	return ioutil.WriteFile(filepath.Join(originals, originalDigestFile), data, 0600)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received %v status code from %q", resp.StatusCode, src)
+// originalDigest returns the integrity metadata of what the build matching the passed key was installed from
+func (r *Runtime) originalDigest(key *manifest.Key) (*manifest.Digest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.platformDirectory(key), originalDir, originalDigestFile))
+	if err != nil {
+		return nil, err
+	}
+	digest := &manifest.Digest{}
+	if err := json.Unmarshal(data, digest); err != nil {
+		return nil, err
 	}
+	return digest, nil
+}
 
-	tarball := filepath.Join(dst, "envoy.tar"+filepath.Ext(src))
-	f, err := os.OpenFile(tarball, os.O_CREATE|os.O_WRONLY, 0600)
+// original returns the archive the build matching the passed key was installed from and its integrity metadata
+func (r *Runtime) original(key *manifest.Key) (string, *manifest.Digest, error) {
+	digest, err := r.originalDigest(key)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	defer f.Close() //nolint
-
-	bar := progressbar.NewOptions64(resp.ContentLength, progressbar.OptionSetDescription("[Fetching Envoy]"))
-	out := io.MultiWriter(f, bar)
-	_, err = io.Copy(out, resp.Body)
-	fmt.Println("") // append a newline to progressbar output
-	return tarball, err
-}
-
-func extractEnvoy(dst, tarball string) error {
-	// Walk the tarball until we find the bin and lib directories
-	if err := archiver.Walk(tarball, func(f archiver.File) error {
-		if (f.Name() == "bin" && f.IsDir()) || (f.Name() == "lib" && f.IsDir()) {
-			if f.Header != nil {
-				if header, ok := f.Header.(*tar.Header); ok {
-					if err := archiver.Extract(tarball, header.Name, dst); err != nil {
-						log.Errorf("error extracting %v: %v", f.Name(), err)
-					}
-				}
-			}
-		}
-		return nil
-	}); err != nil {
+	originals := filepath.Join(r.platformDirectory(key), originalDir)
+	archives, err := filepath.Glob(filepath.Join(originals, "envoy*"))
+	if err != nil || len(archives) != 1 {
+		return "", nil, fmt.Errorf("no archive in %v", originals)
+	}
+	return archives[0], digest, nil
+}
+
+// moveIntoStore replaces the build matching the passed key with the contents of the passed temporary directory
+func (r *Runtime) moveIntoStore(key *manifest.Key, tmpDir string) error {
+	dst := r.platformDirectory(key)
+	touchBuild(tmpDir)
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return fmt.Errorf("unable to create directory %q: %v", filepath.Dir(dst), err)
+	}
+	if pids := activePids(dst); len(pids) > 0 {
+		return fmt.Errorf("%v is in use by process %v, stop it before replacing the build", key, pids[0])
+	}
+	// clean up whatever an interrupted extraction by an older GetEnvoy might have left behind
+	if err := os.RemoveAll(dst); err != nil {
 		return err
 	}
-	envoyFilepath := filepath.Join(dst, envoyLocation)
-	log.Debugf("checking for binary at %v", envoyFilepath)
-	if _, err := os.Stat(envoyFilepath); os.IsNotExist(err) {
-		return errors.New("no Envoy binary in downloaded tarball")
+	if err := os.Rename(tmpDir, dst); err != nil {
+		return fmt.Errorf("unable to move envoy to %v: %v", dst, err)
 	}
 	return nil
 }
+
+func (r *Runtime) quarantine(key *manifest.Key, tarball string) (string, error) {
+	dir := filepath.Join(r.quarantineStore(), key.Flavor, key.Version, platformDirName(key))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, strconv.FormatInt(time.Now().UnixNano(), 10)+"-"+filepath.Base(tarball))
+	if err := os.Rename(tarball, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
@@ -15,11 +15,17 @@
 package envoy
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"os"
 
@@ -39,7 +45,10 @@ func TestRuntime_Fetch(t *testing.T) {
 		libLocation      string
 		alreadyLocal     bool
 		responseStatus   int
+		checksum         func(tarball string) string
+		skipVerify       bool
 		wantErr          bool
+		wantQuarantined  bool
 		wantServerCalled bool
 	}{
 		{
@@ -48,6 +57,7 @@ func TestRuntime_Fetch(t *testing.T) {
 			tarballStructure: "envoy",
 			tarExtension:     ".tar.gz",
 			responseStatus:   http.StatusOK,
+			checksum:         sha256Of,
 			envoyLocation:    "builds/standard/1.11.0/darwin/bin/envoy",
 			libLocation:      "builds/standard/1.11.0/darwin/lib/somelib",
 			wantServerCalled: true,
@@ -58,10 +68,34 @@ func TestRuntime_Fetch(t *testing.T) {
 			tarballStructure: "envoy",
 			tarExtension:     ".tar.xz",
 			responseStatus:   http.StatusOK,
+			checksum:         sha256Of,
 			envoyLocation:    "builds/standard/1.11.0/darwin/bin/envoy",
 			libLocation:      "builds/standard/1.11.0/darwin/lib/somelib",
 			wantServerCalled: true,
 		},
+		{
+			name:             "Verifies checksum before untarring envoy",
+			key:              defaultDarwinKey,
+			tarballStructure: "envoy",
+			tarExtension:     ".tar.gz",
+			responseStatus:   http.StatusOK,
+			checksum:         sha256Of,
+			envoyLocation:    "builds/standard/1.11.0/darwin/bin/envoy",
+			libLocation:      "builds/standard/1.11.0/darwin/lib/somelib",
+			wantServerCalled: true,
+		},
+		{
+			name:             "errors and quarantines the tarball if checksum doesn't match",
+			key:              defaultDarwinKey,
+			tarballStructure: "envoy",
+			tarExtension:     ".tar.gz",
+			responseStatus:   http.StatusOK,
+			checksum:         func(string) string { return strings.Repeat("0", 64) },
+			envoyLocation:    "builds/standard/1.11.0/darwin/bin/envoy",
+			wantErr:          true,
+			wantQuarantined:  true,
+			wantServerCalled: true,
+		},
 		{
 			name:             "Does nothing if it already has a local copy",
 			key:              defaultDarwinKey,
@@ -76,6 +110,7 @@ func TestRuntime_Fetch(t *testing.T) {
 			tarballStructure: "noenvoy",
 			tarExtension:     ".tar.gz",
 			responseStatus:   http.StatusOK,
+			checksum:         sha256Of,
 			wantErr:          true,
 			wantServerCalled: true,
 		},
@@ -85,9 +120,30 @@ func TestRuntime_Fetch(t *testing.T) {
 			tarballStructure: "noenvoy",
 			tarExtension:     ".tar.gz",
 			responseStatus:   http.StatusTeapot,
+			checksum:         sha256Of,
 			wantErr:          true,
 			wantServerCalled: true,
 		},
+		{
+			name:             "errors without downloading if the manifest has no checksum",
+			key:              defaultDarwinKey,
+			tarballStructure: "envoy",
+			tarExtension:     ".tar.gz",
+			responseStatus:   http.StatusOK,
+			wantErr:          true,
+			wantServerCalled: false,
+		},
+		{
+			name:             "Downloads builds without a checksum if verification is skipped",
+			key:              defaultDarwinKey,
+			tarballStructure: "envoy",
+			tarExtension:     ".tar.gz",
+			responseStatus:   http.StatusOK,
+			skipVerify:       true,
+			envoyLocation:    "builds/standard/1.11.0/darwin/bin/envoy",
+			libLocation:      "builds/standard/1.11.0/darwin/lib/somelib",
+			wantServerCalled: true,
+		},
 	}
 	for _, tt := range tests {
 		tc := tt
@@ -96,16 +152,24 @@ func TestRuntime_Fetch(t *testing.T) {
 			defer os.RemoveAll(tmpDir)
 			envoyLocation := filepath.Join(tmpDir, tc.envoyLocation)
 			libLocation := filepath.Join(tmpDir, tc.libLocation)
-			mock, gotCalled := mockServer(tc.responseStatus, tc.tarballStructure, tc.tarExtension, tmpDir)
+			mock, tarball, gotCalled := mockServer(tc.responseStatus, tc.tarballStructure, tc.tarExtension, tmpDir)
 			if tc.alreadyLocal {
 				createLocalFile(envoyLocation)
 				createLocalFile(libLocation)
 			}
 
-			r := &Runtime{fetcher: fetcher{tmpDir}}
-			err := r.Fetch(tc.key, mock.URL+"/"+tc.tarballStructure+tc.tarExtension)
+			r := &Runtime{fetcher: fetcher{store: tmpDir}}
+			build := &manifest.Build{DownloadLocationURL: mock.URL + "/" + tc.tarballStructure + tc.tarExtension}
+			if tc.checksum != nil {
+				build.SHA256 = tc.checksum(tarball)
+			}
+			manifest.SetInsecureSkipVerify(tc.skipVerify)
+			defer manifest.SetInsecureSkipVerify(false)
+			err := r.Fetch(tc.key, build)
 			if tc.wantErr {
 				assert.Error(t, err)
+				_, statErr := os.Stat(r.platformDirectory(tc.key))
+				assert.True(t, os.IsNotExist(statErr), "expected nothing to be extracted into the store")
 			} else {
 				assert.Nil(t, err)
 				for _, location := range []string{libLocation, envoyLocation} {
@@ -115,10 +179,139 @@ func TestRuntime_Fetch(t *testing.T) {
 				}
 			}
 			assert.Equal(t, tc.wantServerCalled, *gotCalled, "mismatch of expectations for calling of remote server")
+			quarantined, _ := filepath.Glob(filepath.Join(r.quarantineStore(), "standard", "1.11.0", "darwin", "*.tar.gz"))
+			assert.Equal(t, tc.wantQuarantined, len(quarantined) == 1, "mismatch of expectations for quarantine of the tarball")
 		})
 	}
 }
 
+func sha256Of(path string) string {
+	bytes, _ := ioutil.ReadFile(path)
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRuntime_FetchConcurrently(t *testing.T) {
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "darwin"}
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+
+	var calls int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		// give the other fetch a chance to run into the lock
+		time.Sleep(100 * time.Millisecond)
+		http.ServeFile(w, r, tarball)
+	}))
+	defer mock.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			r := &Runtime{fetcher: fetcher{store: tmpDir}}
+			errs <- r.Fetch(key, &manifest.Build{
+				DownloadLocationURL: mock.URL + "/envoy.tar.gz",
+				Digest:              manifest.Digest{SHA256: sha256Of(tarball)},
+			})
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "expected the build to be downloaded only once")
+	f, _ := ioutil.ReadFile(filepath.Join(tmpDir, "builds/standard/1.11.0/darwin/bin/envoy"))
+	assert.Contains(t, string(f), "some c++")
+}
+
+func TestRuntime_FetchFromMirror(t *testing.T) {
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "darwin"}
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mock.Close()
+
+	r := &Runtime{fetcher: fetcher{store: tmpDir}}
+	err := r.Fetch(key, &manifest.Build{
+		DownloadLocationURL: mock.URL + "/envoy.tar.gz",
+		Mirrors:             []string{"file://" + filepath.ToSlash(tarball)},
+		Digest:              manifest.Digest{SHA256: sha256Of(tarball)},
+	})
+	assert.NoError(t, err)
+	f, _ := ioutil.ReadFile(filepath.Join(r.platformDirectory(key), envoyLocation))
+	assert.Contains(t, string(f), "some c++")
+	_, err = os.Stat(tarball)
+	assert.NoError(t, err, "expected the archive of a file mirror to be left in place")
+}
+
+func TestRuntime_FetchFallsBackOnChecksumMismatch(t *testing.T) {
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "darwin"}
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+	tampered := filepath.Join(tmpDir, "tampered.tar.gz")
+	ioutil.WriteFile(tampered, []byte("not envoy"), 0600)
+	// the mirror serves an archive of its own, which has a checksum of its own
+	mirrored := filepath.Join(tmpDir, "envoy.tar.xz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, mirrored)
+
+	r := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "store")}}
+	err := r.Fetch(key, &manifest.Build{
+		DownloadLocationURL: "file://" + filepath.ToSlash(tampered),
+		Mirrors:             []string{"file://" + filepath.ToSlash(mirrored)},
+		MirrorDigests:       map[string]manifest.Digest{"file://" + filepath.ToSlash(mirrored): {SHA256: sha256Of(mirrored)}},
+		Digest:              manifest.Digest{SHA256: sha256Of(tarball)},
+	})
+	assert.NoError(t, err)
+	f, _ := ioutil.ReadFile(filepath.Join(r.platformDirectory(key), envoyLocation))
+	assert.Contains(t, string(f), "some c++")
+	quarantined, _ := filepath.Glob(filepath.Join(r.quarantineStore(), "standard", "1.11.0", "darwin", "*"))
+	assert.Len(t, quarantined, 1, "expected the tampered archive to be quarantined")
+}
+
+func TestRuntime_FetchOffline(t *testing.T) {
+	manifest.SetOffline(true)
+	defer manifest.SetOffline(false)
+	key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "darwin"}
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	r := &Runtime{fetcher: fetcher{store: tmpDir}}
+
+	err := r.Fetch(key, &manifest.Build{DownloadLocationURL: "http://127.0.0.1:0/envoy.tar.gz"})
+	assert.Error(t, err, "expected builds not to be downloaded in offline mode")
+
+	createLocalFile(filepath.Join(r.platformDirectory(key), envoyLocation))
+	assert.NoError(t, r.Fetch(key, nil), "expected downloaded builds to be available in offline mode")
+}
+
+func TestResolve(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	source := &manifest.Manifest{}
+	for _, version := range []string{"1.11.0", "1.11.1"} {
+		source.SetBuild(&manifest.Key{Flavor: "standard", Version: version, Platform: "DARWIN"}, &manifest.Build{DownloadLocationURL: "file:///envoy.tar.gz"})
+	}
+	data, _ := source.Encode()
+	manifestFile := filepath.Join(tmpDir, "manifest.json")
+	ioutil.WriteFile(manifestFile, data, 0600)
+	defer func(originalURLs []string) {
+		manifest.SetURLs(originalURLs...) //nolint
+	}(manifest.GetURLs())
+	manifest.SetURL(manifestFile)
+
+	out := new(bytes.Buffer)
+	key, err := Resolve(&manifest.Key{Flavor: "standard", Version: "1.11.x", Platform: "DARWIN"}, NewProgress(ProgressNone, out))
+	assert.NoError(t, err)
+	assert.Equal(t, "standard:1.11.1/darwin", key.String())
+	assert.Equal(t, "resolved standard:1.11.x/darwin to standard:1.11.1/darwin\n", out.String())
+}
+
 func createLocalFile(location string) {
 	dir, _ := filepath.Split(location)
 	os.MkdirAll(dir, 0750)
@@ -127,16 +320,18 @@ func createLocalFile(location string) {
 	f.Close()
 }
 
-func mockServer(responseStatusCode int, tarballStructure, tarExtension, tmpDir string) (*httptest.Server, *bool) {
+func mockServer(responseStatusCode int, tarballStructure, tarExtension, tmpDir string) (*httptest.Server, string, *bool) {
 	called := false
+	tarball := filepath.Join(tmpDir, tarballStructure+tarExtension)
+	if tarballStructure != "" {
+		archiver.Archive([]string{filepath.Join("testdata", tarballStructure)}, tarball)
+	}
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		called = true
 		w.WriteHeader(responseStatusCode)
 		if responseStatusCode == http.StatusOK {
-			tarball := filepath.Join(tmpDir, tarballStructure+tarExtension)
-			archiver.Archive([]string{filepath.Join("testdata", tarballStructure)}, tarball)
 			bytes, _ := ioutil.ReadFile(tarball)
 			w.Write(bytes)
 		}
-	})), &called
+	})), tarball, &called
 }
@@ -0,0 +1,147 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"sync"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+// FetchStatus is the outcome of fetching a single build with FetchAll
+type FetchStatus string
+
+const (
+	// Fetched means that the build has been downloaded
+	Fetched FetchStatus = "fetched"
+	// Skipped means that the build had already been downloaded
+	Skipped FetchStatus = "skipped"
+	// Failed means that the build couldn't be fetched
+	Failed FetchStatus = "failed"
+)
+
+// FetchResult describes the outcome of fetching a single build with FetchAll
+type FetchResult struct {
+	// Reference is the full reference of the build, e.g. `standard:1.17.0/darwin`
+	Reference string
+	Status    FetchStatus
+	// Err is the reason of a failure
+	Err error
+}
+
+// fetchJob is a single build to fetch, identified by either a manifest key or an OCI reference
+type fetchJob struct {
+	key       *manifest.Key
+	reference string
+	result    *FetchResult
+}
+
+// FetchAll fetches the builds with the passed references, for each of the passed platforms unless a reference
+// has a platform of its own, with at most concurrency downloads in parallel.
+// A failure to fetch one build doesn't stop the others. Results are in the order of references and platforms,
+// with a single one for a build that several references resolve to, e.g. `standard:1.17.0` and `standard:1.17.x`.
+func (r *Runtime) FetchAll(references, platforms []string, concurrency int) []*FetchResult {
+	jobs := make([]*fetchJob, 0, len(references))
+	results := make([]*FetchResult, 0, len(references))
+	scheduled := make(map[string]bool)
+	for _, reference := range references {
+		if IsOCIReference(reference) {
+			if scheduled[reference] {
+				continue
+			}
+			scheduled[reference] = true
+			result := &FetchResult{Reference: reference}
+			jobs = append(jobs, &fetchJob{reference: reference, result: result})
+			results = append(results, result)
+			continue
+		}
+		keys, err := manifest.NewKeys(reference, platforms...)
+		if err != nil {
+			results = append(results, &FetchResult{Reference: reference, Status: Failed, Err: err})
+			continue
+		}
+		for _, key := range keys {
+			// ranges are resolved upfront, so that they are printed before any download progress
+			resolved, err := resolve(key, r.progress())
+			if err != nil {
+				results = append(results, &FetchResult{Reference: key.String(), Status: Failed, Err: err})
+				continue
+			}
+			if scheduled[resolved.String()] {
+				continue
+			}
+			scheduled[resolved.String()] = true
+			result := &FetchResult{Reference: resolved.String()}
+			jobs = append(jobs, &fetchJob{key: resolved, result: result})
+			results = append(results, result)
+		}
+	}
+
+	// the manifest is only needed to download builds that are not available locally
+	var loadOnce sync.Once
+	var source *manifest.Manifest
+	var sourceErr error
+	locate := func(key *manifest.Key) (*manifest.Build, error) {
+		loadOnce.Do(func() {
+			source, sourceErr = manifest.Load()
+		})
+		if sourceErr != nil {
+			return nil, sourceErr
+		}
+		return manifest.LocateBuild(key, source)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		job := job
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			job.result.Status, job.result.Err = r.fetchJob(job, locate)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (r *Runtime) fetchJob(job *fetchJob, locate func(*manifest.Key) (*manifest.Build, error)) (FetchStatus, error) {
+	if job.key == nil {
+		_, fetched, err := r.fetchOCI(job.reference)
+		switch {
+		case err != nil:
+			return Failed, err
+		case !fetched:
+			return Skipped, nil
+		}
+		return Fetched, nil
+	}
+	if r.AlreadyDownloaded(job.key) {
+		return Skipped, nil
+	}
+	build, err := locate(job.key)
+	if err != nil {
+		return Failed, err
+	}
+	if err := r.Fetch(job.key, build); err != nil {
+		return Failed, err
+	}
+	return Fetched, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archiver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+func TestRuntime_FetchAll(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	require.NoError(t, archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball))
+
+	source := &manifest.Manifest{}
+	for _, platform := range []string{"LINUX_GLIBC", "DARWIN"} {
+		key := &manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: platform}
+		source.SetBuild(key, &manifest.Build{DownloadLocationURL: "file://" + filepath.ToSlash(tarball), Digest: manifest.Digest{SHA256: sha256Of(tarball)}})
+	}
+	data, err := source.Encode()
+	require.NoError(t, err)
+	manifestFile := filepath.Join(tmpDir, "manifest.json")
+	require.NoError(t, ioutil.WriteFile(manifestFile, data, 0600))
+	defer func(originalURLs []string) {
+		manifest.SetURLs(originalURLs...) //nolint
+	}(manifest.GetURLs())
+	require.NoError(t, manifest.SetURL(manifestFile))
+
+	r := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "store")}}
+	createLocalFile(filepath.Join(r.platformDirectory(&manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "DARWIN"}), envoyLocation))
+
+	results := r.FetchAll([]string{"standard:1.11.0", "invalid", "standard:1.10.0/darwin"}, []string{"linux-glibc", "darwin"}, 2)
+	require.Len(t, results, 4)
+	assert.Equal(t, &FetchResult{Reference: "standard:1.11.0/linux-glibc", Status: Fetched}, results[0])
+	assert.Equal(t, &FetchResult{Reference: "standard:1.11.0/darwin", Status: Skipped}, results[1])
+	assert.Equal(t, "invalid", results[2].Reference)
+	assert.Equal(t, Failed, results[2].Status)
+	assert.Error(t, results[2].Err)
+	assert.Equal(t, "standard:1.10.0/darwin", results[3].Reference)
+	assert.Equal(t, Failed, results[3].Status, "expected builds missing from the manifest to fail")
+	assert.Error(t, results[3].Err)
+
+	f, _ := ioutil.ReadFile(filepath.Join(r.platformDirectory(&manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "LINUX_GLIBC"}), envoyLocation))
+	assert.Contains(t, string(f), "some c++")
+
+	// a reference and a range it matches are the same build
+	r = &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "other")}}
+	results = r.FetchAll([]string{"standard:1.11.0", "standard:1.11.x", "standard:1.11.0/darwin"}, []string{"linux-glibc", "darwin"}, 2)
+	require.Len(t, results, 2)
+	assert.Equal(t, &FetchResult{Reference: "standard:1.11.0/linux-glibc", Status: Fetched}, results[0])
+	assert.Equal(t, &FetchResult{Reference: "standard:1.11.0/darwin", Status: Fetched}, results[1])
+}
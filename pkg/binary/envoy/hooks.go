@@ -0,0 +1,168 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+	"github.com/tetratelabs/log"
+)
+
+// HookPhase is the point in the lifecycle of Envoy at which a hook is run
+type HookPhase string
+
+const (
+	// PreStart hooks are run before Envoy is started, e.g. to write its bootstrap or append args to it
+	PreStart HookPhase = "pre-start"
+	// PostReady hooks are run every time Envoy has become ready, i.e. again once it is restarted
+	PostReady HookPhase = "post-ready"
+	// PreTermination hooks are run just before GetEnvoy instructs a running Envoy to terminate
+	PreTermination HookPhase = "pre-termination"
+	// PostExit hooks are run once Envoy has terminated, however it did, and before its debug data is archived
+	PostExit HookPhase = "post-exit"
+)
+
+// HookFailurePolicy decides what happens once a hook fails or times out
+type HookFailurePolicy int
+
+const (
+	// ContinueOnFailure logs why the hook failed and carries on with the next one
+	ContinueOnFailure HookFailurePolicy = iota
+	// AbortOnFailure skips the remaining hooks of the phase.
+	// A failed pre-start hook also prevents Envoy from starting, and Start returns why it failed.
+	// A failed post-ready hook also stops Envoy, and Err returns why it failed.
+	AbortOnFailure
+)
+
+// Hook is a named function that is run at a phase of the lifecycle of Envoy
+type Hook struct {
+	// Name identifies the hook in logs and errors, it defaults to the name of the Run function
+	Name string
+	// Phase is when the hook is run
+	Phase HookPhase
+	// Order sorts the hooks of a phase, lower first, hooks of the same order are run in the order they were registered
+	Order int
+	// OnFailure decides whether a failure of the hook is only logged or aborts the phase
+	OnFailure HookFailurePolicy
+	// Timeout is how long the hook is waited for before it is considered failed, 0 means no limit
+	// The context passed to Run is canceled once it expires, the hook is expected to return soon afterwards
+	Timeout time.Duration
+	// Run does the work of the hook
+	Run func(ctx context.Context, r binary.Runner) error
+}
+
+// RegisterHook registers the passed hooks to be run at their phases of the lifecycle of Envoy
+// It panics if a hook has no Run function or an unknown phase as it would never be run otherwise
+func (r *Runtime) RegisterHook(hooks ...Hook) {
+	for _, h := range hooks {
+		if h.Run == nil {
+			panic(fmt.Sprintf("hook %q has no Run function", h.Name))
+		}
+		switch h.Phase {
+		case PreStart, PostReady, PreTermination, PostExit:
+		default:
+			panic(fmt.Sprintf("hook %q has an unknown phase %q", h.Name, h.Phase))
+		}
+		if h.Name == "" {
+			h.Name = funcName(h.Run)
+		}
+		if r.hooks == nil {
+			r.hooks = make(map[HookPhase][]Hook)
+		}
+		phase := append(r.hooks[h.Phase], h)
+		sort.SliceStable(phase, func(i, j int) bool { return phase[i].Order < phase[j].Order })
+		r.hooks[h.Phase] = phase
+	}
+}
+
+// legacyHooks turns functions registered via RegisterPreStart or RegisterPreTermination into hooks
+// whose failures are only logged, as they have always been
+func legacyHooks(phase HookPhase, fns []func(binary.Runner) error) []Hook {
+	hooks := make([]Hook, 0, len(fns))
+	for _, fn := range fns {
+		f := fn
+		hooks = append(hooks, Hook{
+			Name:  funcName(f),
+			Phase: phase,
+			Run:   func(_ context.Context, r binary.Runner) error { return f(r) },
+		})
+	}
+	return hooks
+}
+
+// runHooks runs the hooks of the phase in order, and returns why the first one that aborts the phase failed
+func (r *Runtime) runHooks(ctx context.Context, phase HookPhase) error {
+	for _, h := range r.hooks[phase] {
+		err := h.run(ctx, r)
+		if err == nil {
+			continue
+		}
+		if h.OnFailure == AbortOnFailure {
+			return fmt.Errorf("%v hook %q failed: %v", phase, h.Name, err)
+		}
+		log.Errorf("%v hook %q failed: %v", phase, h.Name, err)
+	}
+	return nil
+}
+
+// handlePostReady runs the post-ready hooks and stops Envoy if one of them aborts
+func (r *Runtime) handlePostReady(ctx context.Context) {
+	if err := r.runHooks(ctx, PostReady); err != nil {
+		log.Errorf("Stopping Envoy as %v", err)
+		r.lifecycle.mu.Lock()
+		r.lifecycle.hookErr = err
+		r.lifecycle.mu.Unlock()
+		r.requestStop()
+	}
+}
+
+// run runs the hook, giving up on it once its timeout expires
+func (h *Hook) run(ctx context.Context, r binary.Runner) error {
+	if h.Timeout <= 0 {
+		return h.Run(ctx, r)
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- h.Run(ctx, r)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %v", h.Timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// funcName returns the name of a function without its package path, e.g. controlplane.writeBootstrap
+func funcName(f interface{}) string {
+	fn := goruntime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+	"github.com/tetratelabs/getenvoy/pkg/common"
+)
+
+func TestRuntime_RegisterHook(t *testing.T) {
+	r := &Runtime{}
+	noop := func(context.Context, binary.Runner) error { return nil }
+	r.RegisterHook(
+		Hook{Name: "c", Phase: PreStart, Order: 2, Run: noop},
+		Hook{Name: "a", Phase: PreStart, Run: noop},
+		Hook{Name: "b", Phase: PreStart, Order: 1, Run: noop},
+	)
+	r.RegisterHook(Hook{Name: "a2", Phase: PreStart, Run: noop})
+	r.RegisterPreStart(func(binary.Runner) error { return nil })
+
+	var names []string
+	for _, h := range r.hooks[PreStart] {
+		names = append(names, h.Name)
+	}
+	require.Len(t, names, 5)
+	assert.Equal(t, []string{"a", "a2"}, names[:2])
+	assert.Contains(t, names[2], "envoy.TestRuntime_RegisterHook", "expected a legacy hook to be named after its function")
+	assert.Equal(t, []string{"b", "c"}, names[3:])
+
+	assert.Panics(t, func() { r.RegisterHook(Hook{Name: "unknown", Phase: "post-start", Run: noop}) })
+	assert.Panics(t, func() { r.RegisterHook(Hook{Name: "nothing", Phase: PostExit}) })
+}
+
+func TestRuntime_StartPathHookFailures(t *testing.T) {
+	failing := func(context.Context, binary.Runner) error { return errors.New("no bootstrap") }
+	blocking := func(ctx context.Context, _ binary.Runner) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr string
+	}{
+		{
+			name: "failure is logged",
+			hook: Hook{Name: "bootstrap", Phase: PreStart, Run: failing},
+		},
+		{
+			name:    "failure aborts starting Envoy",
+			hook:    Hook{Name: "bootstrap", Phase: PreStart, OnFailure: AbortOnFailure, Run: failing},
+			wantErr: `unable to start Envoy: pre-start hook "bootstrap" failed: no bootstrap`,
+		},
+		{
+			name:    "timeout aborts starting Envoy",
+			hook:    Hook{Name: "bootstrap", Phase: PreStart, OnFailure: AbortOnFailure, Timeout: 50 * time.Millisecond, Run: blocking},
+			wantErr: `unable to start Envoy: pre-start hook "bootstrap" failed: timed out after 50ms`,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+			defer os.RemoveAll(tmpDir)
+			next := false
+			r.RegisterHook(tc.hook, Hook{Name: "next", Phase: PreStart, Order: 1, Run: func(context.Context, binary.Runner) error {
+				next = true
+				return nil
+			}})
+
+			err := r.StartPath(context.Background(), filepath.Join("testdata", "sleep.sh"), nil)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				assert.True(t, next, "expected the next hook to be run")
+				time.Sleep(100 * time.Millisecond) // give the script a chance to set up its traps
+				require.NoError(t, r.Stop(context.Background()))
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr)
+			assert.False(t, next, "expected the remaining hooks to be skipped")
+			assert.Equal(t, binary.StatusTerminated, r.Status())
+			_, err = r.GetPid()
+			assert.Error(t, err, "expected Envoy not to be started")
+			<-r.Done()
+		})
+	}
+}
+
+func TestRuntime_PostReadyHook(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer admin.Close()
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	useAdmin(r, admin)
+	r.RegisterHook(Hook{Name: "register", Phase: PostReady, OnFailure: AbortOnFailure, Run: func(_ context.Context, r binary.Runner) error {
+		if r.Status() != binary.StatusReady {
+			t.Error("post-ready hook was called before Envoy was ready")
+		}
+		return errors.New("registry is down")
+	}})
+
+	require.NoError(t, r.StartPath(context.Background(), filepath.Join("testdata", "sleep.sh"), nil))
+	select {
+	case <-r.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected a failed post-ready hook to stop Envoy")
+	}
+	assert.EqualError(t, r.Err(), `post-ready hook "register" failed: registry is down`)
+}
+
+func TestRuntime_PostExitHookAfterCrash(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	var mu sync.Mutex
+	var called []HookPhase
+	record := func(phase HookPhase) Hook {
+		return Hook{Phase: phase, Run: func(_ context.Context, r binary.Runner) error {
+			mu.Lock()
+			defer mu.Unlock()
+			called = append(called, phase)
+			if phase == PostExit {
+				_, err := os.Stat(r.DebugStore())
+				assert.NoError(t, err, "expected post-exit hooks to be run before the debug store is archived")
+			}
+			return nil
+		}}
+	}
+	r.RegisterHook(record(PreStart), record(PreTermination), record(PostExit))
+
+	err := r.RunPath(filepath.Join("testdata", "sleep.sh"), []string{"error"})
+	require.NoError(t, err)
+	assert.EqualError(t, r.Err(), "exit status 1")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []HookPhase{PreStart, PostExit}, called, "expected only post-exit hooks to be run once Envoy crashed")
+}
+
+func TestRuntime_StartPathAbortCleansUp(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	defer func(homeDir string) { common.HomeDir = homeDir }(common.HomeDir)
+	common.HomeDir = tmpDir
+	r.RootDir = tmpDir
+	RecordInstance(InstanceOptions{ID: "5c0a7e"})(r)
+	postExit := false
+	r.RegisterHook(Hook{Name: "bootstrap", Phase: PreStart, OnFailure: AbortOnFailure, Run: func(context.Context, binary.Runner) error {
+		instances, _ := Instances()
+		assert.Len(t, instances, 1, "expected the instance to be recorded before the next pre-start hook")
+		return errors.New("no bootstrap")
+	}}, Hook{Name: "collect", Phase: PostExit, Run: func(context.Context, binary.Runner) error {
+		postExit = true
+		return nil
+	}})
+
+	err := r.StartPath(context.Background(), filepath.Join("testdata", "sleep.sh"), nil)
+	assert.EqualError(t, err, `unable to start Envoy: pre-start hook "bootstrap" failed: no bootstrap`)
+	<-r.Done()
+
+	// an embedder that keeps running mustn't leave a record of an instance that never started behind
+	instances, err := Instances()
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+	_, err = os.Stat(filepath.Join(stateDir(tmpDir), "5c0a7e.json"))
+	assert.True(t, os.IsNotExist(err), "expected the record of the instance to be removed")
+	assert.True(t, postExit, "expected post-exit hooks to be run")
+	assert.FileExists(t, r.DebugStore()+".tar.gz", "expected the debug store to be archived")
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+// HotRestartOptions controls hot restarts of Envoy, which reload its configuration without dropping connections.
+// See https://www.envoyproxy.io/docs/envoy/latest/intro/arch_overview/operations/hot_restart
+type HotRestartOptions struct {
+	// Enabled starts Envoy with `--restart-epoch`, so that a new epoch can be started by Reload,
+	// or by `getenvoy reload` once the instance is recorded, see RecordInstance
+	Enabled bool
+	// BaseID is passed to Envoy as `--base-id`, it must differ between Envoy instances hot restarted on the same host
+	// It is also passed with hot restart disabled once it is allocated, see InstanceOptions
+	BaseID int
+}
+
+// Epoch is an Envoy process started by a hot restart.
+type Epoch struct {
+	// Epoch is the value of `--restart-epoch` the process was started with
+	Epoch int
+	Pid   int
+	// Live is false while the process is draining connections before it is shut down by the next epoch
+	Live bool
+}
+
+// drainingEpoch is an Envoy process that has been superseded by a newer epoch and hasn't exited yet.
+type drainingEpoch struct {
+	epoch int
+	cmd   *exec.Cmd
+}
+
+// Reload starts a new epoch of Envoy with the current configuration, which takes over from the live one.
+// The previous epoch drains its connections and is shut down by the new one.
+func (r *Runtime) Reload() error {
+	if !r.HotRestart.Enabled {
+		return errors.New("only Envoy run with hot restart enabled can be reloaded")
+	}
+	select {
+	case r.reloads <- struct{}{}:
+	default: // a reload is already pending
+	}
+	return nil
+}
+
+// Epochs returns the Envoy processes that are running, i.e. the live epoch and the ones still draining.
+func (r *Runtime) Epochs() []Epoch {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	epochs := make([]Epoch, 0, len(l.draining)+1)
+	for _, d := range l.draining {
+		epochs = append(epochs, Epoch{Epoch: d.epoch, Pid: d.cmd.Process.Pid})
+	}
+	if r.cmd != nil && r.cmd.Process != nil && l.status != binary.StatusTerminated {
+		epochs = append(epochs, Epoch{Epoch: l.epoch, Pid: r.cmd.Process.Pid, Live: true})
+	}
+	return epochs
+}
+
+// epochCmd returns a command to start Envoy with the args of base, which is never started itself,
+// so that Envoy can be started again after a crash or a reload.
+func (r *Runtime) epochCmd(base *exec.Cmd, epoch int) *exec.Cmd {
+	cmd := restartCmd(base)
+	if r.HotRestart.Enabled {
+		cmd.Args = append(append([]string{}, base.Args...),
+			"--restart-epoch", strconv.Itoa(epoch), "--base-id", strconv.Itoa(r.HotRestart.BaseID))
+	} else if r.baseIDAssigned {
+		cmd.Args = append(append([]string{}, base.Args...), "--base-id", strconv.Itoa(r.HotRestart.BaseID))
+	}
+	return cmd
+}
+
+// startEpoch starts cmd as the next epoch of Envoy, which becomes the live one, unless GetEnvoy is terminating.
+func (r *Runtime) startEpoch(cmd *exec.Cmd) error {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping {
+		return errStopping
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	l.draining = append(l.draining, drainingEpoch{epoch: l.epoch, cmd: r.cmd})
+	r.cmd = cmd
+	l.epoch++
+	return nil
+}
+
+// drained forgets an epoch that has exited after it was superseded by a newer one.
+func (r *Runtime) drained(cmd *exec.Cmd) (epoch int) {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, d := range l.draining {
+		if d.cmd == cmd {
+			l.draining = append(l.draining[:i], l.draining[i+1:]...)
+			return d.epoch
+		}
+	}
+	return -1
+}
+
+// signalDraining sends the signal to the previous epochs that haven't exited yet.
+func (r *Runtime) signalDraining(sig os.Signal) {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, d := range l.draining {
+		log.Infof("Sending Envoy process (PID=%d) of epoch %d %v", d.cmd.Process.Pid, d.epoch, sig)
+		d.cmd.Process.Signal(sig) //nolint
+	}
+}
+
+// liveEpoch returns the epoch of the live Envoy process.
+func (r *Runtime) liveEpoch() int {
+	r.lifecycle.mu.Lock()
+	defer r.lifecycle.mu.Unlock()
+	return r.lifecycle.epoch
+}
+
+// HotRestartPIDs returns the IDs of GetEnvoy processes that run Envoy with hot restart enabled, i.e. can be reloaded.
+// They are told apart by the records of running instances, see RecordInstance, which are only trusted while
+// GetEnvoy holds their lock, so that an unrelated process that reused the ID of one is never taken for it.
+func HotRestartPIDs() ([]int, error) {
+	instances, err := Instances()
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(instances))
+	for _, instance := range instances {
+		if instance.HotRestart {
+			pids = append(pids, instance.PID)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// processAlive checks whether a process with the passed ID exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+	"github.com/tetratelabs/getenvoy/pkg/common"
+)
+
+func TestRuntime_Reload(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	defer func(homeDir string) { common.HomeDir = homeDir }(common.HomeDir)
+	common.HomeDir = tmpDir
+	r.RootDir = tmpDir
+	r.HotRestart = HotRestartOptions{Enabled: true, BaseID: 7}
+	RecordInstance(InstanceOptions{})(r)
+
+	var runErr error
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = r.RunPath(filepath.Join("testdata", "sleep.sh"), nil)
+	}()
+	r.Wait(binary.StatusStarted)
+	assert.Eventually(t, func() bool {
+		pids, err := HotRestartPIDs()
+		return err == nil && len(pids) == 1 && pids[0] == os.Getpid()
+	}, 5*time.Second, 10*time.Millisecond, "expected the GetEnvoy process to be recorded as reloadable")
+
+	r.SendSignal(syscall.SIGHUP)
+	require.Eventually(t, func() bool { return len(r.Epochs()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	epochs := r.Epochs()
+	assert.Equal(t, []bool{false, true}, []bool{epochs[0].Live, epochs[1].Live})
+	assert.Equal(t, []int{0, 1}, []int{epochs[0].Epoch, epochs[1].Epoch})
+	pid, _ := r.GetPid()
+	assert.Equal(t, epochs[1].Pid, pid, "expected the live epoch to be the process of the runtime")
+	assert.Equal(t, []string{"--restart-epoch", "1", "--base-id", "7"}, r.cmd.Args[1:])
+
+	// the new epoch shuts down the previous one once it has drained its connections
+	parent, _ := os.FindProcess(epochs[0].Pid)
+	require.NoError(t, parent.Signal(syscall.SIGINT))
+	require.Eventually(t, func() bool { return len(r.Epochs()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []Epoch{{Epoch: 1, Pid: pid, Live: true}}, r.Epochs())
+	assert.Equal(t, binary.StatusStarted, r.Status(), "expected a reload not to be a restart")
+
+	r.SendSignal(syscall.SIGINT)
+	wg.Wait()
+	assert.NoError(t, runErr)
+	pids, _ := HotRestartPIDs()
+	assert.Empty(t, pids, "expected the GetEnvoy process to be forgotten once it exits")
+}
+
+func TestHotRestartPIDs_IgnoresUnlockedRecords(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	defer func(homeDir string) { common.HomeDir = homeDir }(common.HomeDir)
+	common.HomeDir = tmpDir
+
+	// a GetEnvoy process that was killed leaves its record behind, and its process ID might have been reused since
+	path := filepath.Join(stateDir(tmpDir), "5c0a7e.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+	require.NoError(t, writeInstance(path, &Instance{ID: "5c0a7e", PID: os.Getpid(), HotRestart: true}))
+
+	pids, err := HotRestartPIDs()
+	require.NoError(t, err)
+	assert.Empty(t, pids, "expected a process that doesn't hold the lock of the record not to be reloaded")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "expected the stale record to be cleaned up")
+}
+
+func TestRuntime_ReloadRequiresHotRestart(t *testing.T) {
+	r := &Runtime{}
+	assert.EqualError(t, r.Reload(), "only Envoy run with hot restart enabled can be reloaded")
+
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	r.HotRestart = HotRestartOptions{Enabled: true}
+	err := r.RunPath(filepath.Join("testdata", "sleep.sh"), []string{"--restart-epoch", "3"})
+	assert.EqualError(t, err, "--restart-epoch is managed by GetEnvoy when hot restart is enabled")
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archiver"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+// CustomFlavor is the flavor that local Envoy releases run by path are registered under
+const CustomFlavor = "custom"
+
+// IsRelease returns true if there is a local Envoy release at the passed path, either a tarball or an extracted
+// release directory, rather than an Envoy executable
+func IsRelease(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		return true
+	}
+	format, err := archiver.ByExtension(path)
+	if err != nil {
+		return false
+	}
+	_, ok := format.(archiver.Walker)
+	return ok
+}
+
+// NewReleaseKey returns the key that the local Envoy release at the passed path is registered under by FetchAndRun,
+// e.g. `custom:envoy-1.17-custom` for `./envoy-1.17-custom.tar.xz`
+func NewReleaseKey(path string) (*manifest.Key, error) {
+	name := filepath.Base(filepath.Clean(path))
+	name = strings.TrimSuffix(name, archiveExt(name))
+	return manifest.NewKey(CustomFlavor + ":" + unsafeKeyChars.ReplaceAllString(name, "_"))
+}
+
+// archiveExt returns the file extension that lets archiver recognize the format of the archive at the passed path,
+// e.g. `.tar.xz`, or an empty string if there is none
+func archiveExt(path string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.bz2", ".tbz2", ".tar.lz4", ".tlz4", ".tar.sz", ".tsz", ".tar", ".zip"} {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Import registers the local Envoy release at the passed path under the passed key in the binary store, replacing
+// the build that might already be registered under it, so that it can be run and shared by reference.
+// The release is either a tarball or an extracted release directory with the same `bin/` and `lib/` layout
+// as GetEnvoy builds.
+// It returns false without touching the store if the same release is already registered under the key, and refuses
+// to replace a build that is in use by a running Envoy.
+func (r *Runtime) Import(key *manifest.Key, path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("unable to stat %q: %v", path, err)
+	}
+	unlock, err := osutil.LockFile(r.lockFile(key), func() {
+		r.progress().Printf("waiting for another GetEnvoy process to finish fetching %v", key)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer unlock() //nolint
+	sum, err := releaseChecksum(path, info)
+	if err != nil {
+		return false, fmt.Errorf("unable to compute the checksum of %v: %v", path, err)
+	}
+	if digest, err := r.originalDigest(key); err == nil && digest.SHA256 == sum && r.AlreadyDownloaded(key) {
+		return false, nil
+	}
+	tmpDir, err := r.tempDir("import-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tmpDir) //nolint
+	if !info.IsDir() {
+		// the build keeps the archive it is installed from, which mustn't change along with the one of the user
+		tarball := filepath.Join(tmpDir, filepath.Base(path))
+		if err := copyFile(path, tarball, 0600); err != nil {
+			return false, fmt.Errorf("unable to copy envoy from %v: %v", path, err)
+		}
+		return true, r.install(key, tarball, manifest.Digest{SHA256: sum})
+	}
+	if err := copyRelease(path, tmpDir); err != nil {
+		return false, fmt.Errorf("unable to copy envoy from %v: %v", path, err)
+	}
+	if err := recordDigest(tmpDir, manifest.Digest{SHA256: sum}); err != nil {
+		return false, fmt.Errorf("unable to record the checksum of %v: %v", path, err)
+	}
+	return true, r.moveIntoStore(key, tmpDir)
+}
+
+// releaseChecksum returns the SHA256 of the release tarball at the passed path, or a SHA256 over the names, modes and
+// contents of the `bin/` and `lib/` directories of an extracted release directory
+func releaseChecksum(path string, info os.FileInfo) (string, error) {
+	if !info.IsDir() {
+		return sha256Hex(path)
+	}
+	h := sha256.New()
+	for _, name := range []string{"bin", "lib"} {
+		root := filepath.Join(path, name)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(path, file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%v\x00", filepath.ToSlash(rel), info.Mode()) //nolint
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(file)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "%s\x00", link) //nolint
+			case info.Mode().IsRegular():
+				sum, err := sha256Hex(file)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "%s\x00", sum) //nolint
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyRelease copies the `bin/` and `lib/` directories of the release at src into dst
+func copyRelease(src, dst string) error {
+	if _, err := os.Stat(filepath.Join(src, envoyLocation)); err != nil {
+		return fmt.Errorf("no Envoy binary in %v", src)
+	}
+	for _, name := range []string{"bin", "lib"} {
+		root := filepath.Join(src, name)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dst, rel)
+			switch {
+			case info.IsDir():
+				return os.MkdirAll(target, 0750)
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				return os.Symlink(link, target)
+			case info.Mode().IsRegular():
+				return copyFile(path, target, info.Mode().Perm())
+			default:
+				return fmt.Errorf("%v is not a regular file", path)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
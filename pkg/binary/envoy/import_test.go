@@ -0,0 +1,115 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mholt/archiver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+func TestNewReleaseKey(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "./envoy-1.17-custom.tar.xz", want: "envoy-1.17-custom"},
+		{path: "/tmp/envoy.tar.gz", want: "envoy"},
+		{path: "builds/envoy 1.17+patch/", want: "envoy_1.17_patch"},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.path, func(t *testing.T) {
+			key, err := NewReleaseKey(tc.path)
+			require.NoError(t, err)
+			assert.Equal(t, CustomFlavor, key.Flavor)
+			assert.Equal(t, tc.want, key.Version)
+		})
+	}
+}
+
+func TestRuntime_Import(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy-1.17-custom.tar.xz")
+	require.NoError(t, archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball))
+	dir := filepath.Join("testdata", "envoy")
+
+	assert.True(t, IsRelease(tarball))
+	assert.True(t, IsRelease(dir))
+	assert.False(t, IsRelease(filepath.Join(dir, envoyLocation)), "expected an executable not to be a release")
+	assert.False(t, IsRelease(filepath.Join(tmpDir, "missing.tar.gz")))
+
+	r := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "store")}}
+	for _, src := range []string{tarball, dir} {
+		key := &manifest.Key{Flavor: "acme", Version: filepath.Base(src), Platform: "LINUX_GLIBC"}
+		imported, err := r.Import(key, src)
+		require.NoError(t, err)
+		assert.True(t, imported)
+		assert.True(t, r.AlreadyDownloaded(key))
+		f, _ := ioutil.ReadFile(filepath.Join(r.platformDirectory(key), envoyLocation))
+		assert.Contains(t, string(f), "some c++")
+		_, err = os.Stat(filepath.Join(r.platformDirectory(key), "lib", "somelib"))
+		assert.NoError(t, err)
+	}
+
+	key := &manifest.Key{Flavor: "acme", Version: "empty", Platform: "LINUX_GLIBC"}
+	_, err := r.Import(key, tmpDir)
+	assert.Error(t, err, "expected a directory without an Envoy binary to be rejected")
+	assert.False(t, r.AlreadyDownloaded(key))
+}
+
+func TestRuntime_ImportKeepsUnchangedAndInUseBuilds(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	release := filepath.Join(tmpDir, "release")
+	require.NoError(t, copyRelease(filepath.Join("testdata", "envoy"), release))
+
+	r := &Runtime{fetcher: fetcher{store: filepath.Join(tmpDir, "store")}}
+	key := &manifest.Key{Flavor: "acme", Version: "1.17.0", Platform: "LINUX_GLIBC"}
+	imported, err := r.Import(key, release)
+	require.NoError(t, err)
+	require.True(t, imported)
+
+	// a running Envoy marks the build as in use
+	marker := filepath.Join(r.platformDirectory(key), inUsePrefix+strconv.Itoa(os.Getpid()))
+	require.NoError(t, ioutil.WriteFile(marker, nil, 0600))
+
+	imported, err = r.Import(key, release)
+	require.NoError(t, err, "expected importing the same release again not to touch the build")
+	assert.False(t, imported)
+	_, err = os.Stat(marker)
+	assert.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(release, "lib", "somelib"), []byte("patched"), 0600))
+	_, err = r.Import(key, release)
+	require.Error(t, err, "expected a build in use not to be replaced")
+	assert.Contains(t, err.Error(), "is in use by process")
+
+	require.NoError(t, os.Remove(marker))
+	imported, err = r.Import(key, release)
+	require.NoError(t, err)
+	assert.True(t, imported)
+	f, _ := ioutil.ReadFile(filepath.Join(r.platformDirectory(key), "lib", "somelib"))
+	assert.Equal(t, "patched", string(f))
+}
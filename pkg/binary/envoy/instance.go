@@ -0,0 +1,334 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+	"github.com/tetratelabs/getenvoy/pkg/common"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+// Instance is a record of a GetEnvoy process running Envoy, e.g. in the background via `getenvoy run --detach`.
+type Instance struct {
+	// ID and Name identify the instance, e.g. in `getenvoy stop` and `getenvoy logs`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// PID is the process ID of GetEnvoy, which stops Envoy gracefully once it receives SIGINT
+	PID int `json:"pid"`
+	// EnvoyPID is the process ID of the Envoy process started last
+	EnvoyPID int `json:"envoyPid"`
+	// Reference is the reference of the Envoy build or the path of the Envoy binary that is run
+	Reference string `json:"reference"`
+	// AdminAddress is where the admin listener of Envoy is, or empty if it isn't decided by GetEnvoy, see AdminAddress
+	AdminAddress string `json:"adminAddress,omitempty"`
+	// BaseID is the `--base-id` of Envoy, or -1 if it wasn't decided by GetEnvoy
+	BaseID int `json:"baseId"`
+	// HotRestart is set once GetEnvoy handles SIGHUP by hot restarting Envoy, see HotRestartPIDs
+	HotRestart bool   `json:"hotRestart,omitempty"`
+	DebugStore string `json:"debugStore"`
+	// AccessLog and ErrorLog are where the stdout and stderr of Envoy are captured, see debug.EnableEnvoyLogCollection
+	AccessLog string    `json:"accessLog"`
+	ErrorLog  string    `json:"errorLog"`
+	Started   time.Time `json:"started"`
+
+	// lock is held by the GetEnvoy process for as long as it runs the instance, see Running
+	lock string
+}
+
+// instanceFile is the name of the file in the debug store that holds the record of the instance that was run.
+const instanceFile = "envoy-instance.json"
+
+// stateDir is where GetEnvoy processes running Envoy are recorded while they are running, see RecordInstance.
+func stateDir(rootDir string) string {
+	return filepath.Join(rootDir, "state")
+}
+
+// instanceLockPath returns the lock that the GetEnvoy process running the instance with the passed record holds.
+func instanceLockPath(record string) string {
+	return strings.TrimSuffix(record, ".json") + ".lock"
+}
+
+// AccessLogPath returns where the stdout of Envoy, i.e. its access logs by convention, is captured in the debug store.
+func AccessLogPath(debugStore string) string {
+	return filepath.Join(debugStore, "logs", "access.log")
+}
+
+// ErrorLogPath returns where the stderr of Envoy is captured in the debug store.
+func ErrorLogPath(debugStore string) string {
+	return filepath.Join(debugStore, "logs", "error.log")
+}
+
+// NewInstanceID returns a random ID to record an instance under.
+func NewInstanceID() string {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// InstanceOutputPath returns where the output of GetEnvoy is written to while it runs the instance in the background.
+func InstanceOutputPath(id string) string {
+	return filepath.Join(stateDir(common.HomeDir), id+".out")
+}
+
+// InstanceOptions controls how Envoy is told apart from other instances running on the same host, see RecordInstance.
+type InstanceOptions struct {
+	// ID identifies the instance, a random one is used if empty
+	ID string
+	// Name is a unique, human-friendly name of the instance, the first free one of envoy-1, envoy-2, etc. if empty
+	Name string
+	// AllocateAdminPort picks a free port for the admin listener of Envoy if Config.AdminPort is taken,
+	// unless Envoy is run with a bootstrap of the user, i.e. `--config-path`
+	AllocateAdminPort bool
+	// AllocateBaseID passes Envoy a `--base-id` that no other instance uses, unless Envoy args already decide it
+	AllocateBaseID bool
+}
+
+// RecordInstance is a preset option that records Envoy in the state directory while it is running,
+// so that it can be found by `getenvoy ps`, `getenvoy stop` and `getenvoy logs`.
+// The name, admin address and base ID of the instance are allocated before any other pre-start hook is run,
+// e.g. one that writes a bootstrap, and are recorded in the debug store as well.
+// Once Envoy has terminated, the record is removed along with the output of GetEnvoy run in the background.
+// The record is only trusted while GetEnvoy holds its lock, which outlives no GetEnvoy process, however it exits.
+func RecordInstance(opts InstanceOptions) RuntimeOption {
+	return func(r *Runtime) {
+		if opts.ID == "" {
+			opts.ID = NewInstanceID()
+		}
+		path := filepath.Join(stateDir(r.RootDir), opts.ID+".json")
+		output := filepath.Join(stateDir(r.RootDir), opts.ID+".out")
+		var release func() error
+		r.RegisterHook(Hook{
+			Name:      "record-instance",
+			Phase:     PreStart,
+			Order:     -1,
+			OnFailure: AbortOnFailure,
+			Run: func(context.Context, binary.Runner) (err error) {
+				release, err = r.recordInstance(opts, path)
+				return err
+			},
+		}, Hook{
+			Name:  "forget-instance",
+			Phase: PostExit,
+			Run: func(context.Context, binary.Runner) error {
+				os.Remove(output) //nolint
+				err := os.Remove(path)
+				if release != nil {
+					os.Remove(instanceLockPath(path)) //nolint
+					release()                         //nolint
+				}
+				if err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("unable to forget Envoy instance %v: %v", opts.ID, err)
+				}
+				return nil
+			},
+		})
+	}
+}
+
+// recordInstance allocates what sets the instance apart from the others and records it right away, so that
+// instances started at the same time don't pick the same, then updates the record every time an Envoy process
+// is started, until it has terminated.
+// It returns a function that releases the lock of the instance, see Running.
+func (r *Runtime) recordInstance(opts InstanceOptions, path string) (func() error, error) {
+	unlock, err := osutil.LockFile(filepath.Join(filepath.Dir(path), ".lock"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record Envoy instance %v: %v", opts.ID, err)
+	}
+	defer unlock() //nolint
+	instance, err := r.allocateInstance(opts)
+	if err != nil {
+		return nil, err
+	}
+	release, err := osutil.LockFile(instanceLockPath(path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record Envoy instance %v: %v", opts.ID, err)
+	}
+	if err := writeInstance(path, instance); err != nil {
+		release() //nolint
+		return nil, fmt.Errorf("unable to record Envoy instance %v: %v", opts.ID, err)
+	}
+	if err := writeInstance(filepath.Join(r.DebugStore(), instanceFile), instance); err != nil {
+		log.Warnf("Unable to record Envoy instance %v in the debug store: %v", opts.ID, err)
+	}
+	log.Infof("Running Envoy instance %v", instance)
+
+	statuses, _ := r.Subscribe()
+	// the record is only forgotten once it can't be written any more
+	r.RegisterWait(1)
+	go func() {
+		defer r.RegisterDone()
+		for status := range statuses {
+			if status != binary.StatusStarted {
+				continue
+			}
+			if pid, err := r.GetPid(); err == nil {
+				instance.EnvoyPID = pid
+			}
+			// only once Envoy has started, all pre-start hooks have run, including the one that handles SIGHUP
+			instance.HotRestart = r.HotRestart.Enabled
+			if err := writeInstance(path, instance); err != nil {
+				log.Warnf("Unable to record Envoy instance %v: %v", opts.ID, err)
+			}
+		}
+	}()
+	return release, nil
+}
+
+// writeInstance replaces the record of an instance at once, so that it is never read half-written.
+func writeInstance(path string, instance *Instance) error {
+	bytes, err := json.MarshalIndent(instance, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(bytes, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Instances returns the recorded GetEnvoy processes that are running Envoy, in the order they were started.
+// Records of processes that are gone are cleaned up.
+func Instances() ([]*Instance, error) {
+	return instancesIn(stateDir(common.HomeDir))
+}
+
+func instancesIn(dir string) ([]*Instance, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to list Envoy instances in %v: %v", dir, err)
+	}
+	instances := make([]*Instance, 0, len(files))
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".json" || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		instance := &Instance{lock: instanceLockPath(path)}
+		if err := json.Unmarshal(bytes, instance); err != nil {
+			log.Warnf("Ignoring malformed record of an Envoy instance %v: %v", path, err)
+			continue
+		}
+		if !instance.Running() {
+			os.Remove(path)          //nolint
+			os.Remove(instance.lock) //nolint
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	sort.SliceStable(instances, func(i, j int) bool { return instances[i].Started.Before(instances[j].Started) })
+	return instances, nil
+}
+
+// FindInstance returns the running instance with the passed ID or name, or the only one whose ID starts with it.
+func FindInstance(id string) (*Instance, error) {
+	instances, err := Instances()
+	if err != nil {
+		return nil, err
+	}
+	var found []*Instance
+	for _, instance := range instances {
+		if instance.ID == id || instance.Name == id {
+			return instance, nil
+		}
+		if id != "" && strings.HasPrefix(instance.ID, id) {
+			found = append(found, instance)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("there is no Envoy instance %q running", id)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%q matches several Envoy instances, use more of its ID", id)
+	}
+}
+
+// BaseIDString returns the base ID of the instance, or an empty string if it wasn't decided by GetEnvoy.
+func (i *Instance) BaseIDString() string {
+	if i.BaseID < 0 {
+		return ""
+	}
+	return strconv.Itoa(i.BaseID)
+}
+
+// Running checks whether the GetEnvoy process running the instance still exists.
+// The process ID alone could have been reused by an unrelated process once GetEnvoy was killed,
+// so the lock GetEnvoy holds while it runs the instance has to be held as well.
+func (i *Instance) Running() bool {
+	if i.lock == "" || !processAlive(i.PID) {
+		return false
+	}
+	locked, err := osutil.FileLocked(i.lock)
+	if err != nil {
+		log.Debugf("Unable to check whether Envoy instance %v is running: %v", i.ID, err)
+	}
+	return locked
+}
+
+// Stop asks the GetEnvoy process to stop Envoy as it does on SIGINT, i.e. gracefully, without waiting for it.
+func (i *Instance) Stop() error {
+	if !i.Running() {
+		return fmt.Errorf("there is no Envoy instance %q running", i.ID)
+	}
+	p, err := os.FindProcess(i.PID)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(syscall.SIGINT); err != nil {
+		return fmt.Errorf("unable to stop Envoy instance %v (PID=%d): %v", i.ID, i.PID, err)
+	}
+	return nil
+}
+
+// String describes the instance by what sets it apart from the others running on the same host.
+func (i *Instance) String() string {
+	return fmt.Sprintf("%v (ID=%v) with admin address %v and base ID %v",
+		i.Name, i.ID, orNone(i.AdminAddress), orNone(i.BaseIDString()))
+}
+
+// orNone returns a placeholder for an empty value.
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/common"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+func TestRuntime_RecordInstance(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	defer func(homeDir string) { common.HomeDir = homeDir }(common.HomeDir)
+	common.HomeDir = tmpDir
+	r.RootDir = tmpDir
+	RecordInstance(InstanceOptions{ID: "5c0a7e"})(r)
+
+	path, _ := filepath.Abs(filepath.Join("testdata", "sleep.sh"))
+	require.NoError(t, r.StartPath(context.Background(), path, nil))
+	var instances []*Instance
+	assert.Eventually(t, func() bool {
+		instances, _ = Instances()
+		return len(instances) == 1
+	}, 5*time.Second, 10*time.Millisecond, "expected Envoy to be recorded once it has started")
+	pid, _ := r.GetPid()
+	assert.Equal(t, &Instance{
+		ID:           "5c0a7e",
+		Name:         "envoy-1",
+		PID:          os.Getpid(),
+		EnvoyPID:     pid,
+		Reference:    path,
+		AdminAddress: r.Config.GetAdminAddress(),
+		BaseID:       -1,
+		DebugStore:   r.DebugStore(),
+		AccessLog:    filepath.Join(r.DebugStore(), "logs", "access.log"),
+		ErrorLog:     filepath.Join(r.DebugStore(), "logs", "error.log"),
+		Started:      instances[0].Started,
+		lock:         filepath.Join(stateDir(tmpDir), "5c0a7e.lock"),
+	}, instances[0])
+
+	found, err := FindInstance("5c0")
+	require.NoError(t, err)
+	assert.Equal(t, instances[0], found)
+	recorded, err := ioutil.ReadFile(filepath.Join(r.DebugStore(), instanceFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(recorded), `"name": "envoy-1"`, "expected the instance to be recorded in the debug store")
+
+	time.Sleep(100 * time.Millisecond) // give the script a chance to set up its traps
+	require.NoError(t, r.Stop(context.Background()))
+	instances, err = Instances()
+	require.NoError(t, err)
+	assert.Empty(t, instances, "expected Envoy to be forgotten once it has terminated")
+	_, err = os.Stat(filepath.Join(stateDir(tmpDir), "5c0a7e.lock"))
+	assert.True(t, os.IsNotExist(err), "expected the lock of the instance to be cleaned up")
+}
+
+func TestFindInstance(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	defer func(homeDir string) { common.HomeDir = homeDir }(common.HomeDir)
+	common.HomeDir = tmpDir
+	require.NoError(t, os.MkdirAll(stateDir(tmpDir), 0750))
+
+	gone := exec.Command("true")
+	require.NoError(t, gone.Run())
+	for _, instance := range []*Instance{
+		{ID: "5c0a7e", PID: os.Getpid()},
+		{ID: "5c0b11", PID: os.Getpid()},
+		{ID: "5c0c42", PID: gone.Process.Pid},
+	} {
+		require.NoError(t, writeInstance(filepath.Join(stateDir(tmpDir), instance.ID+".json"), instance))
+		if instance.PID == os.Getpid() {
+			defer holdInstanceLock(t, tmpDir, instance.ID)()
+		}
+	}
+	// GetEnvoy was killed and its process ID reused, which the lock it held tells
+	reused := &Instance{ID: "5c0d93", PID: os.Getpid()}
+	require.NoError(t, writeInstance(filepath.Join(stateDir(tmpDir), reused.ID+".json"), reused))
+
+	found, err := FindInstance("5c0a")
+	require.NoError(t, err)
+	assert.Equal(t, "5c0a7e", found.ID)
+	_, err = FindInstance("5c0")
+	assert.EqualError(t, err, `"5c0" matches several Envoy instances, use more of its ID`)
+	_, err = FindInstance("5c0c42")
+	assert.EqualError(t, err, `there is no Envoy instance "5c0c42" running`)
+	_, err = os.Stat(filepath.Join(stateDir(tmpDir), "5c0c42.json"))
+	assert.True(t, os.IsNotExist(err), "expected the record of a process that is gone to be cleaned up")
+	_, err = FindInstance("5c0d93")
+	assert.EqualError(t, err, `there is no Envoy instance "5c0d93" running`)
+	_, err = os.Stat(filepath.Join(stateDir(tmpDir), "5c0d93.json"))
+	assert.True(t, os.IsNotExist(err), "expected the record of an instance that isn't locked to be cleaned up")
+	assert.EqualError(t, reused.Stop(), `there is no Envoy instance "5c0d93" running`)
+}
+
+// holdInstanceLock pretends that this process runs the instance, as recorded by RecordInstance
+func holdInstanceLock(t *testing.T, rootDir, id string) func() {
+	release, err := osutil.LockFile(instanceLockPath(filepath.Join(stateDir(rootDir), id+".json")), nil)
+	require.NoError(t, err)
+	return func() { release() } //nolint
+}
+
+func TestRuntime_allocateInstance(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	takenPort := int32(taken.Addr().(*net.TCPAddr).Port)
+	others := []*Instance{
+		{ID: "5c0a7e", Name: "envoy-1", BaseID: 0, AdminAddress: "127.0.0.1:15000"},
+		{ID: "9f3e21", Name: "edge", BaseID: 2, AdminAddress: "127.0.0.1:15001"},
+	}
+
+	tests := []struct {
+		name          string
+		opts          InstanceOptions
+		adminPort     int32
+		args          []string
+		wantName      string
+		wantBaseID    int
+		wantArgs      []string
+		wantAdminPort bool
+		// wantNoAdmin is set if the admin address is up to the bootstrap of the user, so it isn't recorded
+		wantNoAdmin bool
+		wantErr     string
+	}{
+		{
+			name:       "defaults",
+			opts:       InstanceOptions{AllocateBaseID: true},
+			adminPort:  takenPort,
+			wantName:   "envoy-2",
+			wantBaseID: 1,
+			wantArgs:   []string{"--base-id", "1"},
+		},
+		{
+			name:          "admin port is in use",
+			opts:          InstanceOptions{Name: "ingress", AllocateAdminPort: true},
+			adminPort:     takenPort,
+			wantName:      "ingress",
+			wantBaseID:    -1,
+			wantAdminPort: true,
+		},
+		{
+			name:          "admin port is recorded by another instance",
+			opts:          InstanceOptions{AllocateAdminPort: true},
+			adminPort:     15001,
+			wantName:      "envoy-2",
+			wantBaseID:    -1,
+			wantAdminPort: true,
+		},
+		{
+			name:        "admin port is decided by the bootstrap of the user",
+			opts:        InstanceOptions{AllocateAdminPort: true},
+			adminPort:   takenPort,
+			args:        []string{"--config-path", "bootstrap.yaml"},
+			wantName:    "envoy-2",
+			wantBaseID:  -1,
+			wantArgs:    []string{"--config-path", "bootstrap.yaml"},
+			wantNoAdmin: true,
+		},
+		{
+			name:        "admin port is decided by the bootstrap of the user given by -c",
+			opts:        InstanceOptions{AllocateAdminPort: true},
+			adminPort:   15001,
+			args:        []string{"-c", "bootstrap.yaml"},
+			wantName:    "envoy-2",
+			wantBaseID:  -1,
+			wantArgs:    []string{"-c", "bootstrap.yaml"},
+			wantNoAdmin: true,
+		},
+		{
+			name:       "base ID is decided by args",
+			opts:       InstanceOptions{AllocateBaseID: true},
+			args:       []string{"--disable-hot-restart"},
+			wantName:   "envoy-2",
+			wantBaseID: -1,
+			wantArgs:   []string{"--disable-hot-restart"},
+		},
+		{
+			name:    "name is taken",
+			opts:    InstanceOptions{Name: "edge"},
+			wantErr: `there is already an Envoy instance "edge" running`,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+			defer os.RemoveAll(tmpDir)
+			for _, other := range others {
+				other.PID = os.Getpid()
+				require.NoError(t, os.MkdirAll(stateDir(tmpDir), 0750))
+				require.NoError(t, writeInstance(filepath.Join(stateDir(tmpDir), other.ID+".json"), other))
+				defer holdInstanceLock(t, tmpDir, other.ID)()
+			}
+			r := &Runtime{RootDir: tmpDir, Config: NewConfig(func(c *Config) {
+				c.AdminAddress = "127.0.0.1"
+				c.AdminPort = tc.adminPort
+			})}
+			r.cmd = exec.Command("envoy", tc.args...)
+			r.userBootstrap = hasBootstrap(tc.args)
+
+			instance, err := r.allocateInstance(tc.opts)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantName, instance.Name)
+			assert.Equal(t, tc.wantBaseID, instance.BaseID)
+			args := r.epochCmd(r.cmd, 0).Args[1:]
+			if !tc.wantAdminPort {
+				assert.Equal(t, tc.wantArgs, args)
+				if tc.wantNoAdmin {
+					assert.Empty(t, instance.AdminAddress)
+				} else {
+					assert.Equal(t, r.Config.GetAdminAddress(), instance.AdminAddress)
+				}
+				return
+			}
+			assert.NotEqual(t, tc.adminPort, r.Config.AdminPort, "expected another admin port to be picked")
+			assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", r.Config.AdminPort), instance.AdminAddress)
+			assert.Equal(t, []string{"--config-yaml",
+				fmt.Sprintf(`admin: {address: {socket_address: {address: "127.0.0.1", port_value: %d}}}`, r.Config.AdminPort)}, args)
+		})
+	}
+}
+
+func TestRuntime_allocateAdminPortGivesUp(t *testing.T) {
+	freePortBackup := freePort
+	defer func() { freePort = freePortBackup }()
+	tries := 0
+	freePort = func(string) (int32, error) {
+		tries++
+		return 15001, nil // always the one of the other instance
+	}
+	r := &Runtime{Config: NewConfig(func(c *Config) {
+		c.AdminAddress = "127.0.0.1"
+		c.AdminPort = 15001
+	})}
+	r.cmd = exec.Command("envoy")
+
+	err := r.allocateAdminPort([]*Instance{{ID: "9f3e21", AdminAddress: "127.0.0.1:15001"}})
+	assert.EqualError(t, err, "unable to allocate a port for the admin listener of Envoy: all 10 free ports tried are taken by other Envoy instances")
+	assert.Equal(t, maxAdminPortTries, tries)
+	assert.Equal(t, []string{"envoy"}, r.cmd.Args, "expected the admin port not to be overridden")
+}
@@ -0,0 +1,344 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	orasctx "github.com/deislabs/oras/pkg/context"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/extension/wasmimage"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+const (
+	// ociScheme is the prefix of references to Envoy builds in OCI registries, e.g. `oci://registry.local/envoy/standard:1.17`
+	ociScheme = "oci://"
+
+	// EnvoyLayerMediaType is the media type of an OCI artifact layer that holds an Envoy release tarball compressed with gzip
+	EnvoyLayerMediaType = "application/vnd.getenvoy.envoy.layer.v1.tar+gzip"
+	// EnvoyLayerXzMediaType is the media type of an OCI artifact layer that holds an Envoy release tarball compressed with xz
+	EnvoyLayerXzMediaType = "application/vnd.getenvoy.envoy.layer.v1.tar+xz"
+
+	// maxOCIManifestSize is the size beyond which an OCI manifest is not read, unlike the layers it refers to
+	maxOCIManifestSize = 4 << 20
+)
+
+var (
+	// envoyLayerMediaTypes are the layer media types of OCI artifacts that are recognized as Envoy builds
+	envoyLayerMediaTypes = []string{
+		EnvoyLayerMediaType,
+		EnvoyLayerXzMediaType,
+		ocispec.MediaTypeImageLayerGzip,
+		ocispec.MediaTypeImageLayer,
+	}
+
+	// unsafeKeyChars matches characters of an OCI reference that cannot be part of a manifest.Key
+	unsafeKeyChars = regexp.MustCompile(`[^\w.-]`)
+)
+
+// IsOCIReference returns true if the passed reference refers to an Envoy build in an OCI registry,
+// e.g. `oci://registry.local/envoy/standard:1.17`
+func IsOCIReference(reference string) bool {
+	return strings.HasPrefix(reference, ociScheme)
+}
+
+// NewOCIKey returns the key under which the build with the passed OCI reference is kept in the binary store.
+// The repository becomes the flavor and the tag, or digest, becomes the version, e.g.
+// `oci://registry.local:5000/envoy/standard:1.17` is kept as `registry.local_5000_envoy_standard:1.17`.
+// OCI artifacts are expected to be built for the current platform.
+func NewOCIKey(reference string) (*manifest.Key, error) {
+	repository, version, err := parseOCIReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	flavor := unsafeKeyChars.ReplaceAllString(repository, "_")
+	return manifest.NewKey(flavor + ":" + unsafeKeyChars.ReplaceAllString(version, "_"))
+}
+
+// parseOCIReference splits the passed OCI reference into a repository and a tag or digest.
+// The tag defaults to `latest`.
+func parseOCIReference(reference string) (repository, version string, err error) {
+	if !IsOCIReference(reference) {
+		return "", "", fmt.Errorf("%q is not an OCI reference", reference)
+	}
+	ref := strings.TrimPrefix(reference, ociScheme)
+	switch i, j := strings.LastIndex(ref, "@"), strings.LastIndex(ref, ":"); {
+	case i > 0:
+		repository, version = ref[:i], ref[i+1:]
+	case j > strings.LastIndex(ref, "/"):
+		repository, version = ref[:j], ref[j+1:]
+	default:
+		repository, version = ref, "latest"
+	}
+	if !strings.Contains(repository, "/") || version == "" {
+		return "", "", fmt.Errorf("%q is not a valid OCI reference, expected oci://<registry>/<repository>:<tag>", reference)
+	}
+	return repository, version, nil
+}
+
+// FetchOCI pulls the Envoy build with the passed OCI reference, unless it is already downloaded, and returns
+// the key under which it is kept in the binary store.
+// Tags are resolved to the digest they currently point to and builds are kept by their digest, so that a tag
+// that moves on, e.g. `latest`, is pulled again.
+// The registry is accessed with Docker credentials and the same HTTP settings as the rest of GetEnvoy.
+func (r *Runtime) FetchOCI(reference string) (*manifest.Key, error) {
+	key, _, err := r.fetchOCI(reference)
+	return key, err
+}
+
+// fetchOCI does the work of FetchOCI and also reports whether the build had to be pulled.
+func (r *Runtime) fetchOCI(reference string) (*manifest.Key, bool, error) {
+	pinned, err := r.pinOCIReference(reference)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := NewOCIKey(pinned)
+	if err != nil {
+		return nil, false, err
+	}
+	unlock, err := osutil.LockFile(r.lockFile(key), func() {
+		r.progress().Printf("waiting for another GetEnvoy process to finish fetching %v", reference)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock() //nolint
+	if r.AlreadyDownloaded(key) {
+		r.progress().Printf("%v is already downloaded", reference)
+		return key, false, nil
+	}
+	if manifest.IsOffline() {
+		return nil, false, fmt.Errorf("%v is not downloaded and cannot be fetched in offline mode", reference)
+	}
+	r.progress().Printf("fetching %v", reference)
+	tarball, err := r.pullOCI(pinned)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to fetch envoy from %v: %v", reference, err)
+	}
+	defer os.Remove(tarball) //nolint
+	return key, true, r.install(key, tarball, manifest.Digest{})
+}
+
+// pinOCIReference replaces the tag of the passed OCI reference with the digest of the manifest it points to.
+// The digest a tag was last resolved to is used when the registry can't be reached, e.g. in offline mode.
+func (r *Runtime) pinOCIReference(reference string) (string, error) {
+	repository, version, err := parseOCIReference(reference)
+	if err != nil {
+		return "", err
+	}
+	if isOCIDigest(version) {
+		return reference, nil
+	}
+	tagKey, err := NewOCIKey(reference)
+	if err != nil {
+		return "", err
+	}
+	tagFile := r.ociTagFile(tagKey)
+
+	var digest string
+	if !manifest.IsOffline() {
+		digest, err = r.resolveOCIDigest(repository + ":" + version)
+	}
+	if manifest.IsOffline() || err != nil {
+		recorded, readErr := ioutil.ReadFile(tagFile)
+		switch {
+		case readErr != nil && manifest.IsOffline():
+			return "", fmt.Errorf("%v is not downloaded and cannot be fetched in offline mode", reference)
+		case readErr != nil:
+			return "", fmt.Errorf("unable to resolve %v: %v", reference, err)
+		case err != nil:
+			log.Warnf("unable to resolve %v, using the build it pointed to before: %v", reference, err)
+		}
+		digest = strings.TrimSpace(string(recorded))
+	} else {
+		if err := os.MkdirAll(filepath.Dir(tagFile), 0750); err != nil {
+			return "", fmt.Errorf("unable to create directory %q: %v", filepath.Dir(tagFile), err)
+		}
+		if err := ioutil.WriteFile(tagFile, []byte(digest), 0600); err != nil {
+			return "", err
+		}
+	}
+	log.Debugf("%v points to %v", reference, digest)
+	return ociScheme + repository + "@" + digest, nil
+}
+
+// resolveOCIDigest returns the digest of the manifest the passed `<registry>/<repository>:<tag>` currently points to.
+func (r *Runtime) resolveOCIDigest(ref string) (string, error) {
+	resolver, err := wasmimage.NewResolver(false, r.Download.PlainHTTP)
+	if err != nil {
+		return "", err
+	}
+	ctx := orasctx.Background()
+	if r.Download.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Download.Timeout)
+		defer cancel()
+	}
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.String(), nil
+}
+
+// ociTagFile returns the location at which the digest a tag was last resolved to is kept
+func (r *Runtime) ociTagFile(key *manifest.Key) string {
+	return filepath.Join(r.store, "tags", key.Flavor, key.Version, platformDirName(key))
+}
+
+// isOCIDigest returns true if the passed version of an OCI reference is a digest rather than a tag,
+// e.g. `sha256:0123abcd`, since tags cannot contain colons.
+func isOCIDigest(version string) bool {
+	return strings.Contains(version, ":")
+}
+
+// pullOCI streams the Envoy tarball of the artifact with the passed OCI reference into the download store,
+// reporting its progress, and returns its location.
+func (r *Runtime) pullOCI(reference string) (string, error) {
+	resolver, err := wasmimage.NewResolver(false, r.Download.PlainHTTP)
+	if err != nil {
+		return "", err
+	}
+	ctx := orasctx.Background()
+	if r.Download.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Download.Timeout)
+		defer cancel()
+	}
+	ref := strings.TrimPrefix(reference, ociScheme)
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	fetcher, err := resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	layer, err := envoyLayer(ctx, fetcher, desc)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("pulling layer %v of %v", layer.Digest, reference)
+
+	dir := r.downloadStore()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("unable to create directory %q: %v", dir, err)
+	}
+	sum := sha256.Sum256([]byte(reference))
+	tarball := filepath.Join(dir, hex.EncodeToString(sum[:8])+layerExt(layer))
+	transfer := r.progress().Transfer(reference, layer.Size, 0)
+	err = fetchBlob(ctx, fetcher, layer, tarball, transfer)
+	transfer.Done(err)
+	if err != nil {
+		os.Remove(tarball) //nolint
+		return "", err
+	}
+	return tarball, nil
+}
+
+// envoyLayer returns the only layer with an Envoy tarball of the artifact whose manifest the passed descriptor describes
+func envoyLayer(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if desc.MediaType != ocispec.MediaTypeImageManifest && desc.MediaType != images.MediaTypeDockerSchema2Manifest {
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer rc.Close() //nolint
+	verifier := desc.Digest.Verifier()
+	data, err := ioutil.ReadAll(io.TeeReader(io.LimitReader(rc, maxOCIManifestSize), verifier))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if !verifier.Verified() {
+		return ocispec.Descriptor{}, fmt.Errorf("manifest %v doesn't match its digest", desc.Digest)
+	}
+	artifact := ocispec.Manifest{}
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("unable to parse manifest %v: %v", desc.Digest, err)
+	}
+	layers := make([]ocispec.Descriptor, 0, 1)
+	for _, layer := range artifact.Layers {
+		for _, mediaType := range envoyLayerMediaTypes {
+			if layer.MediaType == mediaType {
+				layers = append(layers, layer)
+				break
+			}
+		}
+	}
+	if len(layers) != 1 {
+		return ocispec.Descriptor{}, fmt.Errorf("expected exactly one Envoy layer, found %d", len(layers))
+	}
+	return layers[0], nil
+}
+
+// fetchBlob streams the blob with the passed descriptor into a file at dst through the passed writer,
+// verifying it against its digest on the way
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, dst string, w io.Writer) error {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close() //nolint
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(f, verifier, w), rc); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("layer %v doesn't match its digest", desc.Digest)
+	}
+	return f.Close()
+}
+
+// layerExt returns the file extension that lets archiver recognize the format of the passed layer.
+// The file name the layer was pushed with takes precedence over its media type.
+func layerExt(layer ocispec.Descriptor) string {
+	title := layer.Annotations[ocispec.AnnotationTitle]
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar"} {
+		if strings.HasSuffix(title, ext) {
+			return ext
+		}
+	}
+	switch {
+	case strings.HasSuffix(layer.MediaType, "+gzip"), strings.HasSuffix(layer.MediaType, ".gzip"):
+		return ".tar.gz"
+	case strings.HasSuffix(layer.MediaType, "+xz"):
+		return ".tar.xz"
+	default:
+		return ".tar"
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mholt/archiver"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+func TestNewOCIKey(t *testing.T) {
+	tests := []struct {
+		reference string
+		want      *manifest.Key
+		wantErr   bool
+	}{
+		{
+			reference: "oci://registry.local/envoy/standard:1.17",
+			want:      &manifest.Key{Flavor: "registry.local_envoy_standard", Version: "1.17"},
+		},
+		{
+			reference: "oci://registry.local:5000/envoy/standard",
+			want:      &manifest.Key{Flavor: "registry.local_5000_envoy_standard", Version: "latest"},
+		},
+		{
+			reference: "oci://registry.local/envoy/standard@sha256:0123abcd",
+			want:      &manifest.Key{Flavor: "registry.local_envoy_standard", Version: "sha256_0123abcd"},
+		},
+		{
+			reference: "oci://standard:1.17",
+			wantErr:   true,
+		},
+		{
+			reference: "standard:1.17",
+			wantErr:   true,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.reference, func(t *testing.T) {
+			key, err := NewOCIKey(tc.reference)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.Flavor, key.Flavor)
+			assert.Equal(t, tc.want.Version, key.Version)
+			assert.NotEmpty(t, key.Platform)
+		})
+	}
+}
+
+func TestRuntime_FetchOCI(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+	mock := mockRegistry(t, "envoy/standard", "1.17", tarball)
+	defer mock.Close()
+	host, _ := url.Parse(mock.URL)
+	reference := fmt.Sprintf("oci://%v/envoy/standard:1.17", host.Host)
+
+	r := &Runtime{fetcher: fetcher{store: tmpDir, Download: DownloadOptions{PlainHTTP: true}}}
+	key, err := r.FetchOCI(reference)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^sha256_[0-9a-f]{64}$`, key.Version, "expected the build to be kept by its digest")
+	f, _ := ioutil.ReadFile(filepath.Join(r.platformDirectory(key), envoyLocation))
+	assert.Contains(t, string(f), "some c++")
+
+	mock.Close()
+	again, err := r.FetchOCI(reference)
+	assert.NoError(t, err, "expected the build to be served from the binary store")
+	assert.Equal(t, key, again)
+
+	manifest.SetOffline(true)
+	again, err = r.FetchOCI(reference)
+	manifest.SetOffline(false)
+	assert.NoError(t, err, "expected the build to be served from the binary store in offline mode")
+	assert.Equal(t, key, again)
+}
+
+func TestRuntime_FetchOCIMovedTag(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+	mock := mockRegistry(t, "envoy/standard", "latest", tarball)
+	defer mock.Close()
+	host, _ := url.Parse(mock.URL)
+	reference := fmt.Sprintf("oci://%v/envoy/standard", host.Host)
+
+	r := &Runtime{fetcher: fetcher{store: tmpDir, Download: DownloadOptions{PlainHTTP: true}}}
+	first, err := r.FetchOCI(reference)
+	assert.NoError(t, err)
+
+	// `latest` moves on to another build
+	moved := filepath.Join(tmpDir, "moved.tar.xz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, moved)
+	mock.push("envoy/standard", "latest", moved)
+
+	second, err := r.FetchOCI(reference)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Version, second.Version, "expected the moved tag to be pulled again")
+	assert.True(t, r.AlreadyDownloaded(second))
+}
+
+func TestRuntime_FetchOCIReportsProgress(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+	info, _ := os.Stat(tarball)
+	mock := mockRegistry(t, "envoy/standard", "1.17", tarball)
+	defer mock.Close()
+	host, _ := url.Parse(mock.URL)
+	reference := fmt.Sprintf("oci://%v/envoy/standard:1.17", host.Host)
+
+	out := new(bytes.Buffer)
+	r := &Runtime{fetcher: fetcher{store: tmpDir, Download: DownloadOptions{PlainHTTP: true}, Progress: NewProgress(ProgressJSON, out)}}
+	_, err := r.FetchOCI(reference)
+	assert.NoError(t, err)
+	assert.Regexp(t, fmt.Sprintf(`"event":"done","name":"oci://[^"]+","bytes":%d,"total":%d`, info.Size(), info.Size()), out.String())
+}
+
+func TestRuntime_FetchOCIVerifiesLayer(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+	archiver.Archive([]string{filepath.Join("testdata", "envoy")}, tarball)
+	mock := mockRegistry(t, "envoy/standard", "1.17", tarball)
+	defer mock.Close()
+	host, _ := url.Parse(mock.URL)
+	reference := fmt.Sprintf("oci://%v/envoy/standard:1.17", host.Host)
+	mock.mu.Lock()
+	for path, blob := range mock.blobs {
+		// the layer rather than the config, which is `{}`
+		if strings.Contains(path, "/blobs/") && len(blob) > 2 {
+			mock.blobs[path] = append([]byte("tampered"), blob[8:]...)
+		}
+	}
+	mock.mu.Unlock()
+
+	r := &Runtime{fetcher: fetcher{store: tmpDir, Download: DownloadOptions{PlainHTTP: true}}}
+	_, err := r.FetchOCI(reference)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't match its digest")
+	downloads, _ := ioutil.ReadDir(r.downloadStore())
+	assert.Empty(t, downloads, "expected the tampered layer not to be kept")
+}
+
+// ociRegistry is a mock OCI registry that serves artifacts pushed to it
+type ociRegistry struct {
+	*httptest.Server
+	t     *testing.T
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+// mockRegistry serves an OCI artifact with a single Envoy layer, like the one `oras push` would produce
+func mockRegistry(t *testing.T, repository, tag, tarball string) *ociRegistry {
+	registry := &ociRegistry{t: t, blobs: map[string][]byte{}}
+	registry.push(repository, tag, tarball)
+	registry.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.mu.Lock()
+		blob, ok := registry.blobs[r.URL.Path]
+		registry.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(blob).String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		if r.Method != http.MethodHead {
+			w.Write(blob) //nolint
+		}
+	}))
+	return registry
+}
+
+// push makes the tag point to an artifact with a single Envoy layer holding the passed tarball
+func (m *ociRegistry) push(repository, tag, tarball string) {
+	t := m.t
+	layer, err := ioutil.ReadFile(tarball)
+	assert.NoError(t, err)
+	config := []byte("{}")
+	artifact := ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: "application/vnd.unknown.config.v1+json", Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers: []ocispec.Descriptor{{
+			MediaType:   EnvoyLayerMediaType,
+			Digest:      digest.FromBytes(layer),
+			Size:        int64(len(layer)),
+			Annotations: map[string]string{ocispec.AnnotationTitle: filepath.Base(tarball)},
+		}},
+	}
+	artifact.SchemaVersion = 2
+	manifestJSON, _ := json.Marshal(artifact)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs["/v2/"+repository+"/manifests/"+tag] = manifestJSON
+	m.blobs["/v2/"+repository+"/manifests/"+digest.FromBytes(manifestJSON).String()] = manifestJSON
+	m.blobs["/v2/"+repository+"/blobs/"+artifact.Config.Digest.String()] = config
+	m.blobs["/v2/"+repository+"/blobs/"+artifact.Layers[0].Digest.String()] = layer
+}
@@ -0,0 +1,312 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/schollz/progressbar/v2"
+)
+
+// ProgressMode selects how progress of fetching Envoy is reported.
+type ProgressMode string
+
+const (
+	// ProgressAuto reports progress with an interactive bar on a terminal and with plain text lines otherwise.
+	ProgressAuto ProgressMode = "auto"
+	// ProgressPlain reports progress with periodic plain text lines, e.g. for CI logs.
+	ProgressPlain ProgressMode = "plain"
+	// ProgressJSON reports progress with a JSON event per line, e.g. for tools.
+	ProgressJSON ProgressMode = "json"
+	// ProgressNone doesn't report progress of downloads, only what is being fetched.
+	ProgressNone ProgressMode = "none"
+)
+
+// ProgressModes lists the supported progress modes.
+var ProgressModes = []ProgressMode{ProgressAuto, ProgressPlain, ProgressJSON, ProgressNone}
+
+// progressInterval is how often plain text and JSON progress of a download is reported.
+var progressInterval = 5 * time.Second
+
+func (m ProgressMode) String() string {
+	return string(m)
+}
+
+// Set implements pflag.Value, so that ProgressMode can be used as a command line flag.
+func (m *ProgressMode) Set(value string) error {
+	for _, mode := range ProgressModes {
+		if string(mode) == value {
+			*m = mode
+			return nil
+		}
+	}
+	modes := make([]string, 0, len(ProgressModes))
+	for _, mode := range ProgressModes {
+		modes = append(modes, string(mode))
+	}
+	return fmt.Errorf("%q is not a valid progress mode, expected one of %v", value, strings.Join(modes, "|"))
+}
+
+// Type implements pflag.Value.
+func (m *ProgressMode) Type() string {
+	return "mode"
+}
+
+// Progress reports progress of fetching Envoy builds.
+// Implementations are safe for concurrent use, e.g. by FetchAll.
+type Progress interface {
+	// Printf reports a notable event, e.g. that a build is being fetched.
+	Printf(format string, args ...interface{})
+	// Transfer reports the start of a download of total bytes, or of an unknown size if total is negative,
+	// of which offset bytes are already available, e.g. from a previous attempt.
+	Transfer(name string, total, offset int64) Transfer
+}
+
+// Transfer reports progress of a single download.
+type Transfer interface {
+	// Write counts the downloaded bytes.
+	io.Writer
+	// Done reports the end of the download, successful if err is nil.
+	Done(err error)
+}
+
+// NewProgress returns Progress that reports to out in the passed mode.
+func NewProgress(mode ProgressMode, out io.Writer) Progress {
+	if out == nil {
+		out = os.Stdout
+	}
+	lines := &lineWriter{out: out}
+	switch mode {
+	case ProgressPlain:
+		return &plainProgress{lineWriter: lines}
+	case ProgressJSON:
+		return &jsonProgress{lineWriter: lines}
+	case ProgressNone:
+		return &noneProgress{lineWriter: lines}
+	default:
+		if f, ok := out.(*os.File); ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())) {
+			return &barProgress{lineWriter: lines}
+		}
+		return &plainProgress{lineWriter: lines}
+	}
+}
+
+// lineWriter serializes lines written by concurrent downloads.
+type lineWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *lineWriter) Printf(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.out, format+"\n", args...)
+}
+
+// barProgress draws an interactive progress bar of a download.
+// Bars of concurrent downloads would overwrite each other, so as soon as there is more than one,
+// the bar stops being drawn and every download is reported with plain text lines instead.
+type barProgress struct {
+	*lineWriter
+	// active is the number of downloads in progress
+	active int
+	// bar is the download whose bar is being drawn, if any
+	bar *barTransfer
+}
+
+func (p *barProgress) Transfer(name string, total, offset int64) Transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active++
+	if p.active > 1 {
+		if p.bar != nil {
+			fmt.Fprintln(p.out, "") // end the bar that is no longer drawn
+			p.bar = nil
+		}
+		return &periodicTransfer{name: name, total: total, bytes: offset, last: time.Now(), report: func(t *periodicTransfer, err error, done bool) {
+			plainReport(p.lineWriter, t, err, done)
+			if done {
+				p.done()
+			}
+		}}
+	}
+	bar := progressbar.NewOptions64(total, progressbar.OptionSetWriter(p.out), progressbar.OptionSetDescription("[Fetching Envoy]"))
+	bar.Add64(offset) //nolint
+	p.bar = &barTransfer{ProgressBar: bar, progress: p, name: name, bytes: offset}
+	return p.bar
+}
+
+func (p *barProgress) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+}
+
+type barTransfer struct {
+	*progressbar.ProgressBar
+	progress *barProgress
+	name     string
+	bytes    int64
+}
+
+func (t *barTransfer) Write(b []byte) (int, error) {
+	p := t.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.bytes += int64(len(b))
+	if p.bar != t {
+		return len(b), nil
+	}
+	return t.ProgressBar.Write(b)
+}
+
+func (t *barTransfer) Done(err error) {
+	p := t.progress
+	p.mu.Lock()
+	drawn := p.bar == t
+	if drawn {
+		fmt.Fprintln(p.out, "") // append a newline to progressbar output
+		p.bar = nil
+	}
+	p.mu.Unlock()
+	if !drawn {
+		plainReport(p.lineWriter, &periodicTransfer{name: t.name, bytes: t.bytes}, err, true)
+	}
+	p.done()
+}
+
+// plainProgress prints a line with progress of every download periodically.
+type plainProgress struct {
+	*lineWriter
+}
+
+func (p *plainProgress) Transfer(name string, total, offset int64) Transfer {
+	return &periodicTransfer{name: name, total: total, bytes: offset, last: time.Now(), report: func(t *periodicTransfer, err error, done bool) {
+		plainReport(p.lineWriter, t, err, done)
+	}}
+}
+
+// plainReport prints a line with progress of a download.
+func plainReport(w *lineWriter, t *periodicTransfer, err error, done bool) {
+	switch {
+	case done && err != nil:
+		w.Printf("failed to download %v after %v: %v", t.name, formatBytes(t.bytes), err)
+	case done:
+		w.Printf("downloaded %v (%v)", t.name, formatBytes(t.bytes))
+	case t.total > 0:
+		w.Printf("downloading %v: %d%% (%v of %v)", t.name, t.bytes*100/t.total, formatBytes(t.bytes), formatBytes(t.total))
+	default:
+		w.Printf("downloading %v: %v", t.name, formatBytes(t.bytes))
+	}
+}
+
+// jsonProgress prints a JSON event per line for the start, periodic progress and end of every download.
+type jsonProgress struct {
+	*lineWriter
+}
+
+// progressEvent is a line of JSON progress output.
+type progressEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Message string    `json:"message,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func (p *jsonProgress) Printf(format string, args ...interface{}) {
+	p.emit(&progressEvent{Event: "message", Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *jsonProgress) Transfer(name string, total, offset int64) Transfer {
+	p.emit(&progressEvent{Event: "start", Name: name, Bytes: offset, Total: total})
+	return &periodicTransfer{name: name, total: total, bytes: offset, last: time.Now(), report: func(t *periodicTransfer, err error, done bool) {
+		event := &progressEvent{Event: "progress", Name: t.name, Bytes: t.bytes, Total: t.total}
+		if done {
+			event.Event = "done"
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		p.emit(event)
+	}}
+}
+
+func (p *jsonProgress) emit(event *progressEvent) {
+	event.Time = time.Now().UTC()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.out, string(data))
+}
+
+// noneProgress only reports notable events.
+type noneProgress struct {
+	*lineWriter
+}
+
+func (p *noneProgress) Transfer(string, int64, int64) Transfer {
+	return discardTransfer{}
+}
+
+type discardTransfer struct{}
+
+func (discardTransfer) Write(b []byte) (int, error) {
+	return ioutil.Discard.Write(b)
+}
+
+func (discardTransfer) Done(error) {}
+
+// periodicTransfer reports progress of a download at most every progressInterval and once it is done.
+type periodicTransfer struct {
+	name         string
+	total, bytes int64
+	last         time.Time
+	report       func(t *periodicTransfer, err error, done bool)
+}
+
+func (t *periodicTransfer) Write(b []byte) (int, error) {
+	t.bytes += int64(len(b))
+	if now := time.Now(); now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.report(t, nil, false)
+	}
+	return len(b), nil
+}
+
+func (t *periodicTransfer) Done(err error) {
+	t.report(t, err, true)
+}
+
+func formatBytes(n int64) string {
+	const mb = 1024 * 1024
+	if n < mb {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/mb)
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressMode_Set(t *testing.T) {
+	var mode ProgressMode
+	require.NoError(t, mode.Set("json"))
+	assert.Equal(t, ProgressJSON, mode)
+	assert.EqualError(t, mode.Set("fancy"), `"fancy" is not a valid progress mode, expected one of auto|plain|json|none`)
+	assert.Equal(t, ProgressJSON, mode, "expected an invalid mode not to be set")
+}
+
+func TestNewProgress(t *testing.T) {
+	defer func(interval time.Duration) { progressInterval = interval }(progressInterval)
+	progressInterval = 0
+
+	tests := []struct {
+		name string
+		mode ProgressMode
+		want string
+	}{
+		{
+			name: "plain text lines",
+			mode: ProgressPlain,
+			want: "fetching standard:1.17.0/linux-glibc\n" +
+				"downloading envoy.tar.xz: 60% (6 B of 10 B)\n" +
+				"downloading envoy.tar.xz: 100% (10 B of 10 B)\n" +
+				"downloaded envoy.tar.xz (10 B)\n",
+		},
+		{
+			name: "auto falls back to plain text lines when not on a terminal",
+			mode: ProgressAuto,
+			want: "fetching standard:1.17.0/linux-glibc\n" +
+				"downloading envoy.tar.xz: 60% (6 B of 10 B)\n" +
+				"downloading envoy.tar.xz: 100% (10 B of 10 B)\n" +
+				"downloaded envoy.tar.xz (10 B)\n",
+		},
+		{
+			name: "only notable events",
+			mode: ProgressNone,
+			want: "fetching standard:1.17.0/linux-glibc\n",
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			progress := NewProgress(tc.mode, out)
+			progress.Printf("fetching %v", "standard:1.17.0/linux-glibc")
+			transfer := progress.Transfer("envoy.tar.xz", 10, 4)
+			transfer.Write([]byte("12"))   //nolint
+			transfer.Write([]byte("3456")) //nolint
+			transfer.Done(nil)
+			assert.Equal(t, tc.want, out.String())
+		})
+	}
+}
+
+func TestNewProgress_JSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	progress := NewProgress(ProgressJSON, out)
+	progress.Printf("fetching %v", "standard:1.17.0/linux-glibc")
+	transfer := progress.Transfer("envoy.tar.xz", 10, 0)
+	transfer.Write([]byte("1234")) //nolint
+	transfer.Done(errors.New("connection reset"))
+
+	var events []progressEvent
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event progressEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event), "expected every line to be a JSON event: %s", line)
+		assert.False(t, event.Time.IsZero())
+		event.Time = time.Time{}
+		events = append(events, event)
+	}
+	assert.Equal(t, []progressEvent{
+		{Event: "message", Message: "fetching standard:1.17.0/linux-glibc"},
+		{Event: "start", Name: "envoy.tar.xz", Total: 10},
+		{Event: "done", Name: "envoy.tar.xz", Bytes: 4, Total: 10, Error: "connection reset"},
+	}, events)
+}
+
+func TestBarProgress_ConcurrentTransfers(t *testing.T) {
+	out := new(bytes.Buffer)
+	progress := &barProgress{lineWriter: &lineWriter{out: out}}
+
+	first := progress.Transfer("first.tar.xz", 8192, 0)
+	first.Write(make([]byte, 1024)) //nolint
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"second.tar.xz", "third.tar.xz"} {
+		transfer := progress.Transfer(name, 8192, 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 8; i++ {
+				transfer.Write(make([]byte, 1024)) //nolint
+			}
+			transfer.Done(nil)
+		}()
+	}
+	for i := 0; i < 7; i++ {
+		first.Write(make([]byte, 1024)) //nolint
+	}
+	first.Done(nil)
+	wg.Wait()
+
+	lines := strings.Split(out.String(), "\n")
+	var done []string
+	for _, line := range lines[1:] {
+		if line != "" {
+			done = append(done, line)
+		}
+	}
+	assert.Contains(t, lines[0], "[Fetching Envoy]", "expected the first download to start with a bar")
+	assert.ElementsMatch(t, []string{
+		"downloaded first.tar.xz (8192 B)",
+		"downloaded second.tar.xz (8192 B)",
+		"downloaded third.tar.xz (8192 B)",
+	}, done, "expected plain lines once downloads are concurrent")
+
+	// a download on its own gets a bar again
+	out.Reset()
+	transfer := progress.Transfer("fourth.tar.xz", 1024, 0)
+	transfer.Write(make([]byte, 1024)) //nolint
+	transfer.Done(nil)
+	assert.Contains(t, out.String(), "[Fetching Envoy]")
+	assert.NotContains(t, out.String(), "downloaded")
+}
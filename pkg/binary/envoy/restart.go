@@ -0,0 +1,226 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver"
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+// RestartPolicy decides whether Envoy is restarted once it exits.
+type RestartPolicy string
+
+const (
+	// RestartNo never restarts Envoy, i.e. GetEnvoy exits together with it.
+	RestartNo RestartPolicy = "no"
+	// RestartOnFailure restarts Envoy when it exits with a non-zero status or is killed by a signal.
+	RestartOnFailure RestartPolicy = "on-failure"
+)
+
+// RestartPolicies lists the supported restart policies.
+var RestartPolicies = []RestartPolicy{RestartNo, RestartOnFailure}
+
+func (p RestartPolicy) String() string {
+	return string(p)
+}
+
+// Set implements pflag.Value, so that RestartPolicy can be used as a command line flag.
+func (p *RestartPolicy) Set(value string) error {
+	policies := make([]string, 0, len(RestartPolicies))
+	for _, policy := range RestartPolicies {
+		if string(policy) == value {
+			*p = policy
+			return nil
+		}
+		policies = append(policies, string(policy))
+	}
+	return fmt.Errorf("%q is not a valid restart policy, expected one of %v", value, strings.Join(policies, "|"))
+}
+
+// Type implements pflag.Value.
+func (p *RestartPolicy) Type() string {
+	return "policy"
+}
+
+// RestartOptions controls whether and how Envoy is restarted once it exits.
+type RestartOptions struct {
+	// Policy decides whether Envoy is restarted
+	Policy RestartPolicy
+	// MaxRestarts is how many consecutive failures Envoy is restarted after at most, 0 means no limit
+	MaxRestarts int
+	// Backoff is the delay before the first restart, doubled for every consecutive one
+	Backoff time.Duration
+	// MaxBackoff caps the delay between restarts
+	MaxBackoff time.Duration
+	// StablePeriod is how long Envoy has to run for before it exits for its failures not to count as consecutive
+	// anymore, i.e. for both the backoff and the failures counted against MaxRestarts to start over
+	StablePeriod time.Duration
+}
+
+// DefaultRestartOptions returns the default RestartOptions, which never restart Envoy.
+func DefaultRestartOptions() RestartOptions {
+	return RestartOptions{
+		Policy:       RestartNo,
+		Backoff:      time.Second,
+		MaxBackoff:   time.Minute,
+		StablePeriod: time.Minute,
+	}
+}
+
+// RestartStatus describes restarts of a supervised Envoy process.
+type RestartStatus struct {
+	// Restarts is how many times Envoy has been restarted overall
+	Restarts int
+	// LastExit is why Envoy last exited, e.g. `exit status 1`, or empty if it hasn't exited yet
+	LastExit string
+}
+
+// RestartStatus returns how many times Envoy has been restarted and why it last exited
+func (r *Runtime) RestartStatus() RestartStatus {
+	r.lifecycle.mu.Lock()
+	defer r.lifecycle.mu.Unlock()
+	return RestartStatus{Restarts: r.lifecycle.restarts, LastExit: r.lifecycle.lastExit}
+}
+
+// errStopping is returned when Envoy is not restarted because GetEnvoy is terminating.
+var errStopping = errors.New("GetEnvoy is terminating")
+
+// startProcess starts cmd as the Envoy process unless GetEnvoy is terminating.
+// The process is published together with StatusStarted, so that handleTermination either sees it running or
+// stops it from being started at all.
+func (r *Runtime) startProcess(cmd *exec.Cmd) error {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping {
+		return errStopping
+	}
+	r.cmd = cmd
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	l.epoch = 0
+	l.publish(binary.StatusStarted)
+	return nil
+}
+
+// stopRestarts prevents Envoy from being restarted once it exits, e.g. because GetEnvoy received SIGINT.
+func (r *Runtime) stopRestarts() {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.stopping {
+		l.stopping = true
+		close(l.stopped)
+	}
+}
+
+// awaitRestart records why Envoy exited and decides whether to restart it.
+// If so, it saves the debug store as of the crash and blocks for the backoff of the attempt, unless stopped meanwhile.
+func (r *Runtime) awaitRestart(exitErr error, backoff time.Duration) bool {
+	l := &r.lifecycle
+	l.mu.Lock()
+	if exitErr != errStopping {
+		l.lastExit = "exit status 0"
+		if exitErr != nil {
+			l.lastExit = exitErr.Error()
+		}
+	}
+	restart := r.Restart.Policy == RestartOnFailure && exitErr != nil && exitErr != errStopping && !l.stopping &&
+		r.cmd.ProcessState != nil && (r.Restart.MaxRestarts == 0 || l.failures < r.Restart.MaxRestarts)
+	if !restart {
+		l.mu.Unlock()
+		return false
+	}
+	l.restarts++
+	l.failures++
+	attempt := l.restarts
+	l.publish(binary.StatusStarting)
+	stopped := l.stopped
+	l.mu.Unlock()
+
+	log.Infof("Envoy process (PID=%d) crashed: %v, restarting in %v (restart #%d)", r.cmd.Process.Pid, exitErr, backoff, attempt)
+	r.snapshotDebugStore(attempt)
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stopped:
+		return false
+	}
+}
+
+// resetFailures starts counting consecutive failures over if the Envoy process that exited ran for the stable period.
+// It returns true if it did, i.e. if the backoff between restarts should start over as well.
+func (r *Runtime) resetFailures(uptime time.Duration) bool {
+	if !r.Restart.stable(uptime) {
+		return false
+	}
+	r.lifecycle.mu.Lock()
+	defer r.lifecycle.mu.Unlock()
+	r.lifecycle.failures = 0
+	return true
+}
+
+// stable returns true if Envoy ran long enough for its next failure not to count as a consecutive one
+func (o *RestartOptions) stable(uptime time.Duration) bool {
+	return o.StablePeriod > 0 && uptime >= o.StablePeriod
+}
+
+// snapshotDebugStore archives the debug store as of a crash next to the one archived once GetEnvoy exits.
+func (r *Runtime) snapshotDebugStore(attempt int) {
+	if r.DebugStore() == "" {
+		return
+	}
+	snapshot := fmt.Sprintf("%s-crash-%d.tar.gz", r.DebugStore(), attempt)
+	if err := archiver.Archive([]string{r.DebugStore()}, snapshot); err != nil {
+		log.Errorf("Unable to snapshot debug store of crashed Envoy to %v: %v", snapshot, err)
+		return
+	}
+	log.Infof("Saved debug store of crashed Envoy to %v", snapshot)
+}
+
+// nextBackoff doubles the backoff between restarts up to the maximum.
+func (o *RestartOptions) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if o.MaxBackoff > 0 && backoff > o.MaxBackoff {
+		return o.MaxBackoff
+	}
+	return backoff
+}
+
+// restartCmd returns a copy of cmd that can be started again.
+func restartCmd(cmd *exec.Cmd) *exec.Cmd {
+	return &exec.Cmd{
+		Path:        cmd.Path,
+		Args:        cmd.Args,
+		Env:         cmd.Env,
+		Dir:         cmd.Dir,
+		Stdin:       cmd.Stdin,
+		Stdout:      cmd.Stdout,
+		Stderr:      cmd.Stderr,
+		ExtraFiles:  cmd.ExtraFiles,
+		SysProcAttr: cmd.SysProcAttr,
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+func TestRestartPolicy_Set(t *testing.T) {
+	var policy RestartPolicy
+	require.NoError(t, policy.Set("on-failure"))
+	assert.Equal(t, RestartOnFailure, policy)
+	assert.EqualError(t, policy.Set("always"), `"always" is not a valid restart policy, expected one of no|on-failure`)
+}
+
+func TestRestartOptions_nextBackoff(t *testing.T) {
+	opts := &RestartOptions{MaxBackoff: 3 * time.Second}
+	assert.Equal(t, 2*time.Second, opts.nextBackoff(time.Second))
+	assert.Equal(t, 3*time.Second, opts.nextBackoff(2*time.Second))
+}
+
+func TestRestartOptions_stable(t *testing.T) {
+	opts := &RestartOptions{StablePeriod: time.Minute}
+	assert.False(t, opts.stable(time.Second))
+	assert.True(t, opts.stable(time.Minute))
+	assert.False(t, (&RestartOptions{}).stable(time.Hour), "expected no stable period to never start over")
+}
+
+func TestRuntime_RunPathCountsConsecutiveFailures(t *testing.T) {
+	// every run of the script lasts longer than the stable period, so that its failures never count as consecutive
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{
+		Policy: RestartOnFailure, MaxRestarts: 1, Backoff: time.Millisecond, StablePeriod: time.Millisecond,
+	})
+	defer os.RemoveAll(tmpDir)
+
+	statuses, _ := r.Subscribe()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.RunPath(filepath.Join("testdata", "sleep.sh"), []string{"error"}) //nolint
+	}()
+
+	for restarts := 0; restarts < 3; {
+		if status := <-statuses; status == binary.StatusStarting {
+			restarts = r.RestartStatus().Restarts
+		}
+	}
+	r.SendSignal(syscall.SIGINT)
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, r.RestartStatus().Restarts, 3, "expected restarts beyond --max-restarts once Envoy ran stably")
+	assert.Equal(t, binary.StatusTerminated, r.Status())
+}
+
+func TestRuntime_RunPathRestartsOnFailure(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{Policy: RestartOnFailure, MaxRestarts: 2, Backoff: time.Millisecond})
+	defer os.RemoveAll(tmpDir)
+
+	statuses, _ := r.Subscribe()
+	require.NoError(t, r.RunPath(filepath.Join("testdata", "sleep.sh"), []string{"error"}))
+
+	assert.Equal(t, RestartStatus{Restarts: 2, LastExit: "exit status 1"}, r.RestartStatus())
+	for i := 1; i <= 2; i++ {
+		assert.FileExists(t, fmt.Sprintf("%s-crash-%d.tar.gz", r.DebugStore(), i), "expected a snapshot of the debug store for every crash")
+	}
+	var got []int
+	for status := range statuses {
+		got = append(got, status)
+	}
+	assert.Equal(t, binary.StatusTerminated, got[len(got)-1])
+}
+
+func TestRuntime_RunPathDoesntRestartWhenTerminating(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{Policy: RestartOnFailure, Backoff: time.Hour})
+	defer os.RemoveAll(tmpDir)
+
+	statuses, _ := r.Subscribe()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.RunPath(filepath.Join("testdata", "sleep.sh"), []string{"error"}) //nolint
+	}()
+
+	// wait for the crash, i.e. for the process to go back to starting while waiting for the backoff
+	for started := false; ; {
+		status := <-statuses
+		if status == binary.StatusStarted {
+			started = true
+		} else if started && status == binary.StatusStarting {
+			break
+		}
+	}
+	r.SendSignal(syscall.SIGINT)
+	wg.Wait()
+
+	assert.Equal(t, RestartStatus{Restarts: 1, LastExit: "exit status 1"}, r.RestartStatus())
+	assert.Equal(t, binary.StatusTerminated, r.Status())
+}
+
+func newSupervisedRuntime(t *testing.T, opts RestartOptions) (*Runtime, string) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	r := &Runtime{fetcher: fetcher{store: tmpDir}, Config: NewConfig(), Restart: opts}
+	r.wg = new(sync.WaitGroup)
+	r.signals = make(chan os.Signal)
+	r.debugDir = filepath.Join(tmpDir, "debug")
+	require.NoError(t, os.MkdirAll(r.debugDir, 0750))
+	return r, tmpDir
+}
@@ -16,37 +16,86 @@ package envoy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/mholt/archiver"
+	"github.com/tetratelabs/getenvoy/pkg/binary"
 	"github.com/tetratelabs/getenvoy/pkg/manifest"
 	"github.com/tetratelabs/log"
 )
 
+// referenceFile is the name of the file in the debug store that holds the reference of the Envoy build that was run.
+const referenceFile = "envoy-reference"
+
 // Run execs the binary defined by the key with the args passed
-// It is a blocking function that can only be terminated via SIGINT
+// It is a blocking function that can only be terminated via SendSignal, see HandleSignals to terminate it via SIGINT
 func (r *Runtime) Run(key *manifest.Key, args []string) error {
-	path := filepath.Join(r.platformDirectory(key), envoyLocation)
-	return r.RunPath(path, args)
+	if err := r.Start(context.Background(), key, args); err != nil {
+		return err
+	}
+	return r.waitForSignals()
 }
 
 // RunPath execs the binary at the path with the args passed
-// It is a blocking function that can only be terminated via SIGINT
+// It is a blocking function that can only be terminated via SendSignal, see HandleSignals to terminate it via SIGINT
 func (r *Runtime) RunPath(path string, args []string) error {
+	if err := r.StartPath(context.Background(), path, args); err != nil {
+		return err
+	}
+	return r.waitForSignals()
+}
+
+// Start execs the binary defined by the key with the args passed and returns once it has started
+// The build is marked as in use until Envoy terminates so that it is not pruned from the cache
+// The reference of the build is recorded in the debug store so that the run can be reproduced
+func (r *Runtime) Start(ctx context.Context, key *manifest.Key, args []string) error {
+	if r.debugDir != "" {
+		if err := ioutil.WriteFile(filepath.Join(r.debugDir, referenceFile), []byte(key.String()+"\n"), 0600); err != nil {
+			return fmt.Errorf("unable to record Envoy reference: %v", err)
+		}
+	}
+	r.reference = key.String()
+	dir := r.platformDirectory(key)
+	release := markInUse(dir)
+	if err := r.StartPath(ctx, filepath.Join(dir, envoyLocation), args); err != nil {
+		if r.done != nil {
+			<-r.done
+		}
+		release()
+		return err
+	}
+	go func() {
+		<-r.done
+		release()
+	}()
+	return nil
+}
+
+// StartPath execs the binary at the path with the args passed and returns once it has started
+// It returns an error without starting Envoy if a pre-start hook that aborts on failure fails, once the post-exit
+// hooks have cleaned up after the pre-start hooks that did run
+// Envoy is stopped as by Stop once ctx is done, and Done is closed once it has terminated
+// Unlike RunPath, it doesn't handle signals sent to GetEnvoy, see HandleSignals
+func (r *Runtime) StartPath(ctx context.Context, path string, args []string) error {
 	if _, err := os.Stat(path); err != nil {
 		return fmt.Errorf("unable to stat %q: %v", path, err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	r.ctx = ctx
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.ctx = runCtx
+	r.lifecycle.stopped = make(chan struct{})
+	r.lifecycle.stopRequest = make(chan struct{})
+	r.reloads = make(chan struct{}, 1)
+	r.done = make(chan struct{})
 
 	// #nosec -> users can run whatever binary they like!
 	r.cmd = exec.Command(path, args...)
@@ -54,23 +103,131 @@ func (r *Runtime) RunPath(path string, args []string) error {
 	r.cmd.Stdout = r.IO.Out
 	r.cmd.Stderr = r.IO.Err
 	r.cmd.SysProcAttr = sysProcAttr()
+	// pre-start hooks might add `--config-path` for a bootstrap they generate, which is up to GetEnvoy
+	r.userBootstrap = hasBootstrap(args)
 
-	r.handlePreStart()
+	// Envoy is never started once starting it is aborted, which subscribers and hooks waiting for it have to learn
+	// Pre-start hooks that already ran might have left something behind, e.g. the record of the instance
+	abort := func(err error) error {
+		cancel()
+		r.setStatus(binary.StatusTerminated)
+		r.wg.Wait()
+		r.cleanUp()
+		return err
+	}
+	if err := r.runHooks(ctx, PreStart); err != nil {
+		return abort(fmt.Errorf("unable to start Envoy: %v", err))
+	}
+	if r.HotRestart.Enabled {
+		for _, arg := range r.cmd.Args[1:] {
+			if arg == "--restart-epoch" || arg == "--base-id" {
+				return abort(fmt.Errorf("%v is managed by GetEnvoy when hot restart is enabled", arg))
+			}
+		}
+	}
 
+	statuses, unsubscribe := r.Subscribe()
+	defer unsubscribe()
+	r.wg.Add(1)
 	go r.runEnvoy(cancel)
+	go r.awaitTermination(ctx, cancel)
 
-	r.waitForTerminationSignals()
+	for status := range statuses {
+		if status == binary.StatusStarted || status == binary.StatusReady {
+			return nil
+		}
+		if status == binary.StatusTerminated {
+			break
+		}
+	}
+	<-r.done
+	if r.exitErr == nil {
+		return errors.New("envoy process terminated right away")
+	}
+	return r.exitErr
+}
+
+// Stop gracefully stops Envoy, running pre-termination functions and draining it first, and waits until it has
+// terminated or ctx is done
+// It returns why Envoy exited, i.e. nil if it exited successfully
+func (r *Runtime) Stop(ctx context.Context) error {
+	if r.done == nil {
+		return errors.New("envoy process not yet started")
+	}
+	r.requestStop()
+	select {
+	case <-r.done:
+		return r.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once Envoy has terminated and its debug data has been archived
+func (r *Runtime) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns why Envoy exited once Done is closed, i.e. nil if it exited successfully
+// If a post-ready hook stopped Envoy, it returns why the hook failed instead
+func (r *Runtime) Err() error {
+	select {
+	case <-r.done:
+		r.lifecycle.mu.Lock()
+		defer r.lifecycle.mu.Unlock()
+		if r.lifecycle.hookErr != nil {
+			return r.lifecycle.hookErr
+		}
+		return r.exitErr
+	default:
+		return nil
+	}
+}
+
+// requestStop makes awaitTermination stop Envoy
+func (r *Runtime) requestStop() {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.stopRequest:
+	default:
+		close(l.stopRequest)
+	}
+}
+
+// awaitTermination stops Envoy once ctx is done or Stop is called, unless it terminates on its own,
+// then runs the post-exit hooks, archives the debug store and closes Done
+func (r *Runtime) awaitTermination(ctx context.Context, cancel context.CancelFunc) {
+	select {
+	case <-r.ctx.Done():
+		log.Infof("No Envoy processes remaining, terminating GetEnvoy process (PID=%d)", os.Getpid())
+	case <-ctx.Done():
+	case <-r.lifecycle.stopRequest:
+	}
 	r.handleTermination()
 	cancel() // this should never actually do anything but lets avoid any future context leaks
 
 	// Block until the Envoy process and termination handler are finished cleaning up
 	r.wg.Wait()
+	r.cleanUp()
+}
+
+// cleanUp runs the post-exit hooks, archives the debug store and closes Done once Envoy has terminated
+// or was never started
+func (r *Runtime) cleanUp() {
+	// Collect whatever is left behind, even if Envoy crashed
+	if err := r.runHooks(context.Background(), PostExit); err != nil {
+		log.Error(err.Error())
+	}
 
 	// Tar up the debug data and clean up
 	if err := archiver.Archive([]string{r.DebugStore()}, r.DebugStore()+".tar.gz"); err != nil {
-		return fmt.Errorf("unable to archive debug store directory %v: %v", r.DebugStore(), err)
+		r.archiveErr = fmt.Errorf("unable to archive debug store directory %v: %v", r.DebugStore(), err)
+	} else {
+		r.archiveErr = os.RemoveAll(r.DebugStore())
 	}
-	return os.RemoveAll(r.DebugStore())
+	close(r.done)
 }
 
 // DebugStore returns the location at which the runtime instance persists debug data for this given instance
@@ -106,18 +263,27 @@ func (r *Runtime) AppendArgs(args []string) {
 	r.cmd.Args = append(r.cmd.Args, args...)
 }
 
-func (r *Runtime) waitForTerminationSignals() {
-	signal.Notify(r.signals, syscall.SIGINT, syscall.SIGTERM)
+// waitForSignals handles signals passed to SendSignal until Envoy has terminated
+func (r *Runtime) waitForSignals() error {
+	for {
+		select {
+		case s := <-r.signals:
+			r.handleSignal(s)
+		case <-r.done:
+			return r.archiveErr
+		}
+	}
+}
 
-	// Block until we receive SIGINT or are canceled because Envoy has died
-	select {
-	case <-r.ctx.Done():
-		log.Infof("No Envoy processes remaining, terminating GetEnvoy process (PID=%d)", os.Getpid())
-		return
-	case <-r.signals:
-		log.Infof("GetEnvoy process (PID=%d) received SIGINT", os.Getpid())
+// handleSignal reloads Envoy on SIGHUP, if hot restart is enabled, and stops it on any other signal
+func (r *Runtime) handleSignal(s os.Signal) {
+	if s == syscall.SIGHUP && r.HotRestart.Enabled {
+		log.Infof("GetEnvoy process (PID=%d) received SIGHUP, hot restarting Envoy", os.Getpid())
+		r.Reload() //nolint
 		return
 	}
+	log.Infof("GetEnvoy process (PID=%d) received SIGINT", os.Getpid())
+	r.requestStop()
 }
 
 func (r *Runtime) runEnvoy(cancel context.CancelFunc) {
@@ -128,22 +294,95 @@ func (r *Runtime) runEnvoy(cancel context.CancelFunc) {
 		r.cmd.Stderr = os.Stderr
 	}
 
-	r.wg.Add(1)
 	defer r.wg.Done()
 	defer cancel()
 
-	log.Infof("Envoy command: %v", r.cmd.Args)
-	if err := r.cmd.Start(); err != nil {
-		log.Errorf("Unable to start Envoy process: %v", err)
-		return
+	// every process is started from a copy of the configured command, so that it can be started again
+	base, backoff := r.cmd, r.Restart.Backoff
+	for {
+		started := time.Now()
+		err := r.runProcess(base)
+		if err != errStopping {
+			r.exitErr = err
+		}
+		if r.resetFailures(time.Since(started)) {
+			backoff = r.Restart.Backoff
+		}
+		if !r.awaitRestart(err, backoff) {
+			r.setStatus(binary.StatusTerminated)
+			return
+		}
+		backoff = r.Restart.nextBackoff(backoff)
 	}
+}
+
+// processExit is the outcome of waiting for an Envoy process
+type processExit struct {
+	cmd *exec.Cmd
+	err error
+}
 
-	if err := r.cmd.Wait(); err != nil {
-		if r.cmd.ProcessState.ExitCode() == -1 {
-			log.Infof("Envoy process (PID=%d) terminated via %v", r.cmd.Process.Pid, err)
-		} else {
-			log.Infof("Envoy process (PID=%d) terminated with an error: %v", r.cmd.Process.Pid, err)
+// runProcess starts the Envoy process and blocks until the live epoch of it exits, returning why it did
+// Meanwhile, every reload starts a new epoch, and the previous ones are waited for while they drain
+func (r *Runtime) runProcess(base *exec.Cmd) error {
+	cmd := r.epochCmd(base, 0)
+	log.Infof("Envoy command: %v", cmd.Args)
+	if err := r.startProcess(cmd); err != nil {
+		if err != errStopping {
+			log.Errorf("Unable to start Envoy process: %v", err)
 		}
+		return err
+	}
+	ctx, stopProbing := context.WithCancel(r.ctx)
+	defer stopProbing()
+	go r.probeReadiness(ctx)
+
+	exits := make(chan processExit)
+	wait := func(cmd *exec.Cmd) {
+		go func() {
+			exits <- processExit{cmd, cmd.Wait()}
+		}()
+	}
+	wait(cmd)
+	running, reloads := 1, r.reloads
+	var liveErr error
+	for running > 0 {
+		select {
+		case exit := <-exits:
+			running--
+			if exit.cmd != cmd {
+				log.Infof("Envoy process (PID=%d) of epoch %d exited after draining", exit.cmd.Process.Pid, r.drained(exit.cmd))
+				continue
+			}
+			logExit(exit)
+			liveErr = exit.err
+			// previous epochs are only waited for until they exit as they are being shut down by the live one
+			reloads = nil
+		case <-reloads:
+			next := r.epochCmd(base, r.liveEpoch()+1)
+			log.Infof("Envoy command: %v", next.Args)
+			if err := r.startEpoch(next); err != nil {
+				if err != errStopping {
+					log.Errorf("Unable to hot restart Envoy process: %v", err)
+				}
+				continue
+			}
+			cmd = next
+			wait(cmd)
+			running++
+		}
+	}
+	return liveErr
+}
+
+func logExit(exit processExit) {
+	if exit.err == nil {
+		return
+	}
+	if exit.cmd.ProcessState.ExitCode() == -1 {
+		log.Infof("Envoy process (PID=%d) terminated via %v", exit.cmd.Process.Pid, exit.err)
+	} else {
+		log.Infof("Envoy process (PID=%d) terminated with an error: %v", exit.cmd.Process.Pid, exit.err)
 	}
 }
 
@@ -15,6 +15,7 @@
 package envoy
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -25,6 +26,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tetratelabs/getenvoy/pkg/binary"
 )
 
@@ -112,3 +114,93 @@ func newRuntimeWithMockFunctions(t *testing.T) (*Runtime, *bool, *bool) {
 
 	return runtime.(*Runtime), &preStartCalled, &preTerminationCalled
 }
+
+func TestRuntime_StartPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		stop    func(r *Runtime, cancel context.CancelFunc) error
+		wantErr string
+	}{
+		{
+			name: "Stop",
+			stop: func(r *Runtime, _ context.CancelFunc) error { return r.Stop(context.Background()) },
+		},
+		{
+			name: "context canceled",
+			stop: func(r *Runtime, cancel context.CancelFunc) error {
+				cancel()
+				<-r.Done()
+				return r.Err()
+			},
+		},
+		{
+			name: "Envoy exited with an error",
+			args: []string{"error"},
+			stop: func(r *Runtime, _ context.CancelFunc) error {
+				<-r.Done()
+				return r.Err()
+			},
+			wantErr: "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+			defer os.RemoveAll(tmpDir)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			require.NoError(t, r.StartPath(ctx, filepath.Join("testdata", "sleep.sh"), tc.args))
+			assert.Equal(t, binary.StatusStarted, r.Status(), "expected StartPath to return once Envoy has started")
+			assert.NoError(t, r.Err(), "expected no error while Envoy is running")
+			time.Sleep(100 * time.Millisecond) // give the script a chance to set up its traps
+
+			err := tc.stop(r, cancel)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, binary.StatusTerminated, r.Status())
+			assert.FileExists(t, r.DebugStore()+".tar.gz", "expected the debug store to be archived before Done is closed")
+		})
+	}
+}
+
+func TestRuntime_StopBeforeStart(t *testing.T) {
+	r := &Runtime{}
+	assert.EqualError(t, r.Stop(context.Background()), "envoy process not yet started")
+}
+
+func TestHandleSignals(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	HandleSignals(r)
+
+	require.NoError(t, r.StartPath(context.Background(), filepath.Join("testdata", "sleep.sh"), nil))
+	time.Sleep(100 * time.Millisecond) // give the script a chance to set up its traps
+	self, _ := os.FindProcess(os.Getpid())
+	require.NoError(t, self.Signal(syscall.SIGTERM))
+
+	select {
+	case <-r.Done():
+		assert.NoError(t, r.Err())
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGTERM sent to GetEnvoy to stop Envoy")
+	}
+}
+
+func TestRuntime_StartPathFailure(t *testing.T) {
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	notExecutable := filepath.Join(tmpDir, "envoy")
+	require.NoError(t, ioutil.WriteFile(notExecutable, []byte("some c++"), 0600))
+
+	err := r.StartPath(context.Background(), notExecutable, nil)
+	assert.Error(t, err, "expected an error if Envoy can't be started")
+	assert.Equal(t, binary.StatusTerminated, r.Status())
+	assert.Equal(t, err, r.Err())
+}
@@ -17,7 +17,6 @@ package envoy
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -30,6 +29,9 @@ import (
 	ioutil "github.com/tetratelabs/getenvoy/pkg/util/io"
 )
 
+// DefaultKillTimeout is how long Envoy is given to terminate once signaled before it is killed by default
+const DefaultKillTimeout = 15 * time.Second
+
 // RuntimeOption represents a configuration option to NewRuntime.
 type RuntimeOption func(*Runtime)
 
@@ -60,14 +62,14 @@ func (o RuntimeOptions) AndAll(opts RuntimeOptions) RuntimeOptions {
 func NewRuntime(options ...RuntimeOption) (binary.FetchRunner, error) {
 	local := common.HomeDir
 	runtime := &Runtime{
-		Config:         NewConfig(),
-		RootDir:        local,
-		fetcher:        fetcher{local},
-		TmplDir:        filepath.Join(local, "templates"),
-		wg:             &sync.WaitGroup{},
-		signals:        make(chan os.Signal),
-		preStart:       make([]func(binary.Runner) error, 0),
-		preTermination: make([]func(binary.Runner) error, 0),
+		Config:      NewConfig(),
+		RootDir:     local,
+		fetcher:     fetcher{store: local, Download: DefaultDownloadOptions()},
+		Restart:     DefaultRestartOptions(),
+		KillTimeout: DefaultKillTimeout,
+		TmplDir:     filepath.Join(local, "templates"),
+		wg:          &sync.WaitGroup{},
+		signals:     make(chan os.Signal),
 	}
 
 	if debugErr := runtime.initializeDebugStore(); debugErr != nil {
@@ -82,6 +84,14 @@ func NewRuntime(options ...RuntimeOption) (binary.FetchRunner, error) {
 
 type fetcher struct {
 	store string
+
+	// Download controls how Envoy archives are downloaded
+	Download DownloadOptions
+	// Progress reports progress of fetching Envoy, by default in the mode of Download.Progress to IO.Err,
+	// which keeps it apart from IO.Out as that is where Envoy writes to
+	Progress Progress
+
+	progressOnce sync.Once
 }
 
 // Runtime manages an Envoy lifecycle including fetching (if necessary) and running
@@ -95,89 +105,65 @@ type Runtime struct {
 
 	WorkingDir string
 	IO         ioutil.StdStreams
+	// Restart controls whether and how Envoy is restarted once it exits
+	Restart RestartOptions
+	// HotRestart controls whether Envoy can be reloaded without dropping connections
+	HotRestart HotRestartOptions
+	// KillTimeout is how long Envoy is given to terminate once signaled before it is killed, 0 means it is never killed
+	KillTimeout time.Duration
+
+	// reference is the reference of the Envoy build that is run, if it is run by reference
+	reference string
+	// baseIDAssigned is set once GetEnvoy has picked the base ID of Envoy in HotRestart, even if hot restart is disabled
+	baseIDAssigned bool
+	// userBootstrap is set if Envoy is run with a bootstrap of the user, i.e. `--config-path`, rather than one generated
+	// by GetEnvoy, see AdminAddress
+	userBootstrap bool
 
 	cmd *exec.Cmd
 	ctx context.Context
 	wg  *sync.WaitGroup
 
 	signals chan os.Signal
+	reloads chan struct{}
 
-	preStart       []func(binary.Runner) error
-	preTermination []func(binary.Runner) error
+	// done is closed once Envoy has terminated, exitErr is why it did and archiveErr why its debug data wasn't archived
+	done       chan struct{}
+	exitErr    error
+	archiveErr error
 
-	isReady bool
-}
+	// hooks are the registered hooks by phase, sorted in the order they are run
+	hooks map[HookPhase][]Hook
 
-// Status indicates the state of the child process
-func (r *Runtime) Status() int {
-	switch {
-	case r.cmd == nil, r.cmd.Process == nil:
-		return binary.StatusStarting
-	case r.cmd.ProcessState == nil:
-		if r.envoyReady() {
-			return binary.StatusReady
-		}
-		return binary.StatusStarted
-	default:
-		return binary.StatusTerminated
-	}
+	lifecycle lifecycle
 }
 
 // GetPid returns the pid of the child process
+// With hot restart enabled, it is the process of the live epoch
 func (r *Runtime) GetPid() (int, error) {
+	r.lifecycle.mu.Lock()
+	defer r.lifecycle.mu.Unlock()
 	if r.cmd == nil || r.cmd.Process == nil {
 		return 0, fmt.Errorf("envoy process not yet started")
 	}
 	return r.cmd.Process.Pid, nil
 }
 
-func (r *Runtime) envoyReady() bool {
-	// Once we have seen its ready once stop spamming the ready endpoint.
-	// If we expand the interface to support ready <-> not ready then
-	// this approach will be wrong but as the states are monotonic this is good enough for now
-	if r.isReady {
-		return true
-	}
-	resp, err := http.Get(fmt.Sprintf("http://%s/ready", r.Config.GetAdminAddress()))
-	if err != nil {
-		return false
+// AdminAddress returns the host:port address of the admin listener of Envoy if GetEnvoy decided it, i.e. Envoy is run
+// with a bootstrap generated by GetEnvoy, possibly with the admin listener moved by `--config-yaml`, see InstanceOptions,
+// or an empty string if the bootstrap of the user decides it
+func (r *Runtime) AdminAddress() string {
+	if r.userBootstrap {
+		return ""
 	}
-	defer resp.Body.Close() //nolint
-	if resp.StatusCode == http.StatusOK {
-		r.isReady = true
-		return r.isReady
-	}
-	return false
+	return r.Config.GetAdminAddress()
 }
 
-// Wait blocks until the child process reaches the state passed
-// Note: It does not guarantee that it is in the specified state just that it has reached it
-func (r *Runtime) Wait(state int) {
-	for r.Status() < state {
-		// This is a call to a function to allow the goroutine to be preempted for garbage collection
-		// The sleep duration is somewhat arbitrary
-		func() { time.Sleep(time.Millisecond * 100) }()
-	}
-}
-
-// WaitWithContext blocks until the child process reaches the state passed or the context is canceled
-// Note: It does not guarantee that it is in the specified state just that it has reached it
-func (r *Runtime) WaitWithContext(ctx context.Context, state int) {
-	done := make(chan struct{})
-	go func() {
-		r.Wait(state)
-		close(done)
-	}()
+// SendSignal passes a signal to Run or RunPath as if GetEnvoy received it, e.g. SIGINT stops Envoy
+// It is a no-op once Envoy has terminated
+func (r *Runtime) SendSignal(s os.Signal) {
 	select {
-	case <-done:
-		return
-	case <-ctx.Done():
-		return
-
+	case r.signals <- s:
+	case <-r.done:
 	}
 }
-
-// SendSignal sends a signal to the parent process
-func (r *Runtime) SendSignal(s os.Signal) {
-	r.signals <- s
-}
@@ -0,0 +1,56 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+// HandleSignals is a preset option that makes GetEnvoy stop Envoy on SIGINT or SIGTERM, and hot restart it on SIGHUP
+// if hot restart is enabled, the way `getenvoy run` does
+// Without it, the runtime leaves signals sent to GetEnvoy alone, e.g. when it is embedded into another program
+func HandleSignals(r *Runtime) {
+	r.RegisterPreStart(handleSignals)
+}
+
+func handleSignals(r binary.Runner) error {
+	e, ok := r.(*Runtime)
+	if !ok {
+		return errors.New("unable to handle signals as binary.Runner is not an Envoy runtime")
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	if e.HotRestart.Enabled {
+		signal.Notify(signals, syscall.SIGHUP)
+	}
+	done := e.done
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case s := <-signals:
+				e.handleSignal(s)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -16,19 +16,10 @@ package envoy
 
 import (
 	"github.com/tetratelabs/getenvoy/pkg/binary"
-	"github.com/tetratelabs/log"
 )
 
-func (r *Runtime) handlePreStart() {
-	// Execute all registered preStart functions
-	for _, f := range r.preStart {
-		if err := f(r); err != nil {
-			log.Error(err.Error())
-		}
-	}
-}
-
 // RegisterPreStart registers the passed functions to be run before Envoy has started
+// Their failures are only logged, see RegisterHook to abort starting Envoy instead
 func (r *Runtime) RegisterPreStart(f ...func(binary.Runner) error) {
-	r.preStart = append(r.preStart, f...)
+	r.RegisterHook(legacyHooks(PreStart, f)...)
 }
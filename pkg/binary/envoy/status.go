@@ -0,0 +1,189 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+// readinessInterval is how often the readiness prober checks the admin endpoint of Envoy until it is ready.
+var readinessInterval = 100 * time.Millisecond
+
+// lifecycle tracks the status of the child process and publishes its transitions to subscribers.
+type lifecycle struct {
+	mu          sync.Mutex
+	status      int
+	subscribers map[chan int]struct{}
+
+	// stopRequest is closed once Envoy is asked to stop, e.g. by Stop
+	stopRequest chan struct{}
+	// stopping is set once GetEnvoy is terminating, stopped is closed at the same time
+	stopping bool
+	stopped  chan struct{}
+	// restarts and lastExit are reported by RestartStatus, failures are the restarts since Envoy last ran stably
+	restarts int
+	failures int
+	lastExit string
+	// hookErr is why a post-ready hook stopped Envoy
+	hookErr error
+	// epoch is the hot restart epoch of the live process, draining are the previous epochs still running
+	epoch    int
+	draining []drainingEpoch
+}
+
+// Status indicates the state of the child process
+func (r *Runtime) Status() int {
+	r.lifecycle.mu.Lock()
+	defer r.lifecycle.mu.Unlock()
+	return r.lifecycle.status
+}
+
+// Subscribe returns a channel that receives the current status of the child process followed by every status it
+// transitions to, and a function to stop receiving them.
+// A slow subscriber misses intermediate statuses rather than blocking the runtime, but always receives the latest one.
+// The channel is closed once the child process has terminated or the subscription is canceled.
+func (r *Runtime) Subscribe() (<-chan int, func()) {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan int, binary.StatusTerminated+1)
+	ch <- l.status
+	if l.status == binary.StatusTerminated {
+		close(ch)
+		return ch, func() {}
+	}
+	if l.subscribers == nil {
+		l.subscribers = make(map[chan int]struct{})
+	}
+	l.subscribers[ch] = struct{}{}
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.subscribers[ch]; ok {
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// setStatus transitions the child process to the passed status and notifies subscribers.
+// Transitions backwards are ignored as statuses are monotonic, apart from restarts, see awaitRestart.
+func (r *Runtime) setStatus(status int) {
+	l := &r.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if status <= l.status {
+		return
+	}
+	l.publish(status)
+}
+
+// publish sets the status and notifies subscribers, dropping the oldest status a subscriber hasn't received yet
+// if its channel is full. It must be called with mu held.
+func (l *lifecycle) publish(status int) {
+	l.status = status
+	for ch := range l.subscribers {
+		for sent := false; !sent; {
+			select {
+			case ch <- status:
+				sent = true
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+			}
+		}
+		if status == binary.StatusTerminated {
+			close(ch)
+		}
+	}
+	if status == binary.StatusTerminated {
+		l.subscribers = nil
+	}
+}
+
+// Wait blocks until the child process reaches the state passed
+// Note: It does not guarantee that it is in the specified state just that it has reached it
+func (r *Runtime) Wait(state int) {
+	r.WaitWithContext(context.Background(), state)
+}
+
+// WaitWithContext blocks until the child process reaches the state passed or the context is canceled
+// Note: It does not guarantee that it is in the specified state just that it has reached it
+func (r *Runtime) WaitWithContext(ctx context.Context, state int) {
+	statuses, cancel := r.Subscribe()
+	defer cancel()
+	for {
+		select {
+		case status, ok := <-statuses:
+			if !ok || status >= state {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// probeReadiness checks the admin endpoint of Envoy until it reports that Envoy is ready or ctx is canceled.
+// Once seen ready, Envoy is considered ready until it terminates, so the endpoint is not checked any more,
+// and post-ready hooks are run instead.
+func (r *Runtime) probeReadiness(ctx context.Context) {
+	address := r.AdminAddress()
+	if address == "" {
+		if r.Config.GetAdminAddress() != "" {
+			log.Debugf("Not checking readiness of Envoy as its admin address is up to the bootstrap of the user")
+		}
+		return // readiness can't be checked without the admin endpoint
+	}
+	url := fmt.Sprintf("http://%s/ready", address)
+	client := &http.Client{Timeout: time.Second}
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+	for {
+		if envoyReady(ctx, client, url) {
+			r.setStatus(binary.StatusReady)
+			r.handlePostReady(ctx)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func envoyReady(ctx context.Context, client *http.Client, url string) bool {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close() //nolint
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary"
+)
+
+func TestRuntime_Subscribe(t *testing.T) {
+	var probes int32
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			return // e.g. draining on shutdown
+		}
+		if atomic.AddInt32(&probes, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer admin.Close()
+	r, tmpDir := newSupervisedRuntime(t, RestartOptions{})
+	defer os.RemoveAll(tmpDir)
+	useAdmin(r, admin)
+
+	statuses, _ := r.Subscribe()
+	done := make(chan error)
+	go func() {
+		done <- r.RunPath(filepath.Join("testdata", "sleep.sh"), nil)
+	}()
+
+	var got []int
+	for status := range statuses {
+		got = append(got, status)
+		if status == binary.StatusReady {
+			r.SendSignal(syscall.SIGINT)
+		}
+	}
+	require.NoError(t, <-done)
+	assert.Equal(t, []int{binary.StatusStarting, binary.StatusStarted, binary.StatusReady, binary.StatusTerminated}, got)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&probes), "expected the admin endpoint not to be checked once Envoy is ready")
+
+	statuses, _ = r.Subscribe()
+	assert.Equal(t, binary.StatusTerminated, <-statuses, "expected a late subscriber to receive the final status")
+	_, open := <-statuses
+	assert.False(t, open, "expected the channel of a late subscriber to be closed")
+}
+
+func TestRuntime_Unsubscribe(t *testing.T) {
+	r := &Runtime{}
+	statuses, unsubscribe := r.Subscribe()
+	assert.Equal(t, binary.StatusStarting, <-statuses)
+	unsubscribe()
+	unsubscribe()
+	_, open := <-statuses
+	assert.False(t, open, "expected the channel to be closed on unsubscribe")
+
+	r.setStatus(binary.StatusStarted)
+	r.setStatus(binary.StatusStarting)
+	assert.Equal(t, binary.StatusStarted, r.Status(), "expected statuses not to go backwards")
+}
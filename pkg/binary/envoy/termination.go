@@ -15,6 +15,7 @@
 package envoy
 
 import (
+	"context"
 	"syscall"
 
 	"github.com/tetratelabs/getenvoy/pkg/binary"
@@ -22,10 +23,14 @@ import (
 )
 
 func (r *Runtime) handleTermination() {
-	if r.cmd.Process == nil {
+	// once restarts and reloads are stopped, the process is not replaced any more
+	// and its status is published after it is started and waited for, so reading cmd is race-free afterwards
+	r.stopRestarts()
+	status := r.Status()
+	if status == binary.StatusStarting || r.cmd.Process == nil {
 		return // Envoy hasn't started at all
 	}
-	if r.cmd.ProcessState != nil {
+	if status == binary.StatusTerminated {
 		if r.cmd.ProcessState.Success() {
 			log.Infof("Envoy process (PID=%d) exited successfully", r.cmd.Process.Pid)
 			return
@@ -34,21 +39,24 @@ func (r *Runtime) handleTermination() {
 		return
 	}
 
-	// Execute all registered preTermination functions
-	for _, f := range r.preTermination {
-		if err := f(r); err != nil {
-			log.Error(err.Error())
-		}
+	// Execute all registered pre-termination hooks
+	if err := r.runHooks(context.Background(), PreTermination); err != nil {
+		log.Error(err.Error())
 	}
 
-	// Forward on the SIGINT to Envoy
+	// Let Envoy finish serving active connections before it is shut down
+	r.drain()
+
+	// Forward on the SIGINT to Envoy, and kill it if it doesn't terminate in time
 	log.Infof("Sending Envoy process (PID=%d) SIGINT", r.cmd.Process.Pid)
 	r.cmd.Process.Signal(syscall.SIGINT) //nolint
-
+	r.signalDraining(syscall.SIGINT)
+	r.killAfter(r.KillTimeout)
 }
 
 // RegisterPreTermination registers the passed functions to be run after Envoy has started
 // and just before GetEnvoy instructs Envoy to terminate
+// They are skipped if Envoy terminates on its own, see RegisterHook to run functions once Envoy has exited instead
 func (r *Runtime) RegisterPreTermination(f ...func(binary.Runner) error) {
-	r.preTermination = append(r.preTermination, f...)
+	r.RegisterHook(legacyHooks(PreTermination, f)...)
 }
@@ -16,9 +16,9 @@ package envoytest
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
-	"syscall"
 	"time"
 
 	"github.com/mholt/archiver"
@@ -35,37 +35,55 @@ func Fetch() error {
 	key, _ := manifest.NewKey(Reference)
 	r, _ := envoy.NewRuntime()
 	if !r.AlreadyDownloaded(key) {
-		location, err := manifest.Locate(key)
+		build, err := manifest.Locate(key)
 		if err != nil {
 			return fmt.Errorf("unable to retrieve manifest from %v: %v", manifest.GetURL(), err)
 		}
-		if err := r.Fetch(key, location); err != nil {
-			return fmt.Errorf("unable to retrieve binary from %v: %v", location, err)
+		if err := r.Fetch(key, build); err != nil {
+			return fmt.Errorf("unable to retrieve binary from %v: %v", build.DownloadLocationURL, err)
 		}
 	}
 	return nil
 }
 
 // Run executes envoy and waits for it to be ready
-// It is blocking and will only return once ready (nil) or context timeout is exceeded (error)
+// It is blocking and will only return once ready (nil), terminated before becoming ready (error)
+// or context timeout is exceeded (error)
 func Run(ctx context.Context, r binary.Runner, bootstrap string) error {
 	key, _ := manifest.NewKey(Reference)
 	args := []string{}
 	if bootstrap != "" {
 		args = append(args, "-c", bootstrap)
 	}
-	go r.Run(key, args)
-	r.WaitWithContext(ctx, binary.StatusReady)
-	return ctx.Err()
+	statuses, unsubscribe := r.Subscribe()
+	defer unsubscribe()
+	if err := r.Start(context.Background(), key, args); err != nil {
+		return err
+	}
+	for {
+		select {
+		case status := <-statuses:
+			switch status {
+			case binary.StatusReady:
+				return nil
+			case binary.StatusTerminated:
+				return errors.New("envoy terminated before becoming ready")
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
-// Kill sends sigint to a running enboy, waits for termination, then unarchives the debug directory.
-// It is blocking and will only return once terminated (nil) or context timeout is exceeded (error)
+// Kill stops a running envoy, waits for termination, then unarchives the debug directory.
+// It is blocking and will only return once terminated (nil), envoy exited with an error (error)
+// or context timeout is exceeded (error)
 func Kill(ctx context.Context, r binary.Runner) error {
-	r.SendSignal(syscall.SIGINT)
-	r.WaitWithContext(ctx, binary.StatusTerminated)
-	archiver.Unarchive(r.DebugStore()+".tar.gz", filepath.Dir(r.DebugStore()))
-	return ctx.Err()
+	err := r.Stop(ctx)
+	if ctx.Err() == nil {
+		archiver.Unarchive(r.DebugStore()+".tar.gz", filepath.Dir(r.DebugStore()))
+	}
+	return err
 }
 
 // RunKill executes envoy, waits for ready, sends sigint, waits for termination, then unarchives the debug directory.
@@ -26,12 +26,22 @@ import (
 type Runner interface {
 	Run(key *manifest.Key, args []string) error
 	RunPath(path string, args []string) error
+	// Start and StartPath start the binary without blocking until it terminates or handling signals of the process
+	Start(ctx context.Context, key *manifest.Key, args []string) error
+	StartPath(ctx context.Context, path string, args []string) error
+	// Stop gracefully stops the binary and returns why it exited
+	Stop(ctx context.Context) error
+	// Done is closed once the binary has terminated, Err returns why it did afterwards
+	Done() <-chan struct{}
+	Err() error
 	RegisterPreStart(f ...func(Runner) error)
 	RegisterPreTermination(f ...func(Runner) error)
 	RegisterWait(int)
 	RegisterDone()
 	SendSignal(signal os.Signal)
 	Status() int
+	// Subscribe returns a channel of status transitions of the child process and a function to unsubscribe
+	Subscribe() (<-chan int, func())
 	GetPid() (int, error)
 	AppendArgs([]string)
 	Wait(int)
@@ -48,6 +58,7 @@ type Runner interface {
 const (
 	// The Runner's child process is represented as a finite state machine
 	// The states are ordered and monotonic i.e. starting -> started -> ready -> terminated (0 -> 1 -> 2 -> 3)
+	// The only exception is a child process that crashed and is about to be restarted, which goes back to starting
 	// Any additional states must be added to the iota in the order they are expected to occur
 
 	// StatusStarting indicates the child process is not yet started
@@ -61,10 +72,10 @@ const (
 	StatusTerminated
 )
 
-// Fetcher retreives the binary from the location and stores it bases on key
+// Fetcher retreives the binary from the build location, verifies and stores it bases on key
 // TODO (Liam): make this less Envoy specific (not using manifest.Key) so it can be reused
 type Fetcher interface {
-	Fetch(key *manifest.Key, binaryLocation string) error
+	Fetch(key *manifest.Key, build *manifest.Build) error
 	AlreadyDownloaded(key *manifest.Key) bool
 	BinaryStore() string
 }
@@ -0,0 +1,129 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+// NewBundleCmd returns a command that moves Envoy builds between machines.
+func NewBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Move Envoy builds to machines without internet access.",
+		Long: `
+Package downloaded Envoy builds into a bundle and install them from it on another machine,
+e.g. one without internet access.`,
+	}
+	cmd.AddCommand(newBundleExportCmd())
+	cmd.AddCommand(newBundleImportCmd())
+	return cmd
+}
+
+func newBundleExportCmd() *cobra.Command {
+	output := ""
+	cmd := &cobra.Command{
+		Use:   "export <reference>...",
+		Short: "Package downloaded Envoy builds into a bundle.",
+		Long: `
+Package downloaded Envoy builds into a bundle along with a manifest that describes them and their checksums.
+Builds are packaged as the archives they were downloaded as, along with their checksums and signatures, so that
+they are verified on import exactly like a download would be.`,
+		Example: `
+  # Package a build for Linux into a bundle.
+  getenvoy fetch standard:1.17.0/linux-glibc
+  getenvoy bundle export standard:1.17.0/linux-glibc -o bundle.tar.gz`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("missing reference parameter")
+			}
+			if output == "" {
+				return errors.New("--output must be set")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keys := make([]*manifest.Key, 0, len(args))
+			for _, reference := range args {
+				key, err := manifest.NewKey(reference)
+				if err != nil {
+					return err
+				}
+				if key, err = envoy.Resolve(key, envoy.NewProgress(envoy.ProgressNone, cmd.ErrOrStderr())); err != nil {
+					return err
+				}
+				keys = append(keys, key)
+			}
+			source, err := manifest.Load()
+			if err != nil {
+				log.Warnf("unable to retrieve manifest, builds will be described with minimal metadata: %v", err)
+				source = &manifest.Manifest{}
+			}
+			runtime, err := envoy.NewRuntime()
+			if err != nil {
+				return err
+			}
+			if err := runtime.(*envoy.Runtime).ExportBundle(output, source, keys...); err != nil {
+				return err
+			}
+			for _, key := range keys {
+				fmt.Fprintf(cmd.OutOrStdout(), "exported %v\n", key)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", output, "location of the bundle to create, e.g. bundle.tar.gz")
+	return cmd
+}
+
+func newBundleImportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import <bundle>",
+		Short: "Install Envoy builds from a bundle.",
+		Long: `
+Install Envoy builds from a bundle created by ` + "`getenvoy bundle export`" + `.
+Installed builds can be run and fetched in offline mode.`,
+		Example: `
+  # Install builds from a bundle.
+  getenvoy bundle import bundle.tar.gz
+
+  # Run an installed build.
+  getenvoy run standard:1.17.0 --offline -- --config-path ./bootstrap.yaml`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected exactly one bundle parameter")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runtime, err := envoy.NewRuntime()
+			if err != nil {
+				return err
+			}
+			keys, err := runtime.(*envoy.Runtime).ImportBundle(args[0])
+			for _, key := range keys {
+				fmt.Fprintf(cmd.OutOrStdout(), "imported %v\n", key)
+			}
+			return err
+		},
+	}
+}
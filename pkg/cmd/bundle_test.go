@@ -0,0 +1,106 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mholt/archiver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/tetratelabs/getenvoy/pkg/cmd"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+
+	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
+)
+
+var _ = Describe("getenvoy bundle", func() {
+
+	var tmpDir string
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		tmpDir = dir
+	})
+
+	AfterEach(func() {
+		if tmpDir != "" {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		}
+	})
+
+	execute := func(args ...string) (string, error) {
+		stdout := new(bytes.Buffer)
+		c := NewRoot()
+		c.SetOut(stdout)
+		c.SetErr(new(bytes.Buffer))
+		c.SetArgs(args)
+		err := cmdutil.Execute(c)
+		return stdout.String(), err
+	}
+
+	It("should move a build between home directories", func() {
+		onlineHome := filepath.Join(tmpDir, "online")
+		offlineHome := filepath.Join(tmpDir, "offline")
+		bundle := filepath.Join(tmpDir, "bundle.tar.gz")
+		envoy := filepath.Join(tmpDir, "release", "bin", "envoy")
+		Expect(os.MkdirAll(filepath.Dir(envoy), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(envoy, []byte("some c++"), 0600)).To(Succeed())
+		tarball := filepath.Join(tmpDir, "envoy.tar.gz")
+		Expect(archiver.Archive([]string{filepath.Dir(envoy)}, tarball)).To(Succeed())
+		data, err := ioutil.ReadFile(tarball)
+		Expect(err).NotTo(HaveOccurred())
+		source := &manifest.Manifest{}
+		source.SetBuild(&manifest.Key{Flavor: "standard", Version: "1.11.0", Platform: "LINUX_GLIBC"}, &manifest.Build{
+			DownloadLocationURL: "file://" + filepath.ToSlash(tarball),
+			Digest:              manifest.Digest{SHA256: fmt.Sprintf("%x", sha256.Sum256(data))},
+		})
+		data, err = source.Encode()
+		Expect(err).NotTo(HaveOccurred())
+		manifestFile := filepath.Join(tmpDir, "manifest.json")
+		Expect(ioutil.WriteFile(manifestFile, data, 0600)).To(Succeed())
+
+		By("fetching the build")
+		defer func(urls []string) {
+			Expect(manifest.SetURLs(urls...)).To(Succeed())
+		}(manifest.GetURLs())
+		_, err = execute("--home-dir", onlineHome, "--manifest", manifestFile, "fetch", "standard:1.11.0/linux-glibc")
+		Expect(err).NotTo(HaveOccurred())
+
+		By("exporting the build")
+		stdout, err := execute("--home-dir", onlineHome, "--offline", "bundle", "export", "standard:1.11.0/linux-glibc", "-o", bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("exported standard:1.11.0/linux-glibc\n"))
+		Expect(bundle).To(BeARegularFile())
+
+		By("importing the build")
+		stdout, err = execute("--home-dir", offlineHome, "--offline", "bundle", "import", bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("imported standard:1.11.0/linux-glibc\n"))
+		Expect(filepath.Join(offlineHome, "builds", "standard", "1.11.0", "linux_glibc", "bin", "envoy")).To(BeARegularFile())
+	})
+
+	It("should require an output location", func() {
+		_, err := execute("--home-dir", tmpDir, "bundle", "export", "standard:1.11.0")
+		Expect(err).To(MatchError("--output must be set"))
+	})
+})
@@ -0,0 +1,178 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+// NewCacheCmd returns a command that manages Envoy builds downloaded by GetEnvoy.
+func NewCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage downloaded Envoy builds.",
+		Long: `
+Inspect and clean up Envoy builds downloaded into the ` + "`~/.getenvoy/builds`" + ` directory.`,
+	}
+	cmd.AddCommand(newCacheListCmd())
+	cmd.AddCommand(newCacheRemoveCmd())
+	cmd.AddCommand(newCachePruneCmd())
+	return cmd
+}
+
+func newCacheListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List downloaded Envoy builds.",
+		Long: `
+List downloaded Envoy builds along with their size and the time they were last used.`,
+		Example: `
+  # List downloaded Envoy builds.
+  getenvoy cache list`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			builds, err := envoy.DefaultBuildCache().List()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 5, ' ', 0)
+			fmt.Fprintln(w, "REFERENCE\tSIZE\tLAST USED")
+			for _, build := range builds {
+				lastUsed := build.LastUsed.Format("2006-01-02 15:04:05")
+				if build.InUse() {
+					lastUsed = "in use"
+				}
+				fmt.Fprintf(w, "%v\t%v\t%v\n", build.Key, formatSize(build.Size), lastUsed)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newCacheRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <reference>",
+		Short: "Remove a downloaded Envoy build.",
+		Long: `
+Remove a downloaded Envoy build. Builds that are currently being run cannot be removed.`,
+		Example: `
+  # Remove a build for your operating system.
+  getenvoy cache rm standard:1.11.1
+
+  # Remove a build for a specific platform.
+  getenvoy cache rm standard:1.11.1/linux-glibc`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("missing reference parameter")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache := envoy.DefaultBuildCache()
+			for _, reference := range args {
+				key, err := manifest.NewKey(reference)
+				if err != nil {
+					return err
+				}
+				if err := cache.Remove(key); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "removed %v\n", key)
+			}
+			return nil
+		},
+	}
+}
+
+func newCachePruneCmd() *cobra.Command {
+	keep := 0
+	olderThan := ""
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove Envoy builds that are no longer used.",
+		Long: `
+Remove downloaded Envoy builds that have not been used recently. Builds that are currently being run are never removed.`,
+		Example: `
+  # Remove all builds except for the 3 most recently used ones.
+  getenvoy cache prune --keep 3
+
+  # Remove builds that have not been used for 30 days.
+  getenvoy cache prune --older-than 30d`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if keep < 0 {
+				return errors.New("--keep must not be negative")
+			}
+			if keep == 0 && olderThan == "" {
+				return errors.New("at least one of --keep or --older-than must be set")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			age, err := parseAge(olderThan)
+			if err != nil {
+				return err
+			}
+			removed, err := envoy.DefaultBuildCache().Prune(keep, age)
+			for _, build := range removed {
+				fmt.Fprintf(cmd.OutOrStdout(), "removed %v\n", build.Key)
+			}
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&keep, "keep", keep, "number of most recently used builds to keep")
+	cmd.Flags().StringVar(&olderThan, "older-than", olderThan,
+		"remove only builds that have not been used for this long, e.g. 30d, 12h")
+	return cmd
+}
+
+// parseAge parses a duration that, in addition to time.ParseDuration units, might be expressed in days, e.g. 30d.
+func parseAge(text string) (time.Duration, error) {
+	if text == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(text, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(text, "d"), 10, 32)
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	age, err := time.ParseDuration(text)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("%q is not a valid age, e.g. 30d or 12h", text)
+	}
+	return age, nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
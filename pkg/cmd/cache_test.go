@@ -0,0 +1,126 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/spf13/cobra"
+
+	. "github.com/tetratelabs/getenvoy/pkg/cmd"
+
+	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
+)
+
+var _ = Describe("getenvoy cache", func() {
+
+	var homeDir string
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		homeDir = dir
+	})
+
+	AfterEach(func() {
+		if homeDir != "" {
+			Expect(os.RemoveAll(homeDir)).To(Succeed())
+		}
+	})
+
+	givenBuild := func(path string, lastUsed time.Time) {
+		dir := filepath.Join(homeDir, "builds", path)
+		Expect(os.MkdirAll(filepath.Join(dir, "bin"), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "bin", "envoy"), []byte("some c++"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, ".last-used"), nil, 0600)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(dir, ".last-used"), lastUsed, lastUsed)).To(Succeed())
+	}
+
+	var stdout *bytes.Buffer
+	var stderr *bytes.Buffer
+	var c *cobra.Command
+
+	BeforeEach(func() {
+		stdout = new(bytes.Buffer)
+		stderr = new(bytes.Buffer)
+		c = NewRoot()
+		c.SetOut(stdout)
+		c.SetErr(stderr)
+	})
+
+	It("should list downloaded builds", func() {
+		lastUsed := time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local)
+		givenBuild("standard/1.11.0/linux_glibc", lastUsed)
+
+		By("running command")
+		c.SetArgs([]string{"--home-dir", homeDir, "cache", "list"})
+		err := cmdutil.Execute(c)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("verifying command output")
+		Expect(stdout.String()).To(Equal(`REFERENCE                       SIZE     LAST USED
+standard:1.11.0/linux-glibc     8 B      2021-01-02 03:04:05
+`))
+		Expect(stderr.String()).To(BeEmpty())
+	})
+
+	It("should prune builds that have not been used recently", func() {
+		givenBuild("standard/1.10.0/linux_glibc", time.Now().Add(-31*24*time.Hour))
+		givenBuild("standard/1.11.0/linux_glibc", time.Now())
+
+		By("running command")
+		c.SetArgs([]string{"--home-dir", homeDir, "cache", "prune", "--older-than", "30d"})
+		err := cmdutil.Execute(c)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("verifying command output")
+		Expect(stdout.String()).To(Equal("removed standard:1.10.0/linux-glibc\n"))
+		Expect(filepath.Join(homeDir, "builds", "standard", "1.10.0")).NotTo(BeADirectory())
+		Expect(filepath.Join(homeDir, "builds", "standard", "1.11.0", "linux_glibc")).To(BeADirectory())
+	})
+
+	It("should require a prune criteria", func() {
+		By("running command")
+		c.SetArgs([]string{"--home-dir", homeDir, "cache", "prune"})
+		err := cmdutil.Execute(c)
+		Expect(err).To(HaveOccurred())
+
+		By("verifying command output")
+		Expect(stderr.String()).To(Equal(`Error: at least one of --keep or --older-than must be set
+
+Run 'getenvoy cache prune --help' for usage.
+`))
+	})
+
+	It("should remove a build by reference", func() {
+		givenBuild("standard/1.11.0/darwin", time.Now())
+
+		By("running command")
+		c.SetArgs([]string{"--home-dir", homeDir, "cache", "rm", "standard:1.11.0/darwin"})
+		err := cmdutil.Execute(c)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("verifying command output")
+		Expect(stdout.String()).To(Equal("removed standard:1.11.0/darwin\n"))
+		Expect(filepath.Join(homeDir, "builds", "standard")).NotTo(BeADirectory())
+	})
+})
@@ -15,12 +15,32 @@
 package cmd_test
 
 import (
+	"os"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/tetratelabs/getenvoy/pkg/cmd"
+
+	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
 )
 
+// runCLIEnv makes the test binary run GetEnvoy instead of the tests, e.g. when `getenvoy run --detach` runs itself again
+const runCLIEnv = "GETENVOY_TEST_RUN_CLI"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runCLIEnv) != "" {
+		root := cmd.NewRoot()
+		root.SetArgs(os.Args[1:])
+		if err := cmdutil.Execute(root); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
 func TestCmd(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Cmd Suite")
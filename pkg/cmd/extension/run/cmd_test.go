@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	. "github.com/onsi/ginkgo"
@@ -155,9 +156,14 @@ var _ = Describe("getenvoy extension run", func() {
 		// override location of the GetEnvoy manifest
 		err = manifest.SetURL(manifestServer.GetManifestURL())
 		Expect(err).NotTo(HaveOccurred())
+
+		// archives are built on the fly, so the test manifest has no checksums for them
+		os.Setenv("GETENVOY_INSECURE_SKIP_VERIFY", "true")
 	})
 
 	AfterEach(func() {
+		os.Unsetenv("GETENVOY_INSECURE_SKIP_VERIFY")
+		manifest.SetInsecureSkipVerify(false)
 		if manifestServer != nil {
 			manifestServer.Close()
 		}
@@ -480,7 +486,7 @@ Run 'getenvoy extension run --help' for usage.
 			Expect(stdout.String()).To(Equal(fmt.Sprintf(`%s/docker run -u 1001:1002 --rm -t -v %s:/source -w /source --init getenvoy/extension-rust-builder:latest build --output-file target/getenvoy/extension.wasm
 %s/builds/wasm/1.15/%s/bin/envoy -c %s/envoy.tmpl.yaml
 `, dockerDir, workspaceDir, getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal("docker stderr\nenvoy stderr\n"))
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun("docker stderr\n", "1.15")))
 
 			By("verifying Envoy config")
 			placeholders := envoyCaptured.readFileToJSON("placeholders.tmpl.yaml")
@@ -512,7 +518,7 @@ Run 'getenvoy extension run --help' for usage.
 			Expect(stdout.String()).To(Equal(fmt.Sprintf(`%s/docker run -u 1001:1002 --rm -t -v %s:/source -w /source --init -e VAR=VALUE -v /host:/container build/image build --output-file target/getenvoy/extension.wasm
 %s/builds/wasm/1.15/%s/bin/envoy -c %s/envoy.tmpl.yaml
 `, dockerDir, workspaceDir, getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal("docker stderr\nenvoy stderr\n"))
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun("docker stderr\n", "1.15")))
 		})
 
 		It("should properly handle Docker build failing", func() {
@@ -549,7 +555,7 @@ Run 'getenvoy extension run --help' for usage.
 			Expect(stdout.String()).To(Equal(fmt.Sprintf(`%s/docker run -u 1001:1002 --rm -t -v %s:/source -w /source --init getenvoy/extension-rust-builder:latest build --output-file target/getenvoy/extension.wasm
 %s/builds/wasm/stable/%s/bin/envoy -c %s/envoy.tmpl.yaml
 `, dockerDir, workspaceDir, getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal("docker stderr\nenvoy stderr\n"))
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun("docker stderr\n", "stable")))
 		})
 
 		It("should properly handle unknown Envoy version", func() {
@@ -602,7 +608,7 @@ Run 'getenvoy extension run --help' for usage.
 			Expect(stdout.String()).To(Equal(fmt.Sprintf(`%s/docker run -u 1001:1002 --rm -t -v %s:/source -w /source --init getenvoy/extension-rust-builder:latest build --output-file target/getenvoy/extension.wasm
 %s/builds/wasm/1.15/%s/bin/envoy -c %s/envoy.tmpl.yaml --concurrency 2 --component-log-level wasm:debug,config:trace
 `, dockerDir, workspaceDir, getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal("docker stderr\nenvoy stderr\n"))
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun("docker stderr\n", "1.15")))
 		})
 
 		It("should allow to provide a pre-build *.wasm files via --extension-file flag", func() {
@@ -626,7 +632,7 @@ Run 'getenvoy extension run --help' for usage.
 
 			By("verifying command output")
 			Expect(stdout.String()).To(Equal(fmt.Sprintf("%s/builds/wasm/1.15/%s/bin/envoy -c %s/envoy.tmpl.yaml\n", getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal("envoy stderr\n"))
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun("", "1.15")))
 
 			By("verifying Envoy config")
 			placeholders := envoyCaptured.readFileToJSON("placeholders.tmpl.yaml")
@@ -660,7 +666,7 @@ Run 'getenvoy extension run --help' for usage.
 			Expect(stdout.String()).To(Equal(fmt.Sprintf(`%s/docker run -u 1001:1002 --rm -t -v %s:/source -w /source --init getenvoy/extension-rust-builder:latest build --output-file target/getenvoy/extension.wasm
 %s/builds/wasm/1.15/%s/bin/envoy -c %s/envoy.tmpl.yaml
 `, dockerDir, workspaceDir, getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal("docker stderr\nenvoy stderr\n"))
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun("docker stderr\n", "1.15")))
 
 			By("verifying Envoy config")
 			placeholders := envoyCaptured.readFileToJSON("placeholders.tmpl.yaml")
@@ -692,15 +698,14 @@ Run 'getenvoy extension run --help' for usage.
 			Expect(stdout.String()).To(Equal(fmt.Sprintf(`%s/docker run -u 1001:1002 --rm -t -v %s:/source -w /source --init getenvoy/extension-rust-builder:latest build --output-file target/getenvoy/extension.wasm
 %s/builds/wasm/1.15/%s/bin/envoy -c %s/envoy.tmpl.yaml
 `, dockerDir, workspaceDir, getenvoyHomeDir, platform, envoyCaptured.cwd())))
-			Expect(stderr.String()).To(Equal(`Scaffolding a new example setup:
+			Expect(stderr.String()).To(MatchRegexp(stderrOfFetchAndRun(`Scaffolding a new example setup:
 * .getenvoy/extension/examples/default/README.md
 * .getenvoy/extension/examples/default/envoy.tmpl.yaml
 * .getenvoy/extension/examples/default/example.yaml
 * .getenvoy/extension/examples/default/extension.json
 Done!
 docker stderr
-envoy stderr
-`))
+`, "1.15")))
 
 			By("verifying Envoy config")
 			bootstrap := envoyCaptured.readFileToJSON("envoy.tmpl.yaml")
@@ -727,3 +732,9 @@ Run 'getenvoy extension run --help' for usage.
 		})
 	})
 })
+
+// stderrOfFetchAndRun returns a pattern of stderr of a run that has to fetch the given version of Envoy,
+// after printing the given output, before running it
+func stderrOfFetchAndRun(before, version string) string {
+	return fmt.Sprintf(`^%sfetching wasm:%s/\S+\ndownloaded \S+ \(\d+ B\)\nenvoy stderr\n$`, regexp.QuoteMeta(before), regexp.QuoteMeta(version))
+}
@@ -16,6 +16,9 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
@@ -24,37 +27,173 @@ import (
 
 // NewFetchCmd create a command responsible for retrieving Envoy binaries
 func NewFetchCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "fetch <reference>",
+	downloadOpts := envoy.DefaultDownloadOptions()
+	platforms := []string{}
+	concurrency := 3
+	fromFile := ""
+	force := false
+	cmd := &cobra.Command{
+		Use:   "fetch <reference>...",
 		Short: "Retrieve Envoy binaries from GetEnvoy.",
 		Long: `
-Retrieves the referenced Envoy binary from GetEnvoy. The reference can be a full or partial reference.
-A complete list of available builds can be retrieved using` + "`getenvoy list`" + `.`,
+Retrieves the referenced Envoy binaries from GetEnvoy. A reference can be a full or partial reference.
+A complete list of available builds can be retrieved using` + "`getenvoy list`" + `.
+Envoy builds published to OCI registries can be retrieved using ` + "`oci://<registry>/<repository>:<tag>`" + ` references.
+Several builds are downloaded in parallel, followed by a summary of what was fetched, skipped or failed.`,
 		Example: `# Fetch using a partial manifest reference to retrieve a build suitable for your operating system.
 getenvoy fetch standard:1.11.1
 		
 # Fetch using a full manifest reference to retrieve a specific build for Linux. 
-getenvoy fetch standard:1.11.1/linux-glibc`,
+getenvoy fetch standard:1.11.1/linux-glibc
+
+# Fetch a build published to an OCI registry.
+getenvoy fetch oci://registry.local/envoy/standard:1.17
+
+# Prefetch several builds for both Linux and macOS, e.g. to put them into a bundle.
+getenvoy fetch standard:1.17.0 istio:1.8.0 --platform linux-glibc,darwin
+
+# Register a custom release tarball, so that it can be run by reference.
+getenvoy fetch acme:1.17.0-custom --from-file ./envoy-1.17-custom.tar.xz
+getenvoy run acme:1.17.0-custom -- --config-path ./bootstrap.yaml
+
+# Replace a build of the GetEnvoy manifest with a local release, e.g. one with a patch applied.
+getenvoy fetch standard:1.17.0 --from-file ./envoy-1.17-patched.tar.xz --force`,
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
 				return errors.New("missing binary parameter")
 			}
+			if concurrency < 1 {
+				return errors.New("--concurrency must be at least 1")
+			}
+			if fromFile != "" && (len(args) != 1 || envoy.IsOCIReference(args[0]) || len(platforms) > 1) {
+				return errors.New("--from-file registers a release under exactly one reference")
+			}
+			if force && fromFile == "" {
+				return errors.New("--force only applies to --from-file")
+			}
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			key, err := manifest.NewKey(args[0])
+			progress := envoy.NewProgress(downloadOpts.Progress, cmd.OutOrStdout())
+			runtime, err := envoy.NewRuntime(func(r *envoy.Runtime) {
+				r.Download = downloadOpts
+				r.Progress = progress
+			})
 			if err != nil {
 				return err
 			}
-			location, err := manifest.Locate(key)
-			if err != nil {
-				return err
+			if fromFile != "" {
+				keys, err := manifest.NewKeys(args[0], platforms...)
+				if err != nil {
+					return err
+				}
+				if !force {
+					if err := checkNotInManifest(keys[0]); err != nil {
+						return err
+					}
+				}
+				imported, err := runtime.(*envoy.Runtime).Import(keys[0], fromFile)
+				if err != nil {
+					return err
+				}
+				if imported {
+					progress.Printf("registered %v as %v", fromFile, keys[0])
+				} else {
+					progress.Printf("%v is already registered as %v", fromFile, keys[0])
+				}
+				return nil
 			}
-			runtime, err := envoy.NewRuntime()
-			if err != nil {
-				return err
+			results := runtime.(*envoy.Runtime).FetchAll(args, platforms, concurrency)
+			if len(results) == 1 {
+				if results[0].Status == envoy.Skipped {
+					progress.Printf("%v is already downloaded", results[0].Reference)
+				}
+				return results[0].Err
 			}
-			return runtime.Fetch(key, location)
+			if downloadOpts.Progress == envoy.ProgressJSON {
+				return reportFetchSummary(progress, results)
+			}
+			return printFetchSummary(cmd.OutOrStdout(), results)
 		},
 	}
+	cmd.Flags().StringSliceVar(&platforms, "platform", platforms,
+		"platforms to fetch references without a platform of their own for, e.g. linux-glibc,darwin (defaults to the current one)")
+	cmd.Flags().IntVar(&concurrency, "concurrency", concurrency,
+		"maximum number of builds to download in parallel")
+	cmd.Flags().StringVar(&fromFile, "from-file", fromFile,
+		"register a local release tarball or extracted release directory under the reference instead of downloading it")
+	cmd.Flags().BoolVar(&force, "force", force,
+		"let --from-file replace a build of the GetEnvoy manifest with an unverified local release")
+	addDownloadFlags(cmd, &downloadOpts)
+	return cmd
+}
+
+// printFetchSummary writes the outcome of fetching every build and returns an error if any of them failed
+func printFetchSummary(out io.Writer, results []*envoy.FetchResult) error {
+	counts := make(map[envoy.FetchStatus]int)
+	w := tabwriter.NewWriter(out, 0, 8, 5, ' ', 0)
+	fmt.Fprintln(w, "REFERENCE\tSTATUS\tERROR")
+	for _, result := range results {
+		counts[result.Status]++
+		reason := ""
+		if result.Err != nil {
+			reason = result.Err.Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", result.Reference, result.Status, reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d fetched, %d skipped, %d failed\n", counts[envoy.Fetched], counts[envoy.Skipped], counts[envoy.Failed])
+	if counts[envoy.Failed] > 0 {
+		return fmt.Errorf("unable to fetch %d of %d builds", counts[envoy.Failed], len(results))
+	}
+	return nil
+}
+
+// reportFetchSummary reports the outcome of fetching every build as progress messages, e.g. to keep JSON output parseable
+func reportFetchSummary(progress envoy.Progress, results []*envoy.FetchResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			progress.Printf("%v %v: %v", result.Reference, result.Status, result.Err)
+			continue
+		}
+		progress.Printf("%v %v", result.Reference, result.Status)
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to fetch %d of %d builds", failed, len(results))
+	}
+	return nil
+}
+
+// addDownloadFlags adds flags that control how Envoy archives are downloaded.
+func addDownloadFlags(cmd *cobra.Command, opts *envoy.DownloadOptions) {
+	cmd.Flags().DurationVar(&opts.Timeout, "download-timeout", opts.Timeout,
+		"time limit for a single attempt to download Envoy, e.g. 5m (0 means no limit)")
+	cmd.Flags().IntVar(&opts.Retries, "download-retries", opts.Retries,
+		"number of times a failed attempt to download Envoy is retried (downloads are resumed where possible)")
+	cmd.Flags().BoolVar(&opts.PlainHTTP, "plain-http", opts.PlainHTTP,
+		"access OCI registries over plain HTTP rather than HTTPS")
+	cmd.Flags().Var(&opts.Progress, "progress",
+		"how to report progress of downloads <auto|plain|json|none> (auto uses a progress bar only on a terminal)")
+}
+
+// checkNotInManifest returns an error if the passed key refers to a build of the GetEnvoy manifest, which a local
+// release mustn't silently replace, or if the manifest can't be loaded to tell
+func checkNotInManifest(key *manifest.Key) error {
+	if key.Flavor == envoy.CustomFlavor {
+		return nil
+	}
+	m, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("unable to check whether %v is a build of the GetEnvoy manifest, use the %q flavor or --force: %v",
+			key, envoy.CustomFlavor, err)
+	}
+	if _, err := manifest.LocateBuild(key, m); err == nil {
+		return fmt.Errorf("%v is a build of the GetEnvoy manifest, use the %q flavor or --force to replace it with an unverified local release",
+			key, envoy.CustomFlavor)
+	}
+	return nil
 }
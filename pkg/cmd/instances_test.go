@@ -0,0 +1,237 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+	. "github.com/tetratelabs/getenvoy/pkg/cmd"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+
+	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+var _ = Describe("getenvoy ps, stop and logs", func() {
+
+	var tmpDir string
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		tmpDir = dir
+	})
+
+	var releases []func() error
+
+	AfterEach(func() {
+		for _, release := range releases {
+			Expect(release()).To(Succeed())
+		}
+		releases = nil
+		if tmpDir != "" {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		}
+	})
+
+	execute := func(args ...string) (string, error) {
+		stdout := new(bytes.Buffer)
+		c := NewRoot()
+		c.SetOut(stdout)
+		c.SetErr(new(bytes.Buffer))
+		c.SetArgs(append([]string{"--home-dir", tmpDir}, args...))
+		err := cmdutil.Execute(c)
+		return stdout.String(), err
+	}
+
+	// recordUnlocked pretends that the process ran Envoy, as recorded by envoy.RecordInstance, until it was killed
+	recordUnlocked := func(id string, getenvoy *exec.Cmd) *envoy.Instance {
+		debugStore := filepath.Join(tmpDir, "debug", id)
+		instance := &envoy.Instance{
+			ID:           id,
+			Name:         "edge",
+			PID:          getenvoy.Process.Pid,
+			EnvoyPID:     getenvoy.Process.Pid + 1,
+			Reference:    "standard:1.17.0/linux-glibc",
+			AdminAddress: "127.0.0.1:15000",
+			BaseID:       3,
+			DebugStore:   debugStore,
+			AccessLog:    envoy.AccessLogPath(debugStore),
+			ErrorLog:     envoy.ErrorLogPath(debugStore),
+			Started:      time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC),
+		}
+		Expect(os.MkdirAll(filepath.Dir(instance.ErrorLog), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(instance.AccessLog, []byte("GET / 200\n"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(instance.ErrorLog, []byte("starting main dispatch loop\n"), 0600)).To(Succeed())
+		bytes, err := json.Marshal(instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(tmpDir, "state"), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(tmpDir, "state", id+".json"), bytes, 0600)).To(Succeed())
+		return instance
+	}
+
+	// record pretends that the process runs Envoy, as recorded by envoy.RecordInstance
+	record := func(id string, getenvoy *exec.Cmd) *envoy.Instance {
+		instance := recordUnlocked(id, getenvoy)
+		release, err := osutil.LockFile(filepath.Join(tmpDir, "state", id+".lock"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		releases = append(releases, release)
+		return instance
+	}
+
+	It("should list running instances", func() {
+		getenvoy := exec.Command("sleep", "10")
+		Expect(getenvoy.Start()).To(Succeed())
+		defer getenvoy.Process.Kill() //nolint
+		record("5c0a7e", getenvoy)
+		gone := exec.Command("true")
+		Expect(gone.Run()).To(Succeed())
+		record("9f3e21", gone)
+
+		stdout, err := execute("ps")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(MatchRegexp(`^ID +NAME +PID +ENVOY PID +REFERENCE +ADMIN ADDRESS +BASE ID +STARTED\n`+
+			`5c0a7e +edge +%d +%d +standard:1\.17\.0/linux-glibc +127\.0\.0\.1:15000 +3 +2021-02-03T04:05:06Z\n$`,
+			getenvoy.Process.Pid, getenvoy.Process.Pid+1))
+	})
+
+	It("should stop an instance by a prefix of its ID", func() {
+		getenvoy := exec.Command("sleep", "10")
+		Expect(getenvoy.Start()).To(Succeed())
+		record("5c0a7e", getenvoy)
+		exited := make(chan error, 1)
+		go func() {
+			exited <- getenvoy.Wait()
+		}()
+
+		stdout, err := execute("stop", "5c0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("5c0a7e\n"))
+		Expect(<-exited).To(MatchError("signal: interrupt"))
+	})
+
+	It("should neither list nor stop an instance whose GetEnvoy process was killed", func() {
+		// the process ID of GetEnvoy has been reused by an unrelated process
+		unrelated := exec.Command("sleep", "10")
+		Expect(unrelated.Start()).To(Succeed())
+		defer unrelated.Process.Kill() //nolint
+		recordUnlocked("5c0a7e", unrelated)
+
+		stdout, err := execute("ps")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(MatchRegexp(`^ID +NAME +PID +ENVOY PID +REFERENCE +ADMIN ADDRESS +BASE ID +STARTED\n$`))
+
+		recordUnlocked("5c0a7e", unrelated)
+		_, err = execute("stop", "5c0a7e")
+		Expect(err).To(MatchError(`there is no Envoy instance "5c0a7e" running`))
+		Expect(unrelated.Process.Signal(syscall.Signal(0))).To(Succeed(), "expected the process not to be signalled")
+	})
+
+	It("should reject unknown instances", func() {
+		_, err := execute("stop", "5c0a7e")
+		Expect(err).To(MatchError(`there is no Envoy instance "5c0a7e" running`))
+	})
+
+	It("should print the logs of an instance", func() {
+		getenvoy := exec.Command("sleep", "10")
+		Expect(getenvoy.Start()).To(Succeed())
+		defer getenvoy.Process.Kill() //nolint
+		record("5c0a7e", getenvoy)
+
+		stdout, err := execute("logs", "edge")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("starting main dispatch loop\n"))
+
+		stdout, err = execute("logs", "5c0a7e", "--access-log")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("GET / 200\n"))
+	})
+
+	It("should follow the logs of an instance until it has stopped", func() {
+		getenvoy := exec.Command("sleep", "1")
+		Expect(getenvoy.Start()).To(Succeed())
+		instance := record("5c0a7e", getenvoy)
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(300 * time.Millisecond)
+			f, err := os.OpenFile(instance.ErrorLog, os.O_APPEND|os.O_WRONLY, 0600)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close() //nolint
+			_, err = f.WriteString("shutting down\n")
+			Expect(err).NotTo(HaveOccurred())
+			getenvoy.Wait() //nolint
+		}()
+
+		stdout, err := execute("logs", "-f", "5c0a7e")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("starting main dispatch loop\nshutting down\n"))
+	})
+
+	It("should run Envoy in the background until it is stopped", func() {
+		os.Setenv(runCLIEnv, "1")    //nolint
+		defer os.Unsetenv(runCLIEnv) //nolint
+		envoyPath, err := filepath.Abs(filepath.Join("testdata", "envoy.sh"))
+		Expect(err).NotTo(HaveOccurred())
+
+		stdout, err := execute("run", envoyPath, "--detach", "--name", "edge", "--drain-time", "0", "--", "--concurrency", "1")
+		Expect(err).NotTo(HaveOccurred())
+		id := strings.TrimSpace(stdout)
+		Expect(id).NotTo(BeEmpty())
+
+		// the flags reach GetEnvoy in the background, e.g. the home directory and the name
+		stdout, err = execute("ps")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(MatchRegexp(`\n%s +edge +\d+ +\d+ +%s +`, id, envoyPath))
+
+		stdout, err = execute("stop", id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal(id + "\n"))
+	})
+
+	It("should give up on Envoy if it doesn't start in the background in time", func() {
+		os.Setenv(runCLIEnv, "1")    //nolint
+		defer os.Unsetenv(runCLIEnv) //nolint
+		// the manifest never arrives, so GetEnvoy hangs before it starts Envoy
+		hung := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-hung
+		}))
+		defer server.Close()
+		defer close(hung)
+		defer manifest.SetURL(manifest.GetURL()) //nolint
+
+		_, err := execute("--manifest", server.URL+"/manifest.json", "run", "standard:1.17.0", "--detach", "--detach-timeout", "1s")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("unable to start Envoy in the background, it didn't start within 1s:"))
+
+		stdout, err := execute("ps")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(MatchRegexp(`^ID +NAME +PID +ENVOY PID +REFERENCE +ADMIN ADDRESS +BASE ID +STARTED\n$`))
+	})
+})
@@ -15,21 +15,49 @@
 package cmd
 
 import (
-	"os"
+	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
 	"github.com/tetratelabs/getenvoy/pkg/manifest"
 )
 
 // NewListCmd returns command that lists available Envoy binaries
 func NewListCmd() *cobra.Command {
-	return &cobra.Command{
+	opts := manifest.PrintOptions{Format: manifest.TableFormat}
+	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List available Envoys provided by GetEnvoy.",
 		Long: `
-Retrieves a list of Envoy builds provided by GetEnvoy.`,
+Retrieves a list of Envoy builds provided by GetEnvoy, marking the ones already present in the local cache.`,
+		Example: `
+  # List FIPS compliant builds for Linux.
+  getenvoy list --compliance fips1402 --platform linux-glibc
+
+  # List builds of the istio flavors as JSON.
+  getenvoy list --flavor istio -o json`,
+		Args: func(cmd *cobra.Command, _ []string) error {
+			for _, format := range manifest.PrintFormats {
+				if opts.Format == format {
+					return nil
+				}
+			}
+			return fmt.Errorf("unsupported output format %q, expected one of %v", opts.Format, strings.Join(manifest.PrintFormats, "|"))
+		},
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return manifest.Print(os.Stdout)
+			opts.Cached = envoy.DefaultBuildCache().Has
+			return manifest.Print(cmd.OutOrStdout(), opts)
 		},
 	}
+	cmd.Flags().StringVarP(&opts.Format, "output", "o", opts.Format,
+		fmt.Sprintf("output format <%v>", strings.Join(manifest.PrintFormats, "|")))
+	cmd.Flags().StringVar(&opts.Filter.Flavor, "flavor", "",
+		"only list builds of the flavor with a given name or filter profile, e.g. istio")
+	cmd.Flags().StringVar(&opts.Filter.Platform, "platform", "",
+		"only list builds for a given platform, e.g. linux-glibc")
+	cmd.Flags().StringVar(&opts.Filter.Compliance, "compliance", "",
+		"only list builds that meet a given compliance requirement, e.g. fips1402")
+	return cmd
 }
@@ -0,0 +1,96 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+)
+
+// followInterval is how often logs checks for new lines when following a log
+var followInterval = 250 * time.Millisecond
+
+// NewLogsCmd returns a command that prints the logs of Envoy instances run by other GetEnvoy processes
+func NewLogsCmd() *cobra.Command {
+	follow := false
+	accessLog := false
+	cmd := &cobra.Command{
+		Use:   "logs <name|id>",
+		Short: "Print the logs of a running Envoy instance.",
+		Long: `
+Prints the logs of an Envoy instance listed by ` + "`getenvoy ps`" + `, as captured into its debug store.
+By default, the stderr of Envoy is printed, which is where Envoy logs to.
+An instance can be referred to by its name, its ID or a prefix of its ID as long as it is unambiguous.`,
+		Example: `
+  # Follow the logs of Envoy run in the background.
+  getenvoy run standard:1.17.0 --detach -- --config-path ./bootstrap.yaml
+  getenvoy logs -f 5c0a7e
+
+  # Print the access logs Envoy writes to /dev/stdout.
+  getenvoy logs --access-log 5c0a7e`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected exactly one instance name or ID parameter")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instance, err := envoy.FindInstance(args[0])
+			if err != nil {
+				return err
+			}
+			path := instance.ErrorLog
+			if accessLog {
+				path = instance.AccessLog
+			}
+			return printLog(cmd.OutOrStdout(), path, follow, instance.Running)
+		},
+	}
+	cmd.Flags().BoolVarP(&follow, "follow", "f", follow,
+		"keep printing new lines until the instance has stopped")
+	cmd.Flags().BoolVar(&accessLog, "access-log", accessLog,
+		"print the stdout of Envoy, i.e. its access logs, instead of its stderr")
+	return cmd
+}
+
+// printLog copies the log to out, and with follow, keeps copying what is appended to it while running returns true
+func printLog(out io.Writer, path string, follow bool, running func() bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no logs have been captured at %v, is log collection enabled?", path)
+		}
+		return err
+	}
+	defer f.Close() //nolint
+	for {
+		// what is appended before the instance is seen stopped is still copied
+		stopped := !follow || !running()
+		if _, err := io.Copy(out, f); err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+		time.Sleep(followInterval)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+)
+
+// NewPsCmd returns a command that lists Envoy instances run by GetEnvoy
+func NewPsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List running Envoy instances.",
+		Long: `
+Lists Envoy instances run by ` + "`getenvoy run`" + `, both in the foreground and in the background.
+PID is the process ID of GetEnvoy, which supervises Envoy and is what ` + "`getenvoy stop`" + ` signals.`,
+		Example: `
+  # Run Envoy in the background and list it.
+  getenvoy run standard:1.17.0 --detach -- --config-path ./bootstrap.yaml
+  getenvoy ps`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("unexpected parameters")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instances, err := envoy.Instances()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 3, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tPID\tENVOY PID\tREFERENCE\tADMIN ADDRESS\tBASE ID\tSTARTED")
+			for _, i := range instances {
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", i.ID, i.Name, i.PID, i.EnvoyPID, i.Reference,
+					orDash(i.AdminAddress), orDash(i.BaseIDString()), i.Started.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+	return cmd
+}
+
+// orDash returns a placeholder for an empty column
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+)
+
+// NewReloadCmd returns a command that hot restarts Envoy run by another GetEnvoy process
+func NewReloadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reload [<pid>]",
+		Short: "Hot restart Envoy to reload its configuration.",
+		Long: `
+Hot restart Envoy run by ` + "`getenvoy run --hot-restart`" + ` to reload its configuration without dropping connections.
+A new epoch of Envoy is started with the current bootstrap, and the previous one drains its connections
+before it is shut down. It is equivalent to sending SIGHUP to the GetEnvoy process.
+Without the process ID of GetEnvoy, the only one running Envoy with hot restart enabled is reloaded.`,
+		Example: `
+  # Reload Envoy after changing its bootstrap.
+  getenvoy run standard:1.17.0 --hot-restart -- --config-path ./bootstrap.yaml &
+  getenvoy reload`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("expected at most one process ID parameter")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pid, err := reloadablePID(args)
+			if err != nil {
+				return err
+			}
+			p, err := os.FindProcess(pid)
+			if err != nil {
+				return err
+			}
+			if err := p.Signal(syscall.SIGHUP); err != nil {
+				return fmt.Errorf("unable to reload Envoy run by GetEnvoy process (PID=%d): %v", pid, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "reloading Envoy run by GetEnvoy process (PID=%d)\n", pid)
+			return nil
+		},
+	}
+	return cmd
+}
+
+// reloadablePID returns the process ID passed as an argument or otherwise the only GetEnvoy process that can be reloaded.
+// Only GetEnvoy processes that run Envoy with hot restart enabled handle SIGHUP, any other process would be terminated by it.
+func reloadablePID(args []string) (int, error) {
+	pids, err := envoy.HotRestartPIDs()
+	if err != nil {
+		return 0, err
+	}
+	if len(args) == 1 {
+		pid, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a valid process ID", args[0])
+		}
+		for _, reloadable := range pids {
+			if pid == reloadable {
+				return pid, nil
+			}
+		}
+		if len(pids) == 0 {
+			return 0, fmt.Errorf("process %d is not a GetEnvoy process running Envoy with --hot-restart, there is none", pid)
+		}
+		return 0, fmt.Errorf("process %d is not a GetEnvoy process running Envoy with --hot-restart, pick one of %v", pid, pids)
+	}
+	switch len(pids) {
+	case 0:
+		return 0, errors.New("there is no GetEnvoy process running Envoy with --hot-restart")
+	case 1:
+		return pids[0], nil
+	default:
+		return 0, fmt.Errorf("there are several GetEnvoy processes running Envoy with --hot-restart, pick one of %v", pids)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+	. "github.com/tetratelabs/getenvoy/pkg/cmd"
+
+	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
+	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
+)
+
+var _ = Describe("getenvoy reload", func() {
+
+	var tmpDir string
+	var releases []func() error
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		tmpDir = dir
+		releases = nil
+	})
+
+	AfterEach(func() {
+		for _, release := range releases {
+			release() //nolint
+		}
+		if tmpDir != "" {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		}
+	})
+
+	execute := func(args ...string) (string, error) {
+		stdout := new(bytes.Buffer)
+		c := NewRoot()
+		c.SetOut(stdout)
+		c.SetErr(new(bytes.Buffer))
+		c.SetArgs(append([]string{"--home-dir", tmpDir}, args...))
+		err := cmdutil.Execute(c)
+		return stdout.String(), err
+	}
+
+	// recordUnlocked leaves a record of an instance run with hot restart enabled as a GetEnvoy process that was killed would
+	recordUnlocked := func(pid int) string {
+		id := strconv.Itoa(pid)
+		bytes, err := json.Marshal(&envoy.Instance{ID: id, Name: "envoy-" + id, PID: pid, HotRestart: true})
+		Expect(err).NotTo(HaveOccurred())
+		path := filepath.Join(tmpDir, "state", id+".json")
+		Expect(os.MkdirAll(filepath.Dir(path), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(path, bytes, 0600)).To(Succeed())
+		return path
+	}
+
+	// register pretends that the process runs Envoy with hot restart enabled, as recorded by envoy.RecordInstance
+	register := func(pid int) string {
+		path := recordUnlocked(pid)
+		release, err := osutil.LockFile(strings.TrimSuffix(path, ".json")+".lock", nil)
+		Expect(err).NotTo(HaveOccurred())
+		releases = append(releases, release)
+		return path
+	}
+
+	It("should send SIGHUP to the only GetEnvoy process running Envoy with hot restart", func() {
+		getenvoy := exec.Command("sleep", "10")
+		Expect(getenvoy.Start()).To(Succeed())
+		register(getenvoy.Process.Pid)
+
+		stdout, err := execute("reload")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal(fmt.Sprintf("reloading Envoy run by GetEnvoy process (PID=%d)\n", getenvoy.Process.Pid)))
+		Expect(getenvoy.Wait()).To(MatchError("signal: hangup"))
+	})
+
+	It("should forget GetEnvoy processes that are gone", func() {
+		gone := exec.Command("true")
+		Expect(gone.Run()).To(Succeed())
+		registration := register(gone.Process.Pid)
+
+		_, err := execute("reload")
+		Expect(err).To(MatchError("there is no GetEnvoy process running Envoy with --hot-restart"))
+		Expect(registration).NotTo(BeAnExistingFile())
+	})
+
+	It("should send SIGHUP to the GetEnvoy process picked by its ID", func() {
+		getenvoy := exec.Command("sleep", "10")
+		Expect(getenvoy.Start()).To(Succeed())
+		register(getenvoy.Process.Pid)
+		other := exec.Command("sleep", "10")
+		Expect(other.Start()).To(Succeed())
+		defer other.Process.Kill() //nolint
+		register(other.Process.Pid)
+
+		stdout, err := execute("reload", strconv.Itoa(getenvoy.Process.Pid))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal(fmt.Sprintf("reloading Envoy run by GetEnvoy process (PID=%d)\n", getenvoy.Process.Pid)))
+		Expect(getenvoy.Wait()).To(MatchError("signal: hangup"))
+	})
+
+	It("should not signal a process that doesn't run Envoy with hot restart", func() {
+		unrelated := exec.Command("sleep", "10")
+		Expect(unrelated.Start()).To(Succeed())
+		defer unrelated.Process.Kill() //nolint
+		getenvoy := exec.Command("sleep", "10")
+		Expect(getenvoy.Start()).To(Succeed())
+		defer getenvoy.Process.Kill() //nolint
+
+		_, err := execute("reload", strconv.Itoa(unrelated.Process.Pid))
+		Expect(err).To(MatchError(fmt.Sprintf("process %d is not a GetEnvoy process running Envoy with --hot-restart, there is none", unrelated.Process.Pid)))
+
+		register(getenvoy.Process.Pid)
+		_, err = execute("reload", strconv.Itoa(unrelated.Process.Pid))
+		Expect(err).To(MatchError(fmt.Sprintf("process %d is not a GetEnvoy process running Envoy with --hot-restart, pick one of [%d]", unrelated.Process.Pid, getenvoy.Process.Pid)))
+		Expect(unrelated.Process.Signal(syscall.Signal(0))).To(Succeed(), "expected the process not to be signalled")
+	})
+
+	It("should not signal a process that reused the ID of a GetEnvoy process that was killed", func() {
+		unrelated := exec.Command("sleep", "10")
+		Expect(unrelated.Start()).To(Succeed())
+		defer unrelated.Process.Kill() //nolint
+		recordUnlocked(unrelated.Process.Pid)
+
+		_, err := execute("reload")
+		Expect(err).To(MatchError("there is no GetEnvoy process running Envoy with --hot-restart"))
+		Expect(unrelated.Process.Signal(syscall.Signal(0))).To(Succeed(), "expected the process not to be signalled")
+	})
+
+	It("should reject an invalid process ID", func() {
+		_, err := execute("reload", "envoy")
+		Expect(err).To(MatchError(`"envoy" is not a valid process ID`))
+	})
+})
@@ -15,8 +15,12 @@
 package cmd
 
 import (
+	"crypto/ed25519"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tetratelabs/log"
 
@@ -27,6 +31,7 @@ import (
 	"github.com/tetratelabs/getenvoy/pkg/cmd/extension"
 	"github.com/tetratelabs/getenvoy/pkg/common"
 	"github.com/tetratelabs/getenvoy/pkg/manifest"
+	"github.com/tetratelabs/getenvoy/pkg/transport"
 	"github.com/tetratelabs/getenvoy/pkg/version"
 
 	osutil "github.com/tetratelabs/getenvoy/pkg/util/os"
@@ -39,14 +44,22 @@ import (
 //  2) otherwise, to a non-empty value of the environment variable, e.g. `GETENVOY_HOME`
 //  3) otherwise, to the default value, e.g. `${HOME}/.getenvoy`
 type globalOpts struct {
-	HomeDir     string
-	ManifestURL string
+	HomeDir      string
+	ManifestURLs []string
+	TrustedKeys  []string
+	Offline      bool
+	SkipVerify   bool
+	ManifestTTL  time.Duration
+	HTTPConfig   string
+	Proxy        string
+	CABundle     string
 }
 
 func newRootOpts() *globalOpts {
 	return &globalOpts{
-		HomeDir:     common.DefaultHomeDir(),
-		ManifestURL: manifest.GetURL(),
+		HomeDir:      common.DefaultHomeDir(),
+		ManifestURLs: manifest.GetURLs(),
+		ManifestTTL:  manifest.DefaultTTL,
 	}
 }
 
@@ -70,12 +83,29 @@ bootstrap generation and automated collection of access logs, Envoy state and ma
 			}
 			common.HomeDir = rootOpts.HomeDir
 
-			if rootOpts.ManifestURL == "" {
+			if len(rootOpts.ManifestURLs) == 0 || rootOpts.ManifestURLs[0] == "" {
 				return errors.New("GetEnvoy manifest URL cannot be empty")
 			}
-			if err := manifest.SetURL(rootOpts.ManifestURL); err != nil {
+			if err := manifest.SetURLs(rootOpts.ManifestURLs...); err != nil {
 				return err
 			}
+			manifest.SetOffline(rootOpts.Offline)
+			manifest.SetInsecureSkipVerify(rootOpts.SkipVerify)
+			manifest.SetTTL(rootOpts.ManifestTTL)
+
+			if err := configureTransport(rootOpts); err != nil {
+				return err
+			}
+
+			trustedKeys := make([]ed25519.PublicKey, 0, len(rootOpts.TrustedKeys))
+			for _, text := range rootOpts.TrustedKeys {
+				key, err := manifest.ParsePublicKey(text)
+				if err != nil {
+					return err
+				}
+				trustedKeys = append(trustedKeys, key)
+			}
+			manifest.SetTrustedKeys(trustedKeys...)
 
 			if configureLogging {
 				return log.Configure(logOpts)
@@ -85,8 +115,15 @@ bootstrap generation and automated collection of access logs, Envoy state and ma
 	}
 
 	rootCmd.AddCommand(NewRunCmd())
+	rootCmd.AddCommand(NewReloadCmd())
+	rootCmd.AddCommand(NewPsCmd())
+	rootCmd.AddCommand(NewStopCmd())
+	rootCmd.AddCommand(NewLogsCmd())
 	rootCmd.AddCommand(NewListCmd())
 	rootCmd.AddCommand(NewFetchCmd())
+	rootCmd.AddCommand(NewUseCmd())
+	rootCmd.AddCommand(NewCacheCmd())
+	rootCmd.AddCommand(NewBundleCmd())
 	rootCmd.AddCommand(NewDocCmd())
 	rootCmd.AddCommand(extension.NewCmd())
 
@@ -95,12 +132,79 @@ bootstrap generation and automated collection of access logs, Envoy state and ma
 	}
 	rootCmd.PersistentFlags().StringVar(&rootOpts.HomeDir, "home-dir", osutil.Getenv("GETENVOY_HOME", rootOpts.HomeDir),
 		"GetEnvoy home directory (location of downloaded artifacts, caches, etc)")
-	rootCmd.PersistentFlags().StringVar(&rootOpts.ManifestURL, "manifest", osutil.Getenv("GETENVOY_MANIFEST_URL", rootOpts.ManifestURL),
-		"GetEnvoy manifest URL (source of information about available Envoy builds)")
-	rootCmd.PersistentFlags().MarkHidden("manifest") // nolint
+	manifestURLs := rootOpts.ManifestURLs
+	if urls := splitList(os.Getenv("GETENVOY_MANIFEST_URL")); len(urls) > 0 {
+		manifestURLs = urls
+	}
+	rootCmd.PersistentFlags().StringSliceVar(&rootOpts.ManifestURLs, "manifest", manifestURLs,
+		"GetEnvoy manifest URL, file or directory (source of information about available Envoy builds). "+
+			"Can be repeated to merge several manifests, earlier ones take precedence and later ones act as mirrors")
+	rootCmd.PersistentFlags().DurationVar(&rootOpts.ManifestTTL, "manifest-ttl", getenvDuration("GETENVOY_MANIFEST_TTL", rootOpts.ManifestTTL),
+		"how long a cached GetEnvoy manifest is used before checking for a newer one")
+	rootCmd.PersistentFlags().MarkHidden("manifest-ttl") // nolint
+	rootCmd.PersistentFlags().BoolVar(&rootOpts.Offline, "offline", getenvBool("GETENVOY_OFFLINE", rootOpts.Offline),
+		"use only the cached GetEnvoy manifest and already downloaded Envoy builds")
+	rootCmd.PersistentFlags().BoolVar(&rootOpts.SkipVerify, "insecure-skip-verify", getenvBool("GETENVOY_INSECURE_SKIP_VERIFY", rootOpts.SkipVerify),
+		"fetch Envoy builds the manifest has no checksum for without verifying their integrity")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.HTTPConfig, "http-config", os.Getenv("GETENVOY_HTTP_CONFIG"),
+		"HTTP config file with a proxy, a CA bundle and per-host credentials (defaults to http.yaml in the GetEnvoy home directory)")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.Proxy, "proxy", os.Getenv("GETENVOY_PROXY"),
+		"URL of the proxy to send HTTP requests through (overrides HTTP config file)")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.CABundle, "ca-bundle", os.Getenv("GETENVOY_CA_BUNDLE"),
+		"PEM bundle of certificates to trust in addition to the system ones (overrides HTTP config file)")
+	rootCmd.PersistentFlags().StringSliceVar(&rootOpts.TrustedKeys, "trusted-key", splitList(os.Getenv("GETENVOY_TRUSTED_KEYS")),
+		"base64-encoded Ed25519 public key Envoy builds must be signed with (can be repeated)")
 	return rootCmd
 }
 
+// configureTransport sets up HTTP requests made by GetEnvoy, e.g. to fetch manifests, Envoy builds and wasm images.
+func configureTransport(opts *globalOpts) error {
+	path := opts.HTTPConfig
+	if path == "" {
+		path = filepath.Join(opts.HomeDir, "http.yaml")
+	}
+	config, err := transport.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if opts.Proxy != "" {
+		config.Proxy = opts.Proxy
+	}
+	if opts.CABundle != "" {
+		config.CABundle = opts.CABundle
+	}
+	transportOpts, err := config.Options()
+	if err != nil {
+		return err
+	}
+	transport.SetDefaultOptions(transportOpts...)
+	return nil
+}
+
+// splitList splits a comma-separated value of an environment variable.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// getenvBool returns a boolean value of an environment variable or the default if it is not set or malformed.
+func getenvBool(name string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getenvDuration returns a duration value of an environment variable or the default if it is not set or malformed.
+func getenvDuration(name string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 // enableLoggingConfig checks whether logging should be configurable.
 //
 // At the moment, logging configuration is disabled by default to avoid abundance of options.
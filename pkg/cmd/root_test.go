@@ -17,6 +17,7 @@ package cmd_test
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -198,6 +199,37 @@ Run 'getenvoy fake-command --help' for usage.
 		Expect(manifest.GetURL()).To(Equal(expected))
 	})
 
+	It("should support several '--manifest' command line options", func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`{}`), 0600)).To(Succeed())
+		expected := []string{"https://host/path/to/manifest", "file://" + filepath.Join(dir, "manifest.json"), dir}
+
+		By("running command")
+		c := newRootCmd(stdout, stderr)
+		c.SetArgs([]string{"--manifest", expected[0], "--manifest", expected[1] + "," + expected[2], "fake-command"})
+		err = cmdutil.Execute(c)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("verifying global state")
+		Expect(manifest.GetURLs()).To(Equal(expected))
+	})
+
+	It("should reject invalid '--proxy' command line option", func() {
+		By("running command")
+		c := newRootCmd(stdout, stderr)
+		c.SetArgs([]string{"--proxy", "not a url", "fake-command"})
+		err := cmdutil.Execute(c)
+		Expect(err).To(HaveOccurred())
+
+		By("verifying command output")
+		Expect(stderr.String()).To(Equal(`Error: "not a url" is not a valid proxy URL
+
+Run 'getenvoy fake-command --help' for usage.
+`))
+	})
+
 	It("should reject empty '--manifest' command line option", func() {
 		unexpected := "https://host/path/that/should/be/ignored" //nolint:goconst
 
@@ -218,7 +250,7 @@ Run 'getenvoy fake-command --help' for usage.
 
 	It("should reject invalid '--manifest' command line option", func() {
 		unexpected := "https://host/path/that/should/be/ignored" //nolint:goconst
-		invalid := "/not/a/url"
+		invalid := "ftp:/not/a/url"
 
 		By("running command")
 		os.Setenv("GETENVOY_MANIFEST_URL", unexpected)
@@ -229,7 +261,7 @@ Run 'getenvoy fake-command --help' for usage.
 
 		By("verifying command output")
 		Expect(stdout.String()).To(BeEmpty())
-		Expect(stderr.String()).To(Equal(`Error: "/not/a/url" is not a valid manifest URL
+		Expect(stderr.String()).To(Equal(`Error: "ftp:/not/a/url" is not a valid manifest URL
 
 Run 'getenvoy fake-command --help' for usage.
 `))
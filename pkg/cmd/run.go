@@ -15,14 +15,25 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
 	"github.com/tetratelabs/getenvoy/pkg/binary/envoy/controlplane"
 	"github.com/tetratelabs/getenvoy/pkg/binary/envoy/debug"
+	"github.com/tetratelabs/getenvoy/pkg/common"
 
 	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
 
@@ -37,16 +48,34 @@ var (
 	mode                   string
 	bootstrap              string
 	templateArgs           map[string]string
+	downloadOpts           = envoy.DefaultDownloadOptions()
+	restartOpts            = envoy.DefaultRestartOptions()
+	hotRestartOpts         = envoy.HotRestartOptions{}
+	drainTime              = 30 * time.Second
+	killTimeout            = envoy.DefaultKillTimeout
+	detach                 bool
+	detachTimeout          time.Duration
+	instanceOpts           = envoy.InstanceOptions{AllocateAdminPort: true}
 )
 
+// instanceIDEnv tells GetEnvoy which ID to record Envoy under, it is set when GetEnvoy runs itself in the background
+const instanceIDEnv = "GETENVOY_INSTANCE_ID"
+
+// defaultDetachTimeout is how long GetEnvoy waits for Envoy to start in the background by default
+const defaultDetachTimeout = 5 * time.Minute
+
+// detachInterval is how often GetEnvoy checks whether it has started Envoy in the background
+var detachInterval = 100 * time.Millisecond
+
 // NewRunCmd create a command responsible for starting an Envoy process
 func NewRunCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "run <reference|filepath> [flags] [-- <envoy-args>]",
+		Use:   "run [<reference|filepath>] [flags] [-- <envoy-args>]",
 		Short: "Runs an instance of Envoy.",
 		Long: `
 Manages full lifecycle of Envoy including bootstrap generation and automated collection of access logs,
-Envoy state and machine state into the ` + "`~/.getenvoy/debug`" + ` directory.`,
+Envoy state and machine state into the ` + "`~/.getenvoy/debug`" + ` directory.
+Without a reference, the one pinned by ` + "`getenvoy use`" + ` is run.`,
 		Example: `# Run using a manifest reference.
 getenvoy run standard:1.11.1 -- --config-path ./bootstrap.yaml
 
@@ -56,6 +85,28 @@ getenvoy run standard:1.11.1 --mode loadbalancer --bootstrap istio --controlplan
 # Run using a filepath.
 getenvoy run ./envoy -- --config-path ./bootstrap.yaml
 
+# Run using the reference pinned for the current directory.
+getenvoy use standard:1.17.0
+getenvoy run -- --config-path ./bootstrap.yaml
+
+# Run a local release tarball, registering it as custom:envoy-1.17-custom.
+getenvoy run ./envoy-1.17-custom.tar.xz -- --config-path ./bootstrap.yaml
+
+# Run as a long-running gateway, restarting Envoy unless it crashes 5 times in a row.
+getenvoy run standard:1.17.0 --restart on-failure --max-restarts 5 -- --config-path ./bootstrap.yaml
+
+# Run with hot restart enabled, so that the configuration can be reloaded without dropping connections.
+getenvoy run standard:1.17.0 --hot-restart -- --config-path ./bootstrap.yaml
+getenvoy reload
+
+# Run in the background, then follow its logs and stop it using the printed ID.
+getenvoy run standard:1.17.0 --detach -- --config-path ./bootstrap.yaml
+getenvoy logs -f <id>
+getenvoy stop <id>
+
+# Run a second Envoy next to the first one, a free admin port is picked if the default one is taken.
+getenvoy run standard:1.17.0 --name edge -- --config-path ./edge.yaml
+
 # List available Envoy flags.
 getenvoy run standard:1.11.1 -- --help
 
@@ -63,15 +114,21 @@ getenvoy run standard:1.11.1 -- --help
 getenvoy run postgres:nightly --templateArg endpoints=127.0.0.1:5432,192.168.0.101:5432 --templateArg inport=5555
 `,
 		Args: func(cmd *cobra.Command, args []string) error {
-			return validateCmdArgs(args)
-
+			return validateCmdArgs()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			instanceOpts.ID = os.Getenv(instanceIDEnv)
+			if detach && instanceOpts.ID == "" {
+				return runDetached(cmd, args)
+			}
+			instanceOpts.AllocateBaseID = !cmd.Flags().Changed("base-id")
+
 			cfg := envoy.NewConfig(
 				func(c *envoy.Config) {
 					c.XDSAddress = controlplaneAddress
 					c.Mode = envoy.ParseMode(mode)
 					c.ALSAddresss = accessLogServerAddress
+					c.DrainDuration = ptypes.DurationProto(drainTime)
 				},
 			)
 
@@ -79,15 +136,24 @@ getenvoy run postgres:nightly --templateArg endpoints=127.0.0.1:5432,192.168.0.1
 				func(r *envoy.Runtime) {
 					r.Config = cfg
 					r.IO = cmdutil.StreamsOf(cmd)
+					r.Download = downloadOpts
+					r.Restart = restartOpts
+					r.HotRestart = hotRestartOpts
+					r.KillTimeout = killTimeout
 				}).
 				AndAll(debug.EnableAll()).
-				And(controlplaneFunc())...,
+				And(controlplaneFunc(), envoy.HandleSignals, envoy.RecordInstance(instanceOpts))...,
 			)
 			if err != nil {
 				return err
 			}
 
-			key, manifestErr := manifest.NewKey(args[0])
+			// the reference is optional, e.g. `getenvoy run -- --config-path ./bootstrap.yaml`
+			reference, envoyArgs := "", args
+			if len(args) > 0 && cmd.ArgsLenAtDash() != 0 {
+				reference, envoyArgs = args[0], args[1:]
+			}
+			key, manifestErr := manifest.NewKey(reference)
 
 			// Check if the templateArgs were passed to the cmd line.
 			// If they were passed, config must be created based on
@@ -97,10 +163,10 @@ getenvoy run postgres:nightly --templateArg endpoints=127.0.0.1:5432,192.168.0.1
 				if err != nil {
 					return err
 				}
-				args = append(args, cmdArg)
+				envoyArgs = append(envoyArgs, cmdArg)
 			}
 
-			return runtime.FetchAndRun(args[0], args[1:])
+			return runtime.FetchAndRun(reference, envoyArgs)
 		},
 	}
 	cmd.Flags().StringVarP(&bootstrap, "bootstrap", "b", "",
@@ -113,9 +179,123 @@ getenvoy run postgres:nightly --templateArg endpoints=127.0.0.1:5432,192.168.0.1
 		fmt.Sprintf("(experimental) mode to run Envoy in <%v> (requires bootstrap flag)", strings.Join(envoy.SupportedModes, "|")))
 	cmd.Flags().StringToStringVar(&templateArgs, "templateArg", map[string]string{},
 		"arguments passed to a config template for substitution")
+	cmd.Flags().Var(&restartOpts.Policy, "restart",
+		"whether to restart Envoy once it exits <no|on-failure> (debug data of every crash is kept next to the debug store)")
+	cmd.Flags().IntVar(&restartOpts.MaxRestarts, "max-restarts", restartOpts.MaxRestarts,
+		"maximum number of consecutive failures Envoy is restarted after with --restart=on-failure, which start over once Envoy has run for a minute (0 means no limit)")
+	cmd.Flags().BoolVar(&hotRestartOpts.Enabled, "hot-restart", hotRestartOpts.Enabled,
+		"run Envoy so that SIGHUP or getenvoy reload hot restarts it with the current configuration")
+	cmd.Flags().IntVar(&hotRestartOpts.BaseID, "base-id", hotRestartOpts.BaseID,
+		"base ID of the shared memory of Envoy, which must be unique for every Envoy on the host (requires hot-restart flag, otherwise a free one is picked)")
+	cmd.Flags().DurationVar(&drainTime, "drain-time", drainTime,
+		"how long Envoy drains its connections once GetEnvoy is terminating before it is signaled (0 signals it right away)")
+	cmd.Flags().DurationVar(&killTimeout, "kill-timeout", killTimeout,
+		"how long Envoy is given to terminate once signaled before it is killed (0 means it is never killed)")
+	cmd.Flags().StringVar(&instanceOpts.Name, "name", instanceOpts.Name,
+		"name of the Envoy instance, which must be unique among running ones (defaults to the first free one of envoy-1, envoy-2, etc)")
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false,
+		"run Envoy in the background and print the ID to manage it with getenvoy ps, logs and stop")
+	cmd.Flags().DurationVar(&detachTimeout, "detach-timeout", defaultDetachTimeout,
+		"how long Envoy is given to start in the background, including fetching it, before GetEnvoy gives up on it (0 means no limit)")
+	addDownloadFlags(cmd, &downloadOpts)
 	return cmd
 }
 
+// runDetached runs GetEnvoy again in the background with the same flags and args, and returns once it has started Envoy
+func runDetached(cmd *cobra.Command, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to run GetEnvoy in the background: %v", err)
+	}
+	id := envoy.NewInstanceID()
+	output := envoy.InstanceOutputPath(id)
+	if err := os.MkdirAll(filepath.Dir(output), 0750); err != nil {
+		return fmt.Errorf("unable to run GetEnvoy in the background: %v", err)
+	}
+	out, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to run GetEnvoy in the background: %v", err)
+	}
+	defer out.Close() //nolint
+
+	// #nosec -> GetEnvoy runs itself
+	child := exec.Command(exe, detachedArgs(cmd, args)...)
+	child.Env = append(os.Environ(), instanceIDEnv+"="+id, "GETENVOY_HOME="+common.HomeDir)
+	child.Stdout = out
+	child.Stderr = out
+	// a session of its own keeps it running once the terminal is closed
+	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("unable to run GetEnvoy in the background: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- child.Wait()
+	}()
+	failed := func(reason string) error {
+		logged, _ := ioutil.ReadFile(output)
+		os.Remove(output) //nolint
+		return fmt.Errorf("unable to start Envoy in the background, %v:\n%s", reason, bytes.TrimSpace(logged))
+	}
+
+	var timeout <-chan time.Time
+	if detachTimeout > 0 {
+		timeout = time.After(detachTimeout)
+	}
+	ticker := time.NewTicker(detachInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return failed(fmt.Sprintf("GetEnvoy exited with %v", err))
+			}
+			return failed("GetEnvoy exited")
+		case <-timeout:
+			// GetEnvoy is stuck, e.g. fetching Envoy, and would otherwise be left behind in the background
+			child.Process.Kill() //nolint
+			<-exited
+			return failed(fmt.Sprintf("it didn't start within %v", detachTimeout))
+		case <-ticker.C:
+			// the instance is recorded before Envoy is started, and again once it has
+			if instance, err := envoy.FindInstance(id); err == nil && instance.EnvoyPID != 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), id)
+				fmt.Fprintf(cmd.ErrOrStderr(), "started Envoy instance %v\n", instance)
+				return nil
+			}
+		}
+	}
+}
+
+// detachedArgs returns the args GetEnvoy runs itself with in the background, i.e. the subcommand, the flags
+// that were set and the args of cmd, which aren't necessarily the ones of the process, e.g. if cmd is embedded.
+func detachedArgs(cmd *cobra.Command, args []string) []string {
+	detached := strings.Fields(cmd.CommandPath())[1:]
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if f.Name == "detach" {
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range values.GetSlice() {
+				detached = append(detached, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		value := f.Value.String()
+		if f.Value.Type() == "stringToString" {
+			// the value is printed as `[k1=v1,k2=v2]` but parsed without the brackets
+			value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		}
+		detached = append(detached, "--"+f.Name+"="+value)
+	})
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		detached = append(detached, args[:dash]...)
+		detached = append(detached, "--")
+		return append(detached, args[dash:]...)
+	}
+	return append(detached, args...)
+}
+
 var (
 	istio = "istio"
 
@@ -152,9 +332,12 @@ func validateRequiresBootstrap() error {
 	return nil
 }
 
-func validateCmdArgs(args []string) error {
-	if len(args) == 0 {
-		return errors.New("missing binary parameter")
+func validateCmdArgs() error {
+	if restartOpts.MaxRestarts < 0 {
+		return errors.New("--max-restarts must not be negative")
+	}
+	if hotRestartOpts.BaseID != 0 && !hotRestartOpts.Enabled {
+		return errors.New("--base-id requires --hot-restart to be set")
 	}
 	if err := validateMode(); err != nil {
 		return err
@@ -0,0 +1,82 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/binary/envoy"
+)
+
+// stopInterval is how often stop checks whether GetEnvoy has stopped Envoy
+var stopInterval = 100 * time.Millisecond
+
+// NewStopCmd returns a command that stops Envoy instances run by other GetEnvoy processes
+func NewStopCmd() *cobra.Command {
+	timeout := time.Minute
+	cmd := &cobra.Command{
+		Use:   "stop <name|id>...",
+		Short: "Stop running Envoy instances.",
+		Long: `
+Stops Envoy instances listed by ` + "`getenvoy ps`" + ` the way GetEnvoy does on SIGINT: pre-termination functions are run,
+Envoy drains its connections and is killed only if it doesn't terminate in time.
+An instance can be referred to by its name, its ID or a prefix of its ID as long as it is unambiguous.`,
+		Example: `
+  # Stop Envoy run in the background.
+  getenvoy run standard:1.17.0 --detach -- --config-path ./bootstrap.yaml
+  getenvoy stop 5c0a7e`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("missing instance name or ID parameter")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				instance, err := envoy.FindInstance(id)
+				if err != nil {
+					return err
+				}
+				if err := instance.Stop(); err != nil {
+					return err
+				}
+				if !awaitStopped(instance, timeout) {
+					return fmt.Errorf("envoy instance %v (PID=%d) hasn't stopped within %v", instance.ID, instance.PID, timeout)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), instance.ID)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", timeout,
+		"how long to wait for an instance to stop, which includes draining Envoy")
+	return cmd
+}
+
+// awaitStopped waits until the GetEnvoy process running the instance has exited or the timeout expires
+func awaitStopped(instance *envoy.Instance, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for instance.Running() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(stopInterval)
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
+)
+
+// NewUseCmd returns a command that pins the Envoy reference of a project
+func NewUseCmd() *cobra.Command {
+	global := false
+	cmd := &cobra.Command{
+		Use:   "use [<reference>]",
+		Short: "Pin the Envoy reference to run in the current directory.",
+		Long: `
+Pin the Envoy reference to run in the current directory and its subdirectories by writing it into
+a ` + "`" + manifest.VersionFile + "`" + ` file, or set the default one with --global.
+Commands that take a reference, e.g. ` + "`getenvoy run`" + `, use the pinned one when it is omitted,
+and so do extension workspaces that don't specify an Envoy version.
+Without a reference, print the one in effect and where it comes from.`,
+		Example: `
+  # Pin a version for a project.
+  getenvoy use standard:1.17.0
+
+  # Set the default version to the newest 1.17 release.
+  getenvoy use standard:1.17.x --global
+
+  # Print the version in effect.
+  getenvoy use`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("expected at most one reference parameter")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				reference, source, err := manifest.Pinned(cwd)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%v (set by %v)\n", reference, source)
+				return nil
+			}
+			path := filepath.Join(cwd, manifest.VersionFile)
+			if global {
+				path = manifest.DefaultVersionFile()
+			}
+			if err := manifest.Pin(path, args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "pinned %v in %v\n", args[0], path)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&global, "global", global, "set the default reference for directories without a "+manifest.VersionFile+" file")
+	return cmd
+}
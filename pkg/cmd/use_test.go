@@ -0,0 +1,94 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/tetratelabs/getenvoy/pkg/cmd"
+
+	cmdutil "github.com/tetratelabs/getenvoy/pkg/util/cmd"
+)
+
+var _ = Describe("getenvoy use", func() {
+
+	var tmpDir, cwd string
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		tmpDir, err = filepath.EvalSymlinks(dir)
+		Expect(err).NotTo(HaveOccurred())
+		cwd, err = os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.Chdir(cwd)).To(Succeed())
+		if tmpDir != "" {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		}
+	})
+
+	execute := func(args ...string) (string, error) {
+		stdout := new(bytes.Buffer)
+		c := NewRoot()
+		c.SetOut(stdout)
+		c.SetErr(new(bytes.Buffer))
+		c.SetArgs(append([]string{"--home-dir", filepath.Join(tmpDir, "home")}, args...))
+		err := cmdutil.Execute(c)
+		return stdout.String(), err
+	}
+
+	It("should pin a reference for a directory and its subdirectories", func() {
+		project := filepath.Join(tmpDir, "project")
+		Expect(os.MkdirAll(filepath.Join(project, "sub"), 0750)).To(Succeed())
+		Expect(os.Chdir(project)).To(Succeed())
+
+		stdout, err := execute("use", "standard:1.17.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("pinned standard:1.17.0 in " + filepath.Join(project, ".envoy-version") + "\n"))
+
+		Expect(os.Chdir(filepath.Join(project, "sub"))).To(Succeed())
+		stdout, err = execute("use")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("standard:1.17.0 (set by " + filepath.Join(project, ".envoy-version") + ")\n"))
+	})
+
+	It("should fall back to the default reference", func() {
+		Expect(os.Chdir(tmpDir)).To(Succeed())
+
+		_, err := execute("use")
+		Expect(err).To(HaveOccurred())
+
+		_, err = execute("use", "--global", "standard:1.17.x")
+		Expect(err).NotTo(HaveOccurred())
+		stdout, err := execute("use")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout).To(Equal("standard:1.17.x (set by " + filepath.Join(tmpDir, "home", "envoy-version") + ")\n"))
+	})
+
+	It("should reject invalid references", func() {
+		Expect(os.Chdir(tmpDir)).To(Succeed())
+		_, err := execute("use", "standard")
+		Expect(err).To(MatchError(`"standard" is not a valid GetEnvoy reference. Expected format: <flavor>:<version>[/<platform>]`))
+	})
+})
@@ -15,14 +15,10 @@
 package wasmimage
 
 import (
-	"context"
-	"crypto/tls"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 
 	"github.com/containerd/containerd/remotes"
-	"github.com/deislabs/oras/pkg/auth/docker"
 	orascnt "github.com/deislabs/oras/pkg/content"
 	orasctx "github.com/deislabs/oras/pkg/context"
 	"github.com/deislabs/oras/pkg/oras"
@@ -36,21 +32,7 @@ type Puller struct {
 
 // NewPuller returns a new Puller instance.
 func NewPuller(insecure, useHTTP bool) (*Puller, error) {
-	client := http.DefaultClient
-
-	client.Transport = &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// nolint:gosec this option is only enabled when the user specify the insecure flag.
-			InsecureSkipVerify: insecure,
-		},
-	}
-
-	// TODO(musaprg): separate these instructions into another functions
-	auth, err := docker.NewClient()
-	if err != nil {
-		return nil, err
-	}
-	resolver, err := auth.Resolver(context.Background(), client, useHTTP)
+	resolver, err := NewResolver(insecure, useHTTP)
 	if err != nil {
 		return nil, err
 	}
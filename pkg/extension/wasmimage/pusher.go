@@ -15,13 +15,9 @@
 package wasmimage
 
 import (
-	"context"
-	"crypto/tls"
 	"fmt"
-	"net/http"
 
 	"github.com/containerd/containerd/remotes"
-	"github.com/deislabs/oras/pkg/auth/docker"
 	orasctx "github.com/deislabs/oras/pkg/context"
 	"github.com/deislabs/oras/pkg/oras"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -34,23 +30,7 @@ type Pusher struct {
 
 // NewPusher returns a new Pusher instance.
 func NewPusher(insecure, useHTTP bool) (*Pusher, error) {
-	client := http.DefaultClient
-
-	if insecure {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				// nolint:gosec this option is only enabled when the user specify the insecure flag.
-				InsecureSkipVerify: true,
-			},
-		}
-	}
-
-	// TODO(musaprg): separate these instructions into another functions
-	auth, err := docker.NewClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Pusher: %w", err)
-	}
-	resolver, err := auth.Resolver(context.Background(), client, useHTTP)
+	resolver, err := NewResolver(insecure, useHTTP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Pusher: %w", err)
 	}
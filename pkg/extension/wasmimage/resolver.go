@@ -0,0 +1,35 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasmimage
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/deislabs/oras/pkg/auth/docker"
+
+	"github.com/tetratelabs/getenvoy/pkg/transport"
+)
+
+// NewResolver returns a resolver of OCI-compliant registries that uses Docker credentials
+// and the default HTTP transport settings.
+func NewResolver(insecure, useHTTP bool) (remotes.Resolver, error) {
+	client := transport.NewClient(append(transport.DefaultOptions(), transport.WithInsecureSkipVerify(insecure))...)
+	auth, err := docker.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return auth.Resolver(context.Background(), client, useHTTP)
+}
@@ -87,7 +87,7 @@ category: invalid
 				},
 				Entry("empty", testCase{
 					input:       ``,
-					expectedErr: `4 errors occurred: extension name cannot be empty; extension category cannot be empty; programming language cannot be empty; runtime description is not valid: Envoy version cannot be empty`,
+					expectedErr: `3 errors occurred: extension name cannot be empty; extension category cannot be empty; programming language cannot be empty`,
 				}),
 				Entry("invalid Envoy version", testCase{
 					input: `#
@@ -176,10 +176,9 @@ func (d *Descriptor) Validate() (errs error) {
 }
 
 // Validate returns an error if Runtime is not valid.
+//
+// Envoy version is optional, in which case the one pinned by `getenvoy use` is used.
 func (r *Runtime) Validate() (errs error) {
-	if r.Envoy.Version == "" {
-		errs = multierror.Append(errs, errors.New("Envoy version cannot be empty"))
-	}
 	if r.Envoy.Version != "" {
 		if _, err := types.ParseReference(r.Envoy.Version); err != nil {
 			errs = multierror.Append(errs, errors.Wrap(err, "Envoy version is not valid"))
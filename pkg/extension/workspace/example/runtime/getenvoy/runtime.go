@@ -55,7 +55,8 @@ func (r *runtime) Run(ctx *types.RunContext) (errs error) {
 			r.Config.AdminAddress = address.GetAddress()
 			r.Config.AdminPort = int32(address.GetPortValue())
 		}).
-		AndAll(debug.EnableAll())...,
+		AndAll(debug.EnableAll()).
+		And(envoy.HandleSignals)...,
 	)
 	if err != nil {
 		return err
@@ -16,6 +16,7 @@ package runtime
 
 import (
 	"github.com/tetratelabs/getenvoy/pkg/extension/workspace/model"
+	"github.com/tetratelabs/getenvoy/pkg/manifest"
 )
 
 // GetEnvoyReference returns either a path to a custom Envoy binary or
@@ -44,7 +45,14 @@ func (o *RunOpts) GetEnvoyVersion() string {
 		return version
 	}
 	// Envoy version from Extension descriptor
-	return o.Workspace.GetExtensionDescriptor().Runtime.Envoy.Version
+	if version := o.Workspace.GetExtensionDescriptor().Runtime.Envoy.Version; version != "" {
+		return version
+	}
+	// Envoy version pinned by `getenvoy use` for the workspace
+	if version, _, err := manifest.Pinned(o.Workspace.GetDir().GetRootDir()); err == nil {
+		return version
+	}
+	return ""
 }
 
 // GetExtensionConfig returns effective extension config.
@@ -0,0 +1,135 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+// Manifest represents the GetEnvoy manifest along with integrity metadata of its builds.
+//
+// Integrity metadata is not part of the getenvoy-package API, that is why it is
+// decoded separately and kept alongside the api.Manifest.
+type Manifest struct {
+	*api.Manifest
+
+	// Digests holds integrity metadata of build archives keyed by their download location.
+	Digests map[string]*Digest
+
+	// Mirrors holds alternative download locations of build archives keyed by
+	// their preferred download location.
+	Mirrors map[string][]string
+}
+
+// Digest represents integrity metadata of a build archive.
+type Digest struct {
+	// SHA256 is a hex-encoded SHA-256 checksum of the archive.
+	SHA256 string `json:"sha256,omitempty"`
+	// Signature is a base64-encoded Ed25519 signature of the raw SHA-256 checksum.
+	Signature string `json:"signature,omitempty"`
+}
+
+// Build represents a GetEnvoy build that can be downloaded and verified.
+type Build struct {
+	// DownloadLocationURL is the location of the build archive.
+	DownloadLocationURL string
+	// Mirrors are alternative locations of the build archive to fall back to in the order of preference.
+	Mirrors []string
+	// MirrorDigests holds integrity metadata of the mirrors that have their own, keyed by their location.
+	// Other mirrors are expected to serve the very same archive as DownloadLocationURL.
+	MirrorDigests map[string]Digest
+
+	Digest
+}
+
+// NewBuild returns a Build that corresponds to a given manifest entry.
+func (m *Manifest) NewBuild(build *api.Build) *Build {
+	result := &Build{DownloadLocationURL: build.GetDownloadLocationUrl()}
+	result.Mirrors = m.Mirrors[result.DownloadLocationURL]
+	for _, location := range result.Mirrors {
+		if digest := m.Digests[location]; digest != nil {
+			if result.MirrorDigests == nil {
+				result.MirrorDigests = make(map[string]Digest)
+			}
+			result.MirrorDigests[location] = *digest
+		}
+	}
+	// without integrity metadata of its own, the archive is expected to be the one of the first mirror that has it
+	for _, location := range result.Locations() {
+		if digest := m.Digests[location]; digest != nil {
+			result.Digest = *digest
+			break
+		}
+	}
+	return result
+}
+
+// Locations returns all locations of the build archive in the order of preference.
+func (b *Build) Locations() []string {
+	return append([]string{b.DownloadLocationURL}, b.Mirrors...)
+}
+
+// At returns the build as served by a given one of its locations, along with the integrity
+// metadata that location has to be verified against.
+func (b *Build) At(location string) *Build {
+	result := &Build{DownloadLocationURL: location, Digest: b.Digest}
+	if digest, ok := b.MirrorDigests[location]; ok {
+		result.Digest = digest
+	}
+	return result
+}
+
+// rawManifest mirrors the layout of the manifest JSON to extract fields
+// that are unknown to the getenvoy-package API.
+type rawManifest struct {
+	Flavors map[string]struct {
+		Versions map[string]struct {
+			Builds map[string]struct {
+				DownloadLocationURL string `json:"downloadLocationUrl"`
+				Digest
+			} `json:"builds"`
+		} `json:"versions"`
+	} `json:"flavors"`
+}
+
+func decode(data []byte) (*Manifest, error) {
+	result := api.Manifest{}
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(data), &result); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest: %v", err)
+	}
+	raw := rawManifest{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest: %v", err)
+	}
+	digests := make(map[string]*Digest)
+	for _, flavor := range raw.Flavors {
+		for _, version := range flavor.Versions {
+			for _, build := range version.Builds {
+				if build.SHA256 == "" && build.Signature == "" {
+					continue
+				}
+				digest := build.Digest
+				digests[build.DownloadLocationURL] = &digest
+			}
+		}
+	}
+	return &Manifest{Manifest: &result, Digests: digests}, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+func TestManifest_NewBuild(t *testing.T) {
+	m := &Manifest{
+		Digests: map[string]*Digest{
+			"https://mirror-a.example/envoy.tar.xz": {SHA256: "aaaa"},
+			"https://mirror-b.example/envoy.tar.xz": {SHA256: "bbbb"},
+		},
+		Mirrors: map[string][]string{
+			"https://example.org/envoy.tar.xz": {"https://mirror-a.example/envoy.tar.xz", "https://mirror-b.example/envoy.tar.xz"},
+		},
+	}
+
+	build := m.NewBuild(&api.Build{DownloadLocationUrl: "https://example.org/envoy.tar.xz"})
+	assert.Equal(t, "aaaa", build.SHA256, "expected the first checksum to stand in for the download location")
+	assert.Equal(t, &Build{DownloadLocationURL: "https://example.org/envoy.tar.xz", Digest: Digest{SHA256: "aaaa"}},
+		build.At("https://example.org/envoy.tar.xz"))
+	assert.Equal(t, &Build{DownloadLocationURL: "https://mirror-b.example/envoy.tar.xz", Digest: Digest{SHA256: "bbbb"}},
+		build.At("https://mirror-b.example/envoy.tar.xz"), "expected a mirror to be verified against its own checksum")
+
+	m.Digests["https://example.org/envoy.tar.xz"] = &Digest{SHA256: "0000"}
+	build = m.NewBuild(&api.Build{DownloadLocationUrl: "https://example.org/envoy.tar.xz"})
+	assert.Equal(t, "0000", build.At("https://example.org/envoy.tar.xz").SHA256)
+	assert.Equal(t, "aaaa", build.At("https://mirror-a.example/envoy.tar.xz").SHA256)
+}
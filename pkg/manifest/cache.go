@@ -0,0 +1,186 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tetratelabs/log"
+
+	"github.com/tetratelabs/getenvoy/pkg/common"
+	"github.com/tetratelabs/getenvoy/pkg/transport"
+)
+
+const (
+	// DefaultTTL is how long a cached manifest is used without checking for a newer one.
+	DefaultTTL = time.Hour
+)
+
+var (
+	offline = false
+	ttl     = DefaultTTL
+
+	errNotModified = errors.New("not modified")
+)
+
+// SetOffline turns offline mode on or off.
+//
+// In offline mode the manifest is never retrieved over the network; only the
+// copy cached by a previous invocation is used.
+func SetOffline(value bool) {
+	offline = value
+}
+
+// IsOffline returns true if offline mode is on.
+func IsOffline() bool {
+	return offline
+}
+
+// SetTTL sets how long a cached manifest is used without checking for a newer one.
+func SetTTL(value time.Duration) {
+	ttl = value
+}
+
+// cacheMeta represents metadata of a cached manifest.
+type cacheMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// load returns the manifest at a given URL, preferring a cached copy whenever it is fresh enough.
+func load(url string) (*Manifest, error) {
+	cached, meta := readCache(url)
+	if offline {
+		if cached == nil {
+			return nil, fmt.Errorf("there is no cached copy of the manifest %v to use in offline mode", url)
+		}
+		return cached, nil
+	}
+	if cached != nil && time.Since(meta.FetchedAt) < ttl {
+		log.Debugf("using manifest %v cached at %v", url, meta.FetchedAt)
+		return cached, nil
+	}
+	data, fresh, err := get(url, meta)
+	switch {
+	case err == errNotModified:
+		fresh = meta
+		fresh.FetchedAt = time.Now()
+		writeCache(url, nil, fresh)
+		return cached, nil
+	case err != nil && cached != nil:
+		log.Warnf("unable to refresh manifest %v, falling back to a copy cached at %v: %v", url, meta.FetchedAt, err)
+		return cached, nil
+	case err != nil:
+		return nil, err
+	}
+	manifest, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	writeCache(url, data, fresh)
+	return manifest, nil
+}
+
+// get retrieves the manifest at a given URL, revalidating the cached copy described by meta (if any).
+func get(url string, meta *cacheMeta) ([]byte, *cacheMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+	// #nosec => This is by design, users can call out to wherever they like!
+	resp, err := transport.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close() //nolint
+	if meta != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, nil, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("received %v response code from %v", resp.StatusCode, url)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	return data, &cacheMeta{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}, nil
+}
+
+// cacheFile returns the location of the cached copy of the manifest at a given URL.
+func cacheFile(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(common.HomeDir, "manifests", hex.EncodeToString(sum[:8])+".json")
+}
+
+func readCache(url string) (*Manifest, *cacheMeta) {
+	path := cacheFile(url)
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, nil
+	}
+	manifest, err := decode(data)
+	if err != nil {
+		log.Warnf("ignoring malformed manifest cached at %v: %v", path, err)
+		return nil, nil
+	}
+	meta := &cacheMeta{}
+	if raw, err := ioutil.ReadFile(filepath.Clean(path + ".meta")); err == nil {
+		json.Unmarshal(raw, meta) //nolint
+	}
+	return manifest, meta
+}
+
+// writeCache persists the manifest at a given URL; nil data only updates its metadata.
+func writeCache(url string, data []byte, meta *cacheMeta) {
+	path := cacheFile(url)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		log.Debugf("unable to cache manifest %v: %v", url, err)
+		return
+	}
+	if data != nil {
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			log.Debugf("unable to cache manifest %v: %v", url, err)
+			return
+		}
+	}
+	raw, _ := json.Marshal(meta)
+	if err := ioutil.WriteFile(path+".meta", raw, 0600); err != nil {
+		log.Debugf("unable to cache manifest %v: %v", url, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/getenvoy/pkg/common"
+)
+
+func TestLoad(t *testing.T) {
+	defer useTempHomeDir(t)()
+	defer SetTTL(DefaultTTL)
+	defer SetOffline(false)
+
+	requests := 0
+	status := http.StatusOK
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` && status == http.StatusOK {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			b, _ := ioutil.ReadFile(filepath.Join("testdata", "manifest.golden"))
+			w.Write(b)
+		}
+	}))
+	defer mock.Close()
+
+	SetOffline(true)
+	_, err := load(mock.URL)
+	assert.Error(t, err, "expected offline mode to fail without a cached manifest")
+	assert.Equal(t, 0, requests)
+
+	SetOffline(false)
+	got, err := load(mock.URL)
+	require.NoError(t, err)
+	assert.Equal(t, goodManifest(), got.Manifest)
+	assert.Equal(t, 1, requests)
+
+	_, err = load(mock.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests, "expected the cached manifest to be used within its TTL")
+
+	SetTTL(0)
+	got, err = load(mock.URL)
+	require.NoError(t, err)
+	assert.Equal(t, goodManifest(), got.Manifest)
+	assert.Equal(t, 2, requests, "expected the cached manifest to be revalidated once expired")
+
+	status = http.StatusInternalServerError
+	got, err = load(mock.URL)
+	require.NoError(t, err, "expected the cached manifest to be used when the server is unavailable")
+	assert.Equal(t, goodManifest(), got.Manifest)
+	assert.Equal(t, 3, requests)
+
+	SetOffline(true)
+	got, err = load(mock.URL)
+	require.NoError(t, err)
+	assert.Equal(t, goodManifest(), got.Manifest)
+	assert.Equal(t, 3, requests, "expected no requests in offline mode")
+}
+
+// useTempHomeDir points common.HomeDir at a temporary directory and returns a function restoring it.
+func useTempHomeDir(t *testing.T) func() {
+	tmpDir, err := ioutil.TempDir("", "getenvoy-test-")
+	require.NoError(t, err)
+	original := common.HomeDir
+	common.HomeDir = tmpDir
+	return func() {
+		common.HomeDir = original
+		os.RemoveAll(tmpDir)
+	}
+}
@@ -16,15 +16,13 @@ package manifest
 
 import (
 	"fmt"
-	"net/url"
 	"os"
 	"strings"
 
 	"github.com/pkg/errors"
-
 	"github.com/tetratelabs/getenvoy-package/api"
+
 	"github.com/tetratelabs/getenvoy/pkg/types"
-	"github.com/tetratelabs/log"
 )
 
 const (
@@ -32,34 +30,49 @@ const (
 )
 
 var (
-	// manifestURL defines location of the GetEnvoy manifest.
-	manifestURL = &url.URL{
-		Scheme: "https",
-		Host:   "tetrate.bintray.com",
-		Path:   "/getenvoy/manifest.json",
-	}
+	// manifestURLs defines locations of GetEnvoy manifests in the order of preference.
+	manifestURLs = []string{"https://tetrate.bintray.com/getenvoy/manifest.json"}
 )
 
-// GetURL returns location of the GetEnvoy manifest.
+// GetURL returns location of the preferred GetEnvoy manifest.
 func GetURL() string {
-	return manifestURL.String()
+	return manifestURLs[0]
 }
 
 // SetURL sets location of the GetEnvoy manifest.
 func SetURL(rawurl string) error {
-	otherURL, err := url.Parse(rawurl)
-	if err != nil || otherURL.Host == "" || otherURL.Scheme == "" {
-		return errors.Errorf("%q is not a valid manifest URL", rawurl)
+	return SetURLs(rawurl)
+}
+
+// GetURLs returns locations of GetEnvoy manifests in the order of preference.
+func GetURLs() []string {
+	return append([]string(nil), manifestURLs...)
+}
+
+// SetURLs sets locations of GetEnvoy manifests in the order of preference.
+//
+// Each location is either a URL, e.g. `https://example.org/manifest.json` or
+// `file:///mnt/getenvoy/manifest.json`, or a path to a manifest file or to a
+// directory with a `manifest.json` file in it.
+func SetURLs(rawurls ...string) error {
+	if len(rawurls) == 0 {
+		return errors.New("at least one manifest URL is required")
 	}
-	manifestURL = otherURL
+	for _, rawurl := range rawurls {
+		if _, err := parseLocation(rawurl); err != nil {
+			return err
+		}
+	}
+	manifestURLs = append([]string(nil), rawurls...)
 	return nil
 }
 
 // NewKey creates a manifest key based on the reference it is given
+// An empty reference stands for the one pinned by the nearest `.envoy-version` file or the user-level default
 func NewKey(reference string) (*Key, error) {
-	// This enables us to parameterize Docker images
-	if reference == "@" {
-		reference = os.Getenv(referenceEnv)
+	reference, err := expandReference(reference)
+	if err != nil {
+		return nil, err
 	}
 	ref, err := types.ParseReference(reference)
 	if err != nil {
@@ -73,6 +86,45 @@ func NewKey(reference string) (*Key, error) {
 	return &key, nil
 }
 
+// NewKeys creates a manifest key per passed platform based on the reference it is given
+// A reference that has a platform of its own, or an empty list of platforms, yields a single key just like NewKey
+func NewKeys(reference string, platforms ...string) ([]*Key, error) {
+	reference, err := expandReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewKey(reference)
+	if err != nil {
+		return nil, err
+	}
+	if ref, _ := types.ParseReference(reference); len(platforms) == 0 || ref.Platform != "" {
+		return []*Key{key}, nil
+	}
+	keys := make([]*Key, 0, len(platforms))
+	for _, p := range platforms {
+		platform := platformToEnum(p)
+		if _, ok := api.Build_Platform_value[platform]; !ok {
+			return nil, errors.Errorf("%q is not a supported platform", p)
+		}
+		keys = append(keys, &Key{Flavor: key.Flavor, Version: key.Version, Platform: platform})
+	}
+	return keys, nil
+}
+
+// expandReference replaces `@` with the reference in the ENVOY_REFERENCE environment variable
+// and an empty reference with the pinned one
+func expandReference(reference string) (string, error) {
+	switch reference {
+	case "@":
+		// This enables us to parameterize Docker images
+		return os.Getenv(referenceEnv), nil
+	case "":
+		return pinnedReference()
+	default:
+		return reference, nil
+	}
+}
+
 func platformToEnum(s string) string {
 	s = strings.ToUpper(s)
 	s = strings.ReplaceAll(s, "-", "_")
@@ -86,30 +138,36 @@ func (k Key) String() string {
 	return fmt.Sprintf("%v:%v/%v", k.Flavor, k.Version, platformFromEnum(k.Platform))
 }
 
-// Locate returns the location of the binary for the passed parameters from the passed manifest
+// Locate returns the build for the passed parameters from the GetEnvoy manifest
 // The build version is searched for as a prefix of the OperatingSystemVersion.
 // If the OperatingSystemVersion is empty it returns the first build listed for that operating system
-func Locate(key *Key) (string, error) {
+func Locate(key *Key) (*Build, error) {
 	if key == nil {
-		return "", errors.New("passed key was nil")
+		return nil, errors.New("passed key was nil")
 	}
-	log.Debugf("retrieving manifest %s", GetURL())
-	manifest, err := fetch(GetURL())
+	manifest, err := loadAll()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return LocateBuild(key, manifest)
 }
 
-// LocateBuild returns the downloadLocationURL of the associated envoy binary in the manifest using the input key
-func LocateBuild(key *Key, manifest *api.Manifest) (string, error) {
+// LocateBuild returns the associated envoy build in the manifest using the input key
+// A version range or alias in the key is resolved to the newest matching version first
+func LocateBuild(key *Key, manifest *Manifest) (*Build, error) {
+	key, err := ResolveKey(key, manifest)
+	if err != nil {
+		return nil, err
+	}
 	// This is pretty horrible... Not sure there is a nicer way though.
 	if manifest.Flavors[key.Flavor] != nil && manifest.Flavors[key.Flavor].Versions[key.Version] != nil {
-		for _, build := range manifest.Flavors[key.Flavor].Versions[key.Version].Builds {
+		version := manifest.Flavors[key.Flavor].Versions[key.Version]
+		for _, build := range version.Builds {
 			if strings.EqualFold(build.Platform.String(), key.Platform) {
-				return build.DownloadLocationUrl, nil
+				return manifest.NewBuild(build), nil
 			}
 		}
+		return nil, noBuildError("unable to find matching GetEnvoy build for reference", key, version)
 	}
-	return "", fmt.Errorf("unable to find matching GetEnvoy build for reference %q", key)
+	return nil, noBuildError("unable to find matching GetEnvoy build for reference", key)
 }
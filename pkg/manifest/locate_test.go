@@ -32,6 +32,7 @@ func TestLocate(t *testing.T) {
 		responseStatusCode int
 		want               string
 		wantErr            bool
+		wantErrContains    string
 	}{
 		{
 			name:               "standard 1.11.0 linux-glibc matches",
@@ -70,6 +71,13 @@ func TestLocate(t *testing.T) {
 			responseStatusCode: http.StatusOK,
 			wantErr:            true,
 		},
+		{
+			name:               "Error lists available platforms",
+			reference:          "standard-fips1402:1.10.0/linux-musl",
+			responseStatusCode: http.StatusOK,
+			wantErr:            true,
+			wantErrContains:    "there are no builds for linux-musl, available platforms: linux-glibc",
+		},
 		{
 			name:      "Error if passed nil key",
 			reference: "notAReference",
@@ -85,6 +93,7 @@ func TestLocate(t *testing.T) {
 	for _, tt := range tests {
 		tc := tt
 		t.Run(tc.name, func(t *testing.T) {
+			defer useTempHomeDir(t)()
 			mock := mockServer(tc.responseStatusCode, "manifest.golden")
 			defer mock.Close()
 			location := mock.URL
@@ -104,10 +113,13 @@ func TestLocate(t *testing.T) {
 			key, _ := NewKey(tc.reference)
 			if got, err := Locate(key); tc.wantErr {
 				assert.Error(t, err)
-				assert.Equal(t, "", got)
+				assert.Nil(t, got)
+				if tc.wantErrContains != "" {
+					assert.Contains(t, err.Error(), tc.wantErrContains)
+				}
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tc.want, got)
+				assert.Equal(t, tc.want, got.DownloadLocationURL)
 			}
 		})
 	}
@@ -143,3 +155,34 @@ func TestNewKey(t *testing.T) {
 		})
 	}
 }
+
+func TestNewKeys(t *testing.T) {
+	tests := []struct {
+		reference string
+		platforms []string
+		want      []*Key
+		wantErr   bool
+	}{
+		{"flavor:version", nil, []*Key{{Flavor: "flavor", Version: "version", Platform: platformToEnum(platform())}}, false},
+		{"flavor:version", []string{"linux-glibc", "darwin"}, []*Key{
+			{Flavor: "flavor", Version: "version", Platform: "LINUX_GLIBC"},
+			{Flavor: "flavor", Version: "version", Platform: "DARWIN"},
+		}, false},
+		{"flavor:version/darwin", []string{"linux-glibc"}, []*Key{{Flavor: "flavor", Version: "version", Platform: "DARWIN"}}, false},
+		{"flavor:version", []string{"plan9"}, nil, true},
+		{"flavor", []string{"darwin"}, nil, true},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.reference, func(t *testing.T) {
+			got, err := NewKeys(tc.reference, tc.platforms...)
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/tetratelabs/getenvoy/pkg/common"
+	"github.com/tetratelabs/getenvoy/pkg/types"
+)
+
+const (
+	// VersionFile is the name of the file that pins the Envoy reference of a project, e.g. `standard:1.17.0`
+	VersionFile = ".envoy-version"
+	// defaultVersionFile is the name of the file in the GetEnvoy home directory that holds the user-level default
+	defaultVersionFile = "envoy-version"
+)
+
+// Pinned returns the Envoy reference pinned by the nearest VersionFile in the passed directory or its parents,
+// falling back to the user-level default, along with the location of the file it comes from.
+func Pinned(dir string) (reference, source string, err error) {
+	if source, err = findVersionFile(dir); err != nil {
+		return "", "", err
+	}
+	if source == "" {
+		source = DefaultVersionFile()
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			return "", "", errors.Errorf("there is no %s file at or above %s and no default Envoy reference, "+
+				"set one with `getenvoy use <reference>` or pass a reference explicitly", VersionFile, dir)
+		}
+	}
+	reference, err = readVersionFile(source)
+	return reference, source, err
+}
+
+// Pin writes the passed Envoy reference into the passed version file, e.g. a VersionFile or DefaultVersionFile()
+func Pin(path, reference string) error {
+	if _, err := types.ParseReference(reference); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(reference+"\n"), 0600)
+}
+
+// DefaultVersionFile returns the location of the file that holds the user-level default Envoy reference
+func DefaultVersionFile() string {
+	return filepath.Join(common.HomeDir, defaultVersionFile)
+}
+
+// pinnedReference returns the Envoy reference pinned for the current working directory
+func pinnedReference() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to determine current working dir")
+	}
+	reference, _, err := Pinned(cwd)
+	return reference, err
+}
+
+// findVersionFile returns the location of the nearest VersionFile in the passed directory or its parents, if any
+func findVersionFile(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for prev := ""; dir != prev; prev, dir = dir, filepath.Dir(dir) {
+		path := filepath.Join(dir, VersionFile)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// readVersionFile returns the reference in the passed version file, ignoring blank lines and `#` comments
+func readVersionFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("%v doesn't contain an Envoy reference", path)
+}
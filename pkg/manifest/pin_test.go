@@ -0,0 +1,57 @@
+// Copyright 2019 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyUsesPinnedReference(t *testing.T) {
+	defer useTempHomeDir(t)()
+	tmpDir, err := ioutil.TempDir("", "getenvoy-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(cwd) //nolint
+	project := filepath.Join(tmpDir, "project")
+	require.NoError(t, os.MkdirAll(filepath.Join(project, "sub"), 0750))
+	require.NoError(t, os.Chdir(filepath.Join(project, "sub")))
+
+	_, err = NewKey("")
+	assert.Error(t, err, "expected an error without a pinned reference")
+
+	require.NoError(t, Pin(DefaultVersionFile(), "standard:1.16.0"))
+	key, err := NewKey("")
+	require.NoError(t, err)
+	assert.Equal(t, "1.16.0", key.Version, "expected the default reference to be used")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(project, VersionFile), []byte("# pinned for the project\n\nstandard:1.17.0/darwin\n"), 0600))
+	key, err = NewKey("")
+	require.NoError(t, err)
+	assert.Equal(t, &Key{Flavor: "standard", Version: "1.17.0", Platform: "DARWIN"}, key)
+
+	keys, err := NewKeys("", "linux-glibc")
+	require.NoError(t, err)
+	assert.Equal(t, []*Key{key}, keys, "expected the platform of the pinned reference to take precedence")
+
+	assert.Error(t, Pin(filepath.Join(project, VersionFile), "invalid"))
+}
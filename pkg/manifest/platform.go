@@ -0,0 +1,90 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+// linuxPlatform returns the platform of a Linux host with the passed filesystem root and machine hardware name,
+// as reported by `uname -m`, e.g. `linux-glibc`, `linux-musl` or `linux-glibc-arm64`
+//
+// Only `linux-glibc` builds are currently published, so detecting the others accurately makes fetching fail
+// with a helpful message rather than download a binary that cannot run on the host.
+func linuxPlatform(root, machine string) string {
+	platform := "linux-" + libc(root)
+	if arch := normalizeArch(machine); arch != "amd64" {
+		platform += "-" + arch
+	}
+	return platform
+}
+
+// libc returns the C library, `glibc` or `musl`, of the Linux host with the passed filesystem root
+// It is determined by the dynamic linker that the host comes with, e.g. `/lib/ld-musl-x86_64.so.1` on Alpine.
+func libc(root string) string {
+	for _, pattern := range []string{"lib*/ld-linux*.so*", "lib*/*/ld-linux*.so*"} {
+		if matches, _ := filepath.Glob(filepath.Join(root, pattern)); len(matches) > 0 {
+			return "glibc"
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(root, "lib", "ld-musl-*.so*")); len(matches) > 0 {
+		return "musl"
+	}
+	// fall back to the only libc GetEnvoy used to support
+	return "glibc"
+}
+
+// normalizeArch maps the passed machine hardware name to the name of the architecture used by Go, e.g. `x86_64` to `amd64`
+func normalizeArch(machine string) string {
+	switch machine {
+	case "", "x86_64", "amd64":
+		return "amd64"
+	case "aarch64", "arm64":
+		return "arm64"
+	default:
+		return strings.ToLower(machine)
+	}
+}
+
+// availablePlatforms returns the platforms of builds in the passed versions in a deterministic order, e.g. `darwin`
+func availablePlatforms(versions ...*api.Version) []string {
+	set := make(map[string]bool)
+	for _, version := range versions {
+		for _, build := range version.GetBuilds() {
+			set[platformFromEnum(build.Platform.String())] = true
+		}
+	}
+	platforms := make([]string, 0, len(set))
+	for platform := range set {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// noBuildError describes a reference without a matching build, listing the platforms that have one, if any
+func noBuildError(message string, key *Key, versions ...*api.Version) error {
+	platforms := availablePlatforms(versions...)
+	if len(platforms) == 0 {
+		return fmt.Errorf("%s %q", message, key)
+	}
+	return fmt.Errorf("%s %q: there are no builds for %v, available platforms: %v",
+		message, key, platformFromEnum(key.Platform), strings.Join(platforms, ", "))
+}
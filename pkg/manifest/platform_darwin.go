@@ -14,6 +14,8 @@
 
 package manifest
 
+// platform returns the platform of the host
+// Builds for `darwin` run on Apple Silicon as well, by means of Rosetta 2.
 func platform() string {
 	return "darwin"
 }
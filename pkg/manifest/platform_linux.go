@@ -14,6 +14,37 @@
 
 package manifest
 
+import (
+	"sync"
+	"syscall"
+)
+
+var (
+	detectOnce       sync.Once
+	detectedPlatform string
+)
+
+// platform returns the platform of the host, detecting its C library and CPU architecture on first use
 func platform() string {
-	return "linux-glibc"
+	detectOnce.Do(func() {
+		detectedPlatform = linuxPlatform("/", machine())
+	})
+	return detectedPlatform
+}
+
+// machine returns the machine hardware name of the host, as reported by `uname -m`, e.g. `x86_64` or `aarch64`
+// It reflects the host even if GetEnvoy itself is built for another architecture and emulated.
+func machine() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return ""
+	}
+	name := make([]byte, 0, len(uts.Machine))
+	for _, c := range uts.Machine {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return string(name)
 }
@@ -0,0 +1,55 @@
+// Copyright 2019 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinuxPlatform(t *testing.T) {
+	tests := []struct {
+		name    string
+		linkers []string
+		machine string
+		want    string
+	}{
+		{name: "Debian on x86_64", linkers: []string{"lib64/ld-linux-x86-64.so.2"}, machine: "x86_64", want: "linux-glibc"},
+		{name: "Ubuntu on aarch64", linkers: []string{"lib/aarch64-linux-gnu/ld-linux-aarch64.so.1"}, machine: "aarch64", want: "linux-glibc-arm64"},
+		{name: "Alpine on x86_64", linkers: []string{"lib/ld-musl-x86_64.so.1"}, machine: "x86_64", want: "linux-musl"},
+		{name: "Alpine on aarch64", linkers: []string{"lib/ld-musl-aarch64.so.1"}, machine: "aarch64", want: "linux-musl-arm64"},
+		{name: "Alpine with glibc compatibility", linkers: []string{"lib/ld-musl-x86_64.so.1", "lib64/ld-linux-x86-64.so.2"}, machine: "x86_64", want: "linux-glibc"},
+		{name: "Unknown libc", machine: "x86_64", want: "linux-glibc"},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "getenvoy-test-")
+			require.NoError(t, err)
+			defer os.RemoveAll(root)
+			for _, linker := range tc.linkers {
+				path := filepath.Join(root, filepath.FromSlash(linker))
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+				require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+			}
+			assert.Equal(t, tc.want, linuxPlatform(root, tc.machine))
+		})
+	}
+}
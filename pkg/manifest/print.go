@@ -15,36 +15,142 @@
 package manifest
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"sort"
 	"strings"
 	"text/tabwriter"
 
-	"github.com/golang/protobuf/jsonpb"
+	"github.com/ghodss/yaml"
 	"github.com/tetratelabs/getenvoy-package/api"
-	"github.com/tetratelabs/getenvoy/pkg/transport"
 )
 
-// Print retrieves the manifest from the passed location and writes it to the passed writer
-func Print(writer io.Writer) error {
-	manifest, err := fetch(GetURL())
+// Supported formats of Print output
+const (
+	// TableFormat is a human-readable table
+	TableFormat = "table"
+	// JSONFormat is a JSON array of Entry
+	JSONFormat = "json"
+	// YAMLFormat is a YAML list of Entry
+	YAMLFormat = "yaml"
+)
+
+// PrintFormats lists the supported formats of Print output
+var PrintFormats = []string{TableFormat, JSONFormat, YAMLFormat}
+
+// PrintOptions controls which builds Print writes and how
+type PrintOptions struct {
+	// Format is one of PrintFormats, TableFormat if empty
+	Format string
+	// Filter selects the builds to print
+	Filter Filter
+	// Cached reports whether the build with the passed key is present in the local cache
+	Cached func(key *Key) bool
+}
+
+// Filter selects builds by the properties of their flavor and platform. Empty fields match any build.
+type Filter struct {
+	// Flavor matches either the name or the filter profile of a flavor, e.g. `istio` matches `istio-fips1402`
+	Flavor string
+	// Platform matches the platform of a build, e.g. `linux-glibc`
+	Platform string
+	// Compliance matches flavors that meet the compliance requirement, e.g. `fips1402`
+	Compliance string
+}
+
+// Entry describes a build in the output of Print
+type Entry struct {
+	Reference     string   `json:"reference"`
+	Flavor        string   `json:"flavor"`
+	FilterProfile string   `json:"filterProfile,omitempty"`
+	Compliances   []string `json:"compliances,omitempty"`
+	Version       string   `json:"version"`
+	Platform      string   `json:"platform"`
+	Cached        bool     `json:"cached"`
+}
+
+// Print retrieves the manifests from the configured locations and writes their merged builds to the passed writer
+func Print(writer io.Writer, opts PrintOptions) error {
+	manifest, err := loadAll()
 	if err != nil {
 		return err
 	}
-	w := tabwriter.NewWriter(writer, 0, 8, 5, ' ', 0)
-	fmt.Fprintln(w, "REFERENCE\tFLAVOR\tVERSION")
+	entries := manifest.Entries(opts.Filter, opts.Cached)
+	switch opts.Format {
+	case "", TableFormat:
+		w := tabwriter.NewWriter(writer, 0, 8, 5, ' ', 0)
+		fmt.Fprintln(w, "REFERENCE\tFLAVOR\tVERSION\tCACHED")
+		for _, entry := range entries {
+			cached := "no"
+			if entry.Cached {
+				cached = "yes"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", entry.Reference, entry.Flavor, entry.Version, cached)
+		}
+		return w.Flush()
+	case JSONFormat:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case YAMLFormat:
+		data, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unsupported output format %q, expected one of %v", opts.Format, strings.Join(PrintFormats, "|"))
+	}
+}
 
-	for _, flavor := range deterministicFlavors(manifest.Flavors) {
+// Entries returns the builds in the manifest that match the passed filter in a deterministic order
+// The cached function, if any, is used to mark builds present in the local cache
+func (m *Manifest) Entries(filter Filter, cached func(key *Key) bool) []*Entry {
+	entries := make([]*Entry, 0)
+	for _, flavor := range deterministicFlavors(m.GetFlavors()) {
+		if !filter.matchesFlavor(flavor) {
+			continue
+		}
+		compliances := make([]string, 0, len(flavor.Compliances))
+		for _, compliance := range flavor.Compliances {
+			compliances = append(compliances, strings.ToLower(compliance.String()))
+		}
 		for _, version := range deterministicVersions(flavor.Versions) {
 			for _, build := range deterministicBuilds(version.Builds) {
-				ref := fmt.Sprintf("%v:%v/%v", flavor.Name, version.Name, platformFromEnum(build.Platform.String()))
-				fmt.Fprintf(w, "%v\t%v\t%v\n", ref, flavor.Name, version.Name)
+				key := &Key{Flavor: flavor.Name, Version: version.Name, Platform: build.Platform.String()}
+				if filter.Platform != "" && platformToEnum(filter.Platform) != key.Platform {
+					continue
+				}
+				entries = append(entries, &Entry{
+					Reference:     key.String(),
+					Flavor:        flavor.Name,
+					FilterProfile: flavor.FilterProfile,
+					Compliances:   compliances,
+					Version:       version.Name,
+					Platform:      platformFromEnum(key.Platform),
+					Cached:        cached != nil && cached(key),
+				})
 			}
 		}
 	}
-	return w.Flush()
+	return entries
+}
+
+func (f Filter) matchesFlavor(flavor *api.Flavor) bool {
+	if f.Flavor != "" && f.Flavor != flavor.Name && f.Flavor != flavor.FilterProfile {
+		return false
+	}
+	if f.Compliance == "" {
+		return true
+	}
+	for _, compliance := range flavor.Compliances {
+		if strings.EqualFold(compliance.String(), f.Compliance) {
+			return true
+		}
+	}
+	return false
 }
 
 func platformFromEnum(s string) string {
@@ -53,21 +159,12 @@ func platformFromEnum(s string) string {
 	return s
 }
 
-func fetch(url string) (*api.Manifest, error) {
-	// #nosec => This is by design, users can call out to wherever they like!
-	resp, err := transport.Get(url)
+func fetch(url string) (*Manifest, error) {
+	data, _, err := get(url, nil)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received %v response code from %v", resp.StatusCode, url)
-	}
-	defer resp.Body.Close() //nolint
-	result := api.Manifest{}
-	if err := jsonpb.Unmarshal(resp.Body, &result); err != nil {
-		return nil, fmt.Errorf("error unmarshalling manifest: %v", err)
-	}
-	return &result, nil
+	return decode(data)
 }
 
 func deterministicFlavors(flavors map[string]*api.Flavor) []*api.Flavor {
@@ -30,6 +30,7 @@ func TestPrint(t *testing.T) {
 	tests := []struct {
 		name             string
 		wantOutputFile   string
+		opts             PrintOptions
 		wantErr          bool
 		locationOverride string
 	}{
@@ -37,10 +38,32 @@ func TestPrint(t *testing.T) {
 			name:           "Prints golden output",
 			wantOutputFile: "list.golden",
 		},
+		{
+			name:           "Marks cached builds",
+			opts:           PrintOptions{Cached: func(key *Key) bool { return key.Version == "1.11.0" }},
+			wantOutputFile: "list_cached.golden",
+		},
+		{
+			name:           "Prints JSON filtered by compliance",
+			opts:           PrintOptions{Format: JSONFormat, Filter: Filter{Compliance: "fips1402"}},
+			wantOutputFile: "list_fips1402.json.golden",
+		},
+		{
+			name:           "Prints YAML filtered by flavor and platform",
+			opts:           PrintOptions{Format: YAMLFormat, Filter: Filter{Flavor: "standard", Platform: "darwin"}},
+			wantOutputFile: "list_darwin.yaml.golden",
+		},
+		{
+			name:           "Errors on unsupported format",
+			opts:           PrintOptions{Format: "xml"},
+			wantOutputFile: "empty.golden",
+			wantErr:        true,
+		},
 	}
 	for _, tt := range tests {
 		tc := tt
 		t.Run(tc.name, func(t *testing.T) {
+			defer useTempHomeDir(t)()
 			mock := mockServer(http.StatusOK, "manifest.golden")
 			defer mock.Close()
 			got := bytes.NewBuffer(nil)
@@ -54,7 +77,7 @@ func TestPrint(t *testing.T) {
 			}(GetURL())
 			err := SetURL(location)
 			assert.NoError(t, err)
-			if err := Print(got); tc.wantErr {
+			if err := Print(got, tc.opts); tc.wantErr {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
@@ -98,11 +121,12 @@ func TestFetch(t *testing.T) {
 			mock := mockServer(tc.responseStatusCode, tc.responseManifestFile)
 			defer mock.Close()
 			got, err := fetch(mock.URL)
-			assert.Equal(t, tc.want, got)
 			if tc.wantErr {
 				assert.Error(t, err)
+				assert.Nil(t, got)
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tc.want, got.Manifest)
 			}
 		})
 	}
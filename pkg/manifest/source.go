@@ -0,0 +1,204 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/getenvoy-package/api"
+	"github.com/tetratelabs/log"
+	"github.com/tetratelabs/multierror"
+)
+
+const (
+	// manifestFile is the name of the manifest file looked up in a manifest directory.
+	manifestFile = "manifest.json"
+)
+
+// parseLocation parses a given manifest location.
+//
+// Paths to local files and directories are converted into `file://` URLs. Whether they
+// exist is only checked once they are loaded, so that an unavailable one, e.g. on an
+// unmounted share, doesn't get in the way of the others.
+func parseLocation(rawurl string) (*url.URL, error) {
+	location, err := url.Parse(rawurl)
+	if err == nil && location.Scheme != "" && location.Scheme != "file" && location.Host != "" {
+		return location, nil
+	}
+	path := rawurl
+	if err == nil && location.Scheme == "file" {
+		path = location.Path
+	} else if err == nil && location.Scheme != "" {
+		return nil, errors.Errorf("%q is not a valid manifest URL", rawurl)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Errorf("%q is not a valid manifest URL", rawurl)
+	}
+	return &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}, nil
+}
+
+// Load returns the configured manifests merged into one.
+func Load() (*Manifest, error) {
+	return loadAll()
+}
+
+// LoadLocation returns the manifest at a given location, e.g. an extracted bundle directory.
+func LoadLocation(rawurl string) (*Manifest, error) {
+	return loadLocation(rawurl)
+}
+
+// loadAll loads all configured manifests and merges them into one.
+//
+// Manifests that cannot be loaded are skipped as long as at least one of them
+// can be.
+func loadAll() (*Manifest, error) {
+	manifests := make([]*Manifest, 0, len(manifestURLs))
+	var errs, lastErr error
+	for _, rawurl := range manifestURLs {
+		log.Debugf("retrieving manifest %s", rawurl)
+		manifest, err := loadLocation(rawurl)
+		if err != nil {
+			errs, lastErr = multierror.Append(errs, err), err
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	switch {
+	case len(manifests) == 0 && len(manifestURLs) == 1:
+		return nil, lastErr
+	case len(manifests) == 0:
+		return nil, errs
+	case errs != nil:
+		log.Warnf("some of the manifests are unavailable: %v", errs)
+	}
+	return merge(manifests...), nil
+}
+
+// loadLocation loads the manifest at a given location.
+//
+// Remote manifests are cached locally, local ones are read as is.
+func loadLocation(rawurl string) (*Manifest, error) {
+	location, err := parseLocation(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if location.Scheme != "file" {
+		return load(location.String())
+	}
+	path := filepath.FromSlash(location.Path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Errorf("%q is neither a valid manifest URL nor an existing file or directory", rawurl)
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, manifestFile)
+		location = &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read manifest %v", path)
+	}
+	manifest, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	manifest.rebase(location)
+	return manifest, nil
+}
+
+// rebase resolves relative download locations against the location of the manifest.
+//
+// This lets a manifest on a shared filesystem refer to archives next to it.
+func (m *Manifest) rebase(base *url.URL) {
+	for _, flavor := range m.Flavors {
+		for _, version := range flavor.Versions {
+			for _, build := range version.Builds {
+				location, err := url.Parse(build.DownloadLocationUrl)
+				if err != nil || location.IsAbs() {
+					continue
+				}
+				rebased := base.ResolveReference(location).String()
+				if digest, ok := m.Digests[build.DownloadLocationUrl]; ok {
+					delete(m.Digests, build.DownloadLocationUrl)
+					m.Digests[rebased] = digest
+				}
+				build.DownloadLocationUrl = rebased
+			}
+		}
+	}
+}
+
+// merge combines manifests in the order of preference.
+//
+// Flavors, versions and builds are merged. When several manifests provide the
+// same build, the download location of the preferred one is used and the others
+// become its mirrors.
+func merge(manifests ...*Manifest) *Manifest {
+	if len(manifests) == 1 {
+		return manifests[0]
+	}
+	result := &Manifest{
+		Manifest: &api.Manifest{Flavors: make(map[string]*api.Flavor)},
+		Digests:  make(map[string]*Digest),
+		Mirrors:  make(map[string][]string),
+	}
+	for _, manifest := range manifests {
+		if result.ManifestVersion == "" {
+			result.ManifestVersion = manifest.ManifestVersion
+		}
+		for name, flavor := range manifest.Flavors {
+			merged := result.Flavors[name]
+			if merged == nil {
+				merged = &api.Flavor{
+					Name:          flavor.Name,
+					FilterProfile: flavor.FilterProfile,
+					Filters:       flavor.Filters,
+					Compliances:   flavor.Compliances,
+					Versions:      make(map[string]*api.Version),
+				}
+				result.Flavors[name] = merged
+			}
+			for versionName, version := range flavor.Versions {
+				mergedVersion := merged.Versions[versionName]
+				if mergedVersion == nil {
+					mergedVersion = &api.Version{Name: version.Name, Builds: make(map[string]*api.Build)}
+					merged.Versions[versionName] = mergedVersion
+				}
+				for platform, build := range version.Builds {
+					existing := mergedVersion.Builds[platform]
+					if existing == nil {
+						mergedVersion.Builds[platform] = build
+						continue
+					}
+					primary := existing.DownloadLocationUrl
+					if build.DownloadLocationUrl != primary {
+						result.Mirrors[primary] = append(result.Mirrors[primary], build.DownloadLocationUrl)
+					}
+				}
+			}
+		}
+		for location, digest := range manifest.Digests {
+			if _, ok := result.Digests[location]; !ok {
+				result.Digests[location] = digest
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetURLs(t *testing.T) {
+	defer func(originalURLs []string) {
+		assert.NoError(t, SetURLs(originalURLs...))
+	}(GetURLs())
+
+	golden, _ := filepath.Abs(filepath.Join("testdata", "manifest.golden"))
+	assert.NoError(t, SetURLs("https://example.org/manifest.json", "testdata/mirror", "file://"+filepath.ToSlash(golden)))
+	assert.Equal(t, "https://example.org/manifest.json", GetURL())
+	assert.Error(t, SetURLs())
+	assert.NoError(t, SetURLs("testdata/missing"), "expected local manifests to be checked only once they are loaded")
+	assert.Error(t, SetURLs("ftp:/manifest.json"))
+}
+
+func TestLoadAllMergesManifests(t *testing.T) {
+	defer useTempHomeDir(t)()
+	mock := mockServer(http.StatusOK, "manifest.golden")
+	defer mock.Close()
+	defer func(originalURLs []string) {
+		assert.NoError(t, SetURLs(originalURLs...))
+	}(GetURLs())
+	require.NoError(t, SetURLs(mock.URL, "testdata/mirror"))
+	mirrorDir, _ := filepath.Abs(filepath.Join("testdata", "mirror"))
+
+	build, err := Locate(&Key{Flavor: "standard", Version: "1.11.0", Platform: "LINUX_GLIBC"})
+	require.NoError(t, err)
+	assert.Equal(t, &Build{
+		DownloadLocationURL: "standard:1.11.0/linux-glibc",
+		Mirrors:             []string{"file://" + filepath.ToSlash(filepath.Join(mirrorDir, "standard-1.11.0-linux-glibc.tar.xz"))},
+		MirrorDigests: map[string]Digest{
+			"file://" + filepath.ToSlash(filepath.Join(mirrorDir, "standard-1.11.0-linux-glibc.tar.xz")): {SHA256: fooSHA256},
+		},
+		Digest: Digest{SHA256: fooSHA256},
+	}, build, "expected the mirror to provide an alternative location and a checksum")
+
+	build, err = Locate(&Key{Flavor: "patched", Version: "1.11.0", Platform: "LINUX_GLIBC"})
+	require.NoError(t, err)
+	assert.Equal(t, &Build{
+		DownloadLocationURL: "file://" + filepath.ToSlash(filepath.Join(mirrorDir, "builds", "patched-1.11.0-linux-glibc.tar.xz")),
+	}, build, "expected flavors only available in the mirror to be merged")
+
+	got := bytes.NewBuffer(nil)
+	require.NoError(t, Print(got, PrintOptions{}))
+	assert.Contains(t, got.String(), "patched:1.11.0/linux-glibc")
+	assert.Contains(t, got.String(), "standard-fips1402:1.10.0/linux-glibc")
+}
+
+func TestLoadAllSkipsUnavailableManifests(t *testing.T) {
+	defer useTempHomeDir(t)()
+	mock := mockServer(http.StatusTeapot, "manifest.golden")
+	defer mock.Close()
+	defer func(originalURLs []string) {
+		assert.NoError(t, SetURLs(originalURLs...))
+	}(GetURLs())
+
+	require.NoError(t, SetURLs(mock.URL, "testdata/mirror"))
+	build, err := Locate(&Key{Flavor: "patched", Version: "1.11.0", Platform: "LINUX_GLIBC"})
+	assert.NoError(t, err)
+	assert.NotNil(t, build)
+
+	require.NoError(t, SetURLs(mock.URL, mock.URL+"/other"))
+	_, err = Locate(&Key{Flavor: "patched", Version: "1.11.0", Platform: "LINUX_GLIBC"})
+	assert.Error(t, err)
+
+	require.NoError(t, SetURLs("testdata/missing", "testdata/mirror"))
+	build, err = Locate(&Key{Flavor: "patched", Version: "1.11.0", Platform: "LINUX_GLIBC"})
+	assert.NoError(t, err, "expected a missing local manifest to be skipped")
+	assert.NotNil(t, build)
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+// Keys returns keys of all builds in the manifest in a deterministic order.
+func (m *Manifest) Keys() []*Key {
+	keys := make([]*Key, 0)
+	for _, flavor := range deterministicFlavors(m.Flavors) {
+		for _, version := range deterministicVersions(flavor.Versions) {
+			for _, build := range deterministicBuilds(version.Builds) {
+				keys = append(keys, &Key{Flavor: flavor.Name, Version: version.Name, Platform: build.Platform.String()})
+			}
+		}
+	}
+	return keys
+}
+
+// Subset returns a manifest that describes only the builds with the given keys,
+// e.g. to move them to another machine.
+//
+// Builds missing from the manifest are described with minimal metadata and
+// no download location.
+func (m *Manifest) Subset(keys ...*Key) *Manifest {
+	result := &Manifest{
+		Manifest: &api.Manifest{ManifestVersion: m.GetManifestVersion(), Flavors: make(map[string]*api.Flavor)},
+		Digests:  make(map[string]*Digest),
+		Mirrors:  make(map[string][]string),
+	}
+	for _, key := range keys {
+		flavor := m.GetFlavors()[key.Flavor]
+		if flavor != nil && result.Flavors[key.Flavor] == nil {
+			result.Flavors[key.Flavor] = &api.Flavor{
+				Name:          flavor.Name,
+				FilterProfile: flavor.FilterProfile,
+				Filters:       flavor.Filters,
+				Compliances:   flavor.Compliances,
+				Versions:      make(map[string]*api.Version),
+			}
+		}
+		build := &Build{}
+		if m.Manifest != nil {
+			if located, err := LocateBuild(key, m); err == nil {
+				build = located
+			}
+		}
+		result.SetBuild(key, build)
+	}
+	return result
+}
+
+// SetBuild sets the build with a given key, adding its flavor and version to
+// the manifest if necessary.
+func (m *Manifest) SetBuild(key *Key, build *Build) {
+	if m.Manifest == nil {
+		m.Manifest = &api.Manifest{}
+	}
+	if m.Flavors == nil {
+		m.Flavors = make(map[string]*api.Flavor)
+	}
+	flavor := m.Flavors[key.Flavor]
+	if flavor == nil {
+		flavor = &api.Flavor{Name: key.Flavor, FilterProfile: key.Flavor}
+		m.Flavors[key.Flavor] = flavor
+	}
+	if flavor.Versions == nil {
+		flavor.Versions = make(map[string]*api.Version)
+	}
+	version := flavor.Versions[key.Version]
+	if version == nil {
+		version = &api.Version{Name: key.Version, Builds: make(map[string]*api.Build)}
+		flavor.Versions[key.Version] = version
+	}
+	platform := api.Build_Platform(api.Build_Platform_value[key.Platform])
+	version.Builds[platform.String()] = &api.Build{Platform: platform, DownloadLocationUrl: build.DownloadLocationURL}
+	if build.DownloadLocationURL == "" {
+		return
+	}
+	if m.Digests == nil {
+		m.Digests = make(map[string]*Digest)
+	}
+	if build.Digest != (Digest{}) {
+		digest := build.Digest
+		m.Digests[build.DownloadLocationURL] = &digest
+	}
+	if len(build.Mirrors) > 0 {
+		if m.Mirrors == nil {
+			m.Mirrors = make(map[string][]string)
+		}
+		m.Mirrors[build.DownloadLocationURL] = build.Mirrors
+	}
+}
+
+// Encode returns the JSON representation of the manifest, including integrity
+// metadata of its builds.
+func (m *Manifest) Encode() ([]byte, error) {
+	marshaler := jsonpb.Marshaler{Indent: "  "}
+	text, err := marshaler.MarshalToString(m.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling manifest: %v", err)
+	}
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, fmt.Errorf("error marshalling manifest: %v", err)
+	}
+	// add fields that are unknown to the getenvoy-package API
+	for _, flavor := range children(raw, "flavors") {
+		for _, version := range children(flavor, "versions") {
+			for _, build := range children(version, "builds") {
+				digest := m.Digests[fmt.Sprint(build["downloadLocationUrl"])]
+				if digest == nil {
+					continue
+				}
+				if digest.SHA256 != "" {
+					build["sha256"] = digest.SHA256
+				}
+				if digest.Signature != "" {
+					build["signature"] = digest.Signature
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(raw); err != nil {
+		return nil, fmt.Errorf("error marshalling manifest: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func children(parent map[string]interface{}, name string) []map[string]interface{} {
+	values, _ := parent[name].(map[string]interface{})
+	result := make([]map[string]interface{}, 0, len(values))
+	for _, value := range values {
+		if child, ok := value.(map[string]interface{}); ok {
+			result = append(result, child)
+		}
+	}
+	return result
+}
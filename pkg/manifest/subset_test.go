@@ -0,0 +1,47 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+func TestSubsetAndEncode(t *testing.T) {
+	source := &Manifest{Manifest: goodManifest()}
+	fips := &Key{Flavor: "standard-fips1402", Version: "1.10.0", Platform: "LINUX_GLIBC"}
+	custom := &Key{Flavor: "custom", Version: "1.0.0", Platform: "DARWIN"}
+
+	subset := source.Subset(fips, custom)
+	assert.Equal(t, []*Key{custom, fips}, subset.Keys())
+	subset.SetBuild(fips, &Build{DownloadLocationURL: "builds/fips.tar.gz", Digest: Digest{SHA256: fooSHA256}})
+
+	data, err := subset.Encode()
+	require.NoError(t, err)
+	decoded, err := decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, []api.Compliance{api.Compliance_FIPS1402}, decoded.Flavors["standard-fips1402"].Compliances)
+	assert.Nil(t, decoded.Flavors["standard"], "expected builds that were not asked for to be left out")
+
+	build, err := LocateBuild(fips, decoded)
+	require.NoError(t, err)
+	assert.Equal(t, &Build{DownloadLocationURL: "builds/fips.tar.gz", Digest: Digest{SHA256: fooSHA256}}, build)
+	build, err = LocateBuild(custom, decoded)
+	require.NoError(t, err)
+	assert.Equal(t, &Build{}, build)
+}
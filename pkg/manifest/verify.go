@@ -0,0 +1,136 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/tetratelabs/log"
+)
+
+var (
+	// trustedKeys defines public keys that signatures of GetEnvoy builds are verified against.
+	trustedKeys []ed25519.PublicKey
+	// insecureSkipVerify allows builds without a checksum to be used without checking their integrity.
+	insecureSkipVerify = false
+)
+
+// SetInsecureSkipVerify allows builds the manifest has no checksum for to be used without checking their integrity.
+//
+// Builds that have a checksum are verified regardless, and every build must have one once a trusted key is set.
+func SetInsecureSkipVerify(value bool) {
+	insecureSkipVerify = value
+}
+
+// SetTrustedKeys sets public keys that signatures of GetEnvoy builds are verified against.
+//
+// Once at least one key is set, every build must have a checksum and a valid signature.
+func SetTrustedKeys(keys ...ed25519.PublicKey) {
+	trustedKeys = keys
+}
+
+// ParsePublicKey parses a base64-encoded Ed25519 public key.
+func ParsePublicKey(text string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("%q is not a valid base64-encoded Ed25519 public key", text)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ChecksumMismatchError is returned when a build archive doesn't match its checksum in the manifest.
+type ChecksumMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return "checksum mismatch: expected sha256 " + e.Expected + ", got " + e.Actual
+}
+
+// Verifiable checks whether the build has the integrity metadata Verify requires, e.g. before it is downloaded.
+func (b *Build) Verifiable() error {
+	if b.SHA256 != "" {
+		return nil
+	}
+	if len(trustedKeys) > 0 || !insecureSkipVerify {
+		return errors.Errorf("manifest has no checksum for %v, its integrity can't be verified "+
+			"(use --insecure-skip-verify to fetch it anyway)", b.DownloadLocationURL)
+	}
+	return nil
+}
+
+// Verify checks the archive at a given path against integrity metadata of the build.
+func (b *Build) Verify(archive string) error {
+	if b.SHA256 == "" {
+		if err := b.Verifiable(); err != nil {
+			return err
+		}
+		log.Warnf("manifest has no checksum for %v, skipping integrity check", b.DownloadLocationURL)
+		return nil
+	}
+	sum, err := sha256File(archive)
+	if err != nil {
+		return errors.Wrapf(err, "unable to compute checksum of %v", archive)
+	}
+	if actual := hex.EncodeToString(sum); !strings.EqualFold(actual, b.SHA256) {
+		return &ChecksumMismatchError{Expected: strings.ToLower(b.SHA256), Actual: actual}
+	}
+	return b.verifySignature(sum)
+}
+
+func (b *Build) verifySignature(sum []byte) error {
+	if len(trustedKeys) == 0 {
+		if b.Signature != "" {
+			log.Debugf("no trusted keys configured, skipping signature check of %v", b.DownloadLocationURL)
+		}
+		return nil
+	}
+	if b.Signature == "" {
+		return errors.Errorf("manifest has no signature for %v", b.DownloadLocationURL)
+	}
+	signature, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil {
+		return errors.Errorf("signature of %v is not valid base64", b.DownloadLocationURL)
+	}
+	for _, key := range trustedKeys {
+		if ed25519.Verify(key, sum, signature) {
+			return nil
+		}
+	}
+	return errors.Errorf("signature of %v doesn't match any of the trusted keys", b.DownloadLocationURL)
+}
+
+func sha256File(path string) ([]byte, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fooSHA256 is the SHA-256 checksum of "foo"
+const fooSHA256 = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestLocateBuildWithDigest(t *testing.T) {
+	mock := mockServer(http.StatusOK, "checksums.golden")
+	defer mock.Close()
+	manifest, err := fetch(mock.URL)
+	assert.NoError(t, err)
+
+	build, err := LocateBuild(&Key{Flavor: "standard", Version: "1.11.0", Platform: "LINUX_GLIBC"}, manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, &Build{
+		DownloadLocationURL: "standard:1.11.0/linux-glibc",
+		Digest:              Digest{SHA256: fooSHA256, Signature: "c2lnbmF0dXJl"},
+	}, build)
+
+	build, err = LocateBuild(&Key{Flavor: "standard", Version: "1.11.0", Platform: "DARWIN"}, manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, &Build{DownloadLocationURL: "standard:1.11.0/darwin"}, build)
+}
+
+func TestBuildVerify(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	sum := sha256.Sum256([]byte("foo"))
+	validSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(private, sum[:]))
+	otherPublic, _, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name        string
+		digest      Digest
+		trustedKeys []ed25519.PublicKey
+		skipVerify  bool
+		wantErr     bool
+	}{
+		{
+			name:    "no checksum and no trusted keys",
+			digest:  Digest{},
+			wantErr: true,
+		},
+		{
+			name:       "no checksum with insecure skip verify",
+			digest:     Digest{},
+			skipVerify: true,
+		},
+		{
+			name:        "no checksum with trusted keys and insecure skip verify",
+			digest:      Digest{},
+			trustedKeys: []ed25519.PublicKey{public},
+			skipVerify:  true,
+			wantErr:     true,
+		},
+		{
+			name:   "matching checksum",
+			digest: Digest{SHA256: fooSHA256},
+		},
+		{
+			name:   "matching upper-case checksum",
+			digest: Digest{SHA256: "2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE"},
+		},
+		{
+			name:    "mismatching checksum",
+			digest:  Digest{SHA256: "0000000000000000000000000000000000000000000000000000000000000000"},
+			wantErr: true,
+		},
+		{
+			name:        "valid signature",
+			digest:      Digest{SHA256: fooSHA256, Signature: validSignature},
+			trustedKeys: []ed25519.PublicKey{otherPublic, public},
+		},
+		{
+			name:        "signature by untrusted key",
+			digest:      Digest{SHA256: fooSHA256, Signature: validSignature},
+			trustedKeys: []ed25519.PublicKey{otherPublic},
+			wantErr:     true,
+		},
+		{
+			name:        "missing signature with trusted keys",
+			digest:      Digest{SHA256: fooSHA256},
+			trustedKeys: []ed25519.PublicKey{public},
+			wantErr:     true,
+		},
+		{
+			name:        "missing checksum with trusted keys",
+			digest:      Digest{},
+			trustedKeys: []ed25519.PublicKey{public},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+			defer os.RemoveAll(tmpDir)
+			archive := filepath.Join(tmpDir, "envoy.tar.gz")
+			ioutil.WriteFile(archive, []byte("foo"), 0600)
+
+			SetTrustedKeys(tc.trustedKeys...)
+			defer SetTrustedKeys()
+			SetInsecureSkipVerify(tc.skipVerify)
+			defer SetInsecureSkipVerify(false)
+
+			build := &Build{DownloadLocationURL: "standard:1.11.0/linux-glibc", Digest: tc.digest}
+			if err := build.Verify(archive); tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(nil)
+	got, err := ParsePublicKey(base64.StdEncoding.EncodeToString(public) + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, public, got)
+
+	_, err = ParsePublicKey("not a key")
+	assert.Error(t, err)
+}
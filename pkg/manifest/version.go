@@ -0,0 +1,160 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+const (
+	// latestVersion is an alias for the newest release version of a flavor.
+	latestVersion = "latest"
+)
+
+var (
+	// releaseVersionFormat matches versions like `1.17.0` or `1.17.0-p1`.
+	releaseVersionFormat = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?(.*)$`)
+	// wildcardFormat matches ranges like `1.x` or `1.17.*`.
+	wildcardFormat = regexp.MustCompile(`^(\d+)(?:\.(\d+))?\.[x*]$`)
+	// prefixedFormat matches ranges like `~1.17` or `^1.17.2`.
+	prefixedFormat = regexp.MustCompile(`^([~^])(\d+)(?:\.(\d+))?(?:\.(\d+))?$`)
+)
+
+// IsVersionRange returns true if a given version is a range or an alias
+// rather than a concrete version, e.g. `1.16.x`, `~1.17` or `latest`.
+func IsVersionRange(version string) bool {
+	return version == latestVersion || wildcardFormat.MatchString(version) || prefixedFormat.MatchString(version)
+}
+
+// releaseVersion represents a parsed release version.
+type releaseVersion struct {
+	major, minor, patch int
+	suffix              string
+}
+
+func parseReleaseVersion(text string) (*releaseVersion, bool) {
+	matches := releaseVersionFormat.FindStringSubmatch(text)
+	if matches == nil {
+		return nil, false
+	}
+	return &releaseVersion{atoi(matches[1]), atoi(matches[2]), atoi(matches[3]), matches[4]}, true
+}
+
+// less returns true if the version precedes the other one.
+// A version with a suffix, e.g. `1.17.0-rc1`, precedes the same version without it.
+func (v *releaseVersion) less(other *releaseVersion) bool {
+	switch {
+	case v.major != other.major:
+		return v.major < other.major
+	case v.minor != other.minor:
+		return v.minor < other.minor
+	case v.patch != other.patch:
+		return v.patch < other.patch
+	case v.suffix == "" || other.suffix == "":
+		return v.suffix != "" && other.suffix == ""
+	default:
+		return v.suffix < other.suffix
+	}
+}
+
+// versionRange represents a parsed version range.
+type versionRange func(*releaseVersion) bool
+
+func parseVersionRange(text string) (versionRange, error) {
+	if text == latestVersion {
+		return func(*releaseVersion) bool { return true }, nil
+	}
+	if matches := wildcardFormat.FindStringSubmatch(text); matches != nil {
+		major, minor := atoi(matches[1]), matches[2]
+		return func(v *releaseVersion) bool {
+			return v.major == major && (minor == "" || v.minor == atoi(minor))
+		}, nil
+	}
+	if matches := prefixedFormat.FindStringSubmatch(text); matches != nil {
+		min := &releaseVersion{major: atoi(matches[2]), minor: atoi(matches[3]), patch: atoi(matches[4])}
+		sameMinor := matches[1] == "~" && matches[3] != ""
+		return func(v *releaseVersion) bool {
+			if v.major != min.major || (sameMinor && v.minor != min.minor) {
+				return false
+			}
+			return !v.less(min)
+		}, nil
+	}
+	return nil, errors.Errorf("%q is not a valid version range", text)
+}
+
+// Resolve returns a key with its version range or alias, if any, replaced by
+// the newest matching version available in the GetEnvoy manifest.
+func Resolve(key *Key) (*Key, error) {
+	if key == nil {
+		return nil, errors.New("passed key was nil")
+	}
+	if !IsVersionRange(key.Version) {
+		return key, nil
+	}
+	manifest, err := loadAll()
+	if err != nil {
+		return nil, err
+	}
+	return ResolveKey(key, manifest)
+}
+
+// ResolveKey returns a key with its version range or alias, if any, replaced by
+// the newest matching version in the manifest that has a build for the key platform.
+func ResolveKey(key *Key, manifest *Manifest) (*Key, error) {
+	if !IsVersionRange(key.Version) {
+		return key, nil
+	}
+	matches, err := parseVersionRange(key.Version)
+	if err != nil {
+		return nil, err
+	}
+	var newest *releaseVersion
+	resolved := ""
+	candidates := make([]*api.Version, 0)
+	if flavor := manifest.Flavors[key.Flavor]; flavor != nil {
+		for name, version := range flavor.Versions {
+			v, ok := parseReleaseVersion(name)
+			// versions with a suffix, e.g. `1.17.0-rc1`, are never picked unless asked for explicitly
+			if !ok || v.suffix != "" || !matches(v) {
+				continue
+			}
+			candidates = append(candidates, version)
+			if newest != nil && !newest.less(v) {
+				continue
+			}
+			for _, build := range version.Builds {
+				if strings.EqualFold(build.Platform.String(), key.Platform) {
+					newest, resolved = v, name
+					break
+				}
+			}
+		}
+	}
+	if resolved == "" {
+		return nil, noBuildError("unable to find GetEnvoy build matching reference", key, candidates...)
+	}
+	return &Key{Flavor: key.Flavor, Version: resolved, Platform: key.Platform}, nil
+}
+
+func atoi(text string) int {
+	value, _ := strconv.Atoi(text)
+	return value
+}
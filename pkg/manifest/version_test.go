@@ -0,0 +1,93 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tetratelabs/getenvoy-package/api"
+)
+
+func TestResolveKey(t *testing.T) {
+	versions := map[string]*api.Version{}
+	for name, platform := range map[string]api.Build_Platform{
+		"nightly":     api.Build_LINUX_GLIBC,
+		"1.16.0":      api.Build_LINUX_GLIBC,
+		"1.16.3":      api.Build_LINUX_GLIBC,
+		"1.16.10":     api.Build_LINUX_GLIBC,
+		"1.17.0-rc1":  api.Build_LINUX_GLIBC,
+		"1.17.0":      api.Build_LINUX_GLIBC,
+		"1.17.1":      api.Build_LINUX_GLIBC,
+		"1.18.0":      api.Build_DARWIN,
+		"2.0.0-alpha": api.Build_LINUX_GLIBC,
+	} {
+		versions[name] = &api.Version{
+			Name:   name,
+			Builds: map[string]*api.Build{platform.String(): {Platform: platform}},
+		}
+	}
+	manifest := &Manifest{Manifest: &api.Manifest{
+		Flavors: map[string]*api.Flavor{"standard": {Name: "standard", Versions: versions}},
+	}}
+
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "1.16.0", want: "1.16.0"},
+		{version: "nightly", want: "nightly"},
+		{version: "1.16.x", want: "1.16.10"},
+		{version: "1.16.*", want: "1.16.10"},
+		{version: "1.x", want: "1.17.1"},
+		{version: "~1.17", want: "1.17.1"},
+		{version: "~1.16.4", want: "1.16.10"},
+		{version: "^1.16.4", want: "1.17.1"},
+		{version: "latest", want: "1.17.1"},
+		{version: "2.x", wantErr: true},
+		{version: "1.18.x", wantErr: true},
+		{version: "~3", wantErr: true},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.version, func(t *testing.T) {
+			key := &Key{Flavor: "standard", Version: tc.version, Platform: "LINUX_GLIBC"}
+			got, err := ResolveKey(key, manifest)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &Key{Flavor: "standard", Version: tc.want, Platform: "LINUX_GLIBC"}, got)
+		})
+	}
+}
+
+func TestIsVersionRange(t *testing.T) {
+	for version, want := range map[string]bool{
+		"1.17.0":  false,
+		"1.15":    false,
+		"nightly": false,
+		"latest":  true,
+		"1.x":     true,
+		"1.17.x":  true,
+		"1.17.*":  true,
+		"~1.17":   true,
+		"^1.17.2": true,
+	} {
+		assert.Equal(t, want, IsVersionRange(version), version)
+	}
+}
@@ -22,31 +22,63 @@ import (
 )
 
 var (
-	cliUserAgent  = fmt.Sprintf("GetEnvoy/%s", version.Build.Version)
-	defaultClient = NewClient(AddUserAgent(cliUserAgent))
+	cliUserAgent   = fmt.Sprintf("GetEnvoy/%s", version.Build.Version)
+	defaultOptions []Option
+	defaultClient  = NewClient(AddUserAgent(cliUserAgent))
 )
 
 // Option represents an argument of NewClient
-type Option func(http.RoundTripper) http.RoundTripper
+type Option func(*clientConfig)
+
+// clientConfig represents the configuration of a client being built by NewClient.
+type clientConfig struct {
+	// transport is the underlying transport, private to the client being built
+	transport *http.Transport
+	// wrappers decorate the underlying transport in the order they were added
+	wrappers []func(http.RoundTripper) http.RoundTripper
+}
 
 // NewClient returns HTTP client for use of GetEnvoy CLI.
 func NewClient(opts ...Option) *http.Client {
-	tr := http.DefaultTransport
+	cfg := &clientConfig{transport: http.DefaultTransport.(*http.Transport).Clone()}
 	for _, opt := range opts {
-		tr = opt(tr)
+		opt(cfg)
+	}
+	var tr http.RoundTripper = cfg.transport
+	for _, wrap := range cfg.wrappers {
+		tr = wrap(tr)
 	}
 	client := &http.Client{Transport: tr}
 	return client
 }
 
+// SetDefaultOptions sets options of the client used by Get and Do, e.g. a proxy or credentials.
+// They are also returned by DefaultOptions for the sake of other clients, e.g. the one used to push wasm images.
+func SetDefaultOptions(opts ...Option) {
+	defaultOptions = opts
+	defaultClient = NewClient(DefaultOptions()...)
+}
+
+// DefaultOptions returns options of the client used by Get and Do, including the GetEnvoy user-agent.
+func DefaultOptions() []Option {
+	return append([]Option{AddUserAgent(cliUserAgent)}, defaultOptions...)
+}
+
 // AddUserAgent returns Option that adds passed user-agent to every requests.
 // It should be passed as an argument of NewClient.
 func AddUserAgent(ua string) Option {
-	return func(tr http.RoundTripper) http.RoundTripper {
+	return wrap(func(tr http.RoundTripper) http.RoundTripper {
 		return &funcTripper{roundTrip: func(r *http.Request) (*http.Response, error) {
 			r.Header.Add("User-Agent", ua)
 			return tr.RoundTrip(r)
 		}}
+	})
+}
+
+// wrap returns Option that decorates the underlying transport.
+func wrap(fn func(http.RoundTripper) http.RoundTripper) Option {
+	return func(cfg *clientConfig) {
+		cfg.wrappers = append(cfg.wrappers, fn)
 	}
 }
 
@@ -62,3 +94,8 @@ func (f funcTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 func Get(url string) (*http.Response, error) {
 	return defaultClient.Get(url)
 }
+
+// Do is thin wrapper of net/http.Client.Do.
+func Do(req *http.Request) (*http.Response, error) {
+	return defaultClient.Do(req)
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Config represents user-facing HTTP settings, e.g. those of a corporate network.
+//
+// Here is an example of a config file:
+//
+//	proxy: http://proxy.example.org:3128
+//	caBundle: /etc/ssl/certs/example-ca.pem
+//	auth:
+//	- host: mirror.example.org
+//	  bearerToken: s3cr3t
+//	- host: registry.example.org:5000
+//	  username: getenvoy
+//	  password: s3cr3t
+type Config struct {
+	// Proxy is the URL of the proxy to send requests through.
+	Proxy string `json:"proxy,omitempty"`
+	// CABundle is the path to a PEM bundle of certificates to trust in addition to the system ones.
+	CABundle string `json:"caBundle,omitempty"`
+	// Auth holds credentials to add to requests to particular hosts.
+	Auth []HostAuth `json:"auth,omitempty"`
+}
+
+// HostAuth represents credentials of a particular host.
+type HostAuth struct {
+	// Host is the host name, optionally with a port.
+	Host string `json:"host"`
+	// BearerToken is a token to send in a bearer Authorization header.
+	BearerToken string `json:"bearerToken,omitempty"`
+	// Username and Password are sent in a basic Authorization header.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// LoadConfig reads the config file at a given path.
+// A missing file is treated as an empty config.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read HTTP config")
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "%v is not a valid HTTP config", path)
+	}
+	return config, nil
+}
+
+// Options returns client options that correspond to the config.
+func (c *Config) Options() ([]Option, error) {
+	opts := make([]Option, 0)
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+			return nil, errors.Errorf("%q is not a valid proxy URL", c.Proxy)
+		}
+		opts = append(opts, WithProxy(proxy))
+	}
+	if c.CABundle != "" {
+		pool, err := LoadCABundle(c.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRootCAs(pool))
+	}
+	for _, auth := range c.Auth {
+		switch {
+		case auth.Host == "":
+			return nil, errors.New("host of HTTP credentials cannot be empty")
+		case auth.BearerToken != "":
+			opts = append(opts, WithAuthorization(auth.Host, BearerToken(auth.BearerToken)))
+		case auth.Username != "":
+			opts = append(opts, WithAuthorization(auth.Host, BasicAuth(auth.Username, auth.Password)))
+		default:
+			return nil, errors.Errorf("HTTP credentials of %v must have either a bearer token or a username", auth.Host)
+		}
+	}
+	return opts, nil
+}
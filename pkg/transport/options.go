@@ -0,0 +1,110 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WithProxy returns Option that sends every request through the proxy at a given URL
+// instead of the one configured by HTTP_PROXY and HTTPS_PROXY environment variables.
+func WithProxy(proxy *url.URL) Option {
+	return func(cfg *clientConfig) {
+		cfg.transport.Proxy = http.ProxyURL(proxy)
+	}
+}
+
+// WithRootCAs returns Option that verifies server certificates against a given pool
+// instead of the system one.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(cfg *clientConfig) {
+		cfg.tlsConfig().RootCAs = pool
+	}
+}
+
+// WithInsecureSkipVerify returns Option that turns off verification of server certificates.
+func WithInsecureSkipVerify(insecure bool) Option {
+	return func(cfg *clientConfig) {
+		// nolint:gosec this option is only enabled when the user asks for it explicitly.
+		cfg.tlsConfig().InsecureSkipVerify = insecure
+	}
+}
+
+// WithAuthorization returns Option that adds a given Authorization header value,
+// e.g. BearerToken("..."), to every request to a given host that doesn't have one already.
+//
+// The host might include a port, e.g. `mirror.example.org:8443`, in which case only
+// requests to that port are authorized.
+func WithAuthorization(host, value string) Option {
+	return wrap(func(tr http.RoundTripper) http.RoundTripper {
+		return &funcTripper{roundTrip: func(r *http.Request) (*http.Response, error) {
+			if r.Header.Get("Authorization") == "" && matchesHost(r.URL, host) {
+				r = r.Clone(r.Context())
+				r.Header.Set("Authorization", value)
+			}
+			return tr.RoundTrip(r)
+		}}
+	})
+}
+
+// BearerToken returns an Authorization header value for a given token.
+func BearerToken(token string) string {
+	return "Bearer " + token
+}
+
+// BasicAuth returns an Authorization header value for a given username and password.
+func BasicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// LoadCABundle returns a pool of the system certificates along with the ones in
+// the PEM bundle at a given path.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read CA bundle")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("%v doesn't contain any PEM-encoded certificates", path)
+	}
+	return pool, nil
+}
+
+func (cfg *clientConfig) tlsConfig() *tls.Config {
+	if cfg.transport.TLSClientConfig == nil {
+		cfg.transport.TLSClientConfig = &tls.Config{} //nolint:gosec
+	}
+	return cfg.transport.TLSClientConfig
+}
+
+func matchesHost(u *url.URL, host string) bool {
+	if strings.Contains(host, ":") {
+		return strings.EqualFold(u.Host, host)
+	}
+	return strings.EqualFold(u.Hostname(), host)
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithAuthorization(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Result", r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{name: "host", host: u.Hostname(), want: BearerToken("s3cr3t")},
+		{name: "host and port", host: u.Host, want: BearerToken("s3cr3t")},
+		{name: "other port", host: u.Hostname() + ":1", want: ""},
+		{name: "other host", host: "example.org", want: ""},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(WithAuthorization(tc.host, BearerToken("s3cr3t")))
+			res, err := client.Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if got := res.Header.Get("Result"); got != tc.want {
+				t.Errorf("Authorization = %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Result", r.URL.String())
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := NewClient(WithProxy(proxyURL))
+	res, err := client.Get("http://example.invalid/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if got := res.Header.Get("Result"); got != "http://example.invalid/manifest.json" {
+		t.Errorf("proxied URL = %q; want http://example.invalid/manifest.json", got)
+	}
+}
+
+func TestWithRootCAs(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	if _, err := NewClient().Get(ts.URL); err == nil {
+		t.Fatal("expected a certificate signed by an unknown authority to be rejected")
+	}
+
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+	bundle := filepath.Join(tmpDir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{CABundle: bundle}
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewClient(opts...).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if _, err := LoadCABundle(filepath.Join(tmpDir, "missing.pem")); err == nil {
+		t.Error("expected a missing CA bundle to be rejected")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "getenvoy-test-")
+	defer os.RemoveAll(tmpDir)
+
+	config, err := LoadConfig(filepath.Join(tmpDir, "missing.yaml"))
+	if err != nil || config.Proxy != "" || len(config.Auth) != 0 {
+		t.Errorf("LoadConfig() of a missing file = %v, %v; want an empty config", config, err)
+	}
+
+	path := filepath.Join(tmpDir, "http.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+proxy: http://proxy.example.org:3128
+auth:
+- host: mirror.example.org
+  bearerToken: s3cr3t
+- host: registry.example.org:5000
+  username: getenvoy
+  password: s3cr3t
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Proxy != "http://proxy.example.org:3128" || len(config.Auth) != 2 || config.Auth[1].Username != "getenvoy" {
+		t.Errorf("LoadConfig() = %+v", config)
+	}
+	if opts, err := config.Options(); err != nil || len(opts) != 3 {
+		t.Errorf("Options() = %d options, %v; want 3 options", len(opts), err)
+	}
+
+	invalid := []*Config{
+		{Proxy: "not a url"},
+		{Auth: []HostAuth{{BearerToken: "s3cr3t"}}},
+		{Auth: []HostAuth{{Host: "mirror.example.org"}}},
+	}
+	for _, config := range invalid {
+		if _, err := config.Options(); err == nil {
+			t.Errorf("Options() of %+v should have failed", config)
+		}
+	}
+}
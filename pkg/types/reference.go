@@ -30,7 +30,8 @@ type Reference struct {
 }
 
 var (
-	referenceFormat = regexp.MustCompile(`^([\w\d-\._]+):([\w\d-\._]+)/?([\w\d-\._]+)?$`)
+	// version can also be a range, e.g. `1.16.x`, `~1.17` or `^1.17`, or an alias, e.g. `latest`
+	referenceFormat = regexp.MustCompile(`^([\w\d-\._]+):([\w\d-\._~^*]+)/?([\w\d-\._]+)?$`)
 )
 
 // ParseReference parses a given text as a Reference.
@@ -67,6 +67,22 @@ var _ = Describe("ParseReference()", func() {
 				input:    `standard:1.11.0/`,
 				expected: Reference{Flavor: "standard", Version: "1.11.0", Platform: ""},
 			}),
+			Entry("version range", testCase{
+				input:    `standard:1.16.x`,
+				expected: Reference{Flavor: "standard", Version: "1.16.x", Platform: ""},
+			}),
+			Entry("tilde version range", testCase{
+				input:    `standard:~1.17/darwin`,
+				expected: Reference{Flavor: "standard", Version: "~1.17", Platform: "darwin"},
+			}),
+			Entry("caret version range", testCase{
+				input:    `standard:^1.17`,
+				expected: Reference{Flavor: "standard", Version: "^1.17", Platform: ""},
+			}),
+			Entry("version alias", testCase{
+				input:    `standard:LATEST`,
+				expected: Reference{Flavor: "standard", Version: "latest", Platform: ""},
+			}),
 			Entry("special characters", testCase{
 				input:    `abcd-EFGH.01234_:-56789.XYZ_/`,
 				expected: Reference{Flavor: "abcd-efgh.01234_", Version: "-56789.xyz_", Platform: ""},
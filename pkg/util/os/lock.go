@@ -0,0 +1,78 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package os
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// LockFile acquires an exclusive advisory lock on the file at a given path,
+// creating the file and its parent directories if necessary.
+//
+// It blocks until the lock becomes available. If the lock is held by another
+// process at the time of the call, onWait gets called (unless it is nil)
+// before blocking.
+//
+// The lock is released by the returned function or once the process exits.
+func LockFile(path string, onWait func()) (unlock func() error, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		if onWait != nil {
+			onWait()
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		f.Close() //nolint
+		return nil, errors.Wrapf(err, "unable to lock %q", path)
+	}
+	return func() error {
+		defer f.Close() //nolint
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}, nil
+}
+
+// FileLocked checks whether a lock on the file at a given path is held, e.g. by LockFile in another process.
+//
+// A file that doesn't exist is not locked.
+func FileLocked(path string) (bool, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close() //nolint
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to check lock of %q", path)
+	}
+	return false, syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
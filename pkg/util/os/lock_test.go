@@ -0,0 +1,87 @@
+// Copyright 2021 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package os
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LockFile()", func() {
+
+	var tmpDir string
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		tmpDir = dir
+	})
+
+	AfterEach(func() {
+		if tmpDir != "" {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		}
+	})
+
+	It("should create parent directories of the lock file", func() {
+		path := filepath.Join(tmpDir, "a", "b", "c.lock")
+
+		unlock, err := LockFile(path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path).To(BeARegularFile())
+		Expect(unlock()).To(Succeed())
+	})
+
+	It("should block until the lock is released", func() {
+		path := filepath.Join(tmpDir, "c.lock")
+
+		By("acquiring the lock")
+		unlock, err := LockFile(path, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("trying to acquire the same lock")
+		waiting := make(chan struct{})
+		acquired := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			unlock, err := LockFile(path, func() { close(waiting) })
+			Expect(err).NotTo(HaveOccurred())
+			close(acquired)
+			Expect(unlock()).To(Succeed())
+		}()
+		Eventually(waiting).Should(BeClosed())
+		Consistently(acquired).ShouldNot(BeClosed())
+
+		By("releasing the lock")
+		Expect(unlock()).To(Succeed())
+		Eventually(acquired).Should(BeClosed())
+	})
+
+	It("should tell whether the lock is held", func() {
+		path := filepath.Join(tmpDir, "c.lock")
+		Expect(FileLocked(path)).To(BeFalse())
+
+		unlock, err := LockFile(path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(FileLocked(path)).To(BeTrue())
+
+		Expect(unlock()).To(Succeed())
+		Expect(FileLocked(path)).To(BeFalse())
+	})
+})